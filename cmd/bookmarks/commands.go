@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/lehmann314159/bookmarks/internal/api"
+	"github.com/lehmann314159/bookmarks/internal/backup"
+	"github.com/lehmann314159/bookmarks/internal/database"
+	"github.com/lehmann314159/bookmarks/internal/server"
+)
+
+// commands maps each command to the function running it with the
+// arguments that follow it.
+var commands = map[string]func(args []string) error{
+	"serve":       cmdServe,
+	"add":         cmdAdd,
+	"ls":          cmdList,
+	"search":      cmdSearch,
+	"import":      cmdImport,
+	"export":      cmdExport,
+	"check-links": cmdCheckLinks,
+	"backup":      cmdBackup,
+	"migrate":     cmdMigrate,
+	"tags merge":  cmdMergeTags,
+	"tui":         cmdTUI,
+}
+
+func cmdServe(args []string) error {
+	parseFlags(flag.NewFlagSet("serve", flag.ExitOnError), args)
+	// The server reads its settings from the environment
+	if *dataDir != "" {
+		os.Setenv("DATA_DIR", *dataDir)
+	}
+	server.Run()
+	return nil
+}
+
+func cmdAdd(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	tags := fs.String("tags", "", "comma-separated tags")
+	title := fs.String("title", "", "title (default fetched from the page)")
+	description := fs.String("description", "", "description")
+	later := fs.Bool("later", false, "add the page to the reading queue")
+	args = parseFlags(fs, args)
+	if len(args) != 1 {
+		return errors.New("usage: bookmarks add URL [--tags a,b] [--title T] [--description D] [--later]")
+	}
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	added, err := b.Add(api.AddRequest{
+		URL:         args[0],
+		Title:       *title,
+		Description: *description,
+		Tags:        api.SplitTags(*tags),
+		Later:       *later,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		return printJSON(added)
+	}
+	if added.Page == nil {
+		fmt.Printf("Bookmarked site %s\n", added.Site.Domain)
+	} else {
+		fmt.Printf("Added page %d: %s\n", added.Page.ID, added.Page.URL)
+	}
+	return nil
+}
+
+func cmdList(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	var tags stringList
+	fs.Var(&tags, "tag", "only pages with this tag or its subtags; may be repeated")
+	status := fs.String("status", "", "only pages in this read-later state")
+	sort := fs.String("sort", "", "order: newest, oldest, name, domain, visited or rating")
+	limit := fs.Int("limit", 0, "show at most this many pages")
+	offset := fs.Int("offset", 0, "skip this many pages first")
+	args = parseFlags(fs, args)
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	list, err := b.ListPages(api.ListOptions{
+		Query:  strings.Join(args, " "),
+		Tags:   tags,
+		Status: *status,
+		Sort:   *sort,
+		Limit:  *limit,
+		Offset: *offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		return printJSON(list)
+	}
+	printPages(list.Pages)
+	if len(list.Pages) < list.Total {
+		fmt.Printf("\nShowing %d-%d of %d pages\n", *offset+1, *offset+len(list.Pages), list.Total)
+	}
+	return nil
+}
+
+func cmdSearch(args []string) error {
+	args = parseFlags(flag.NewFlagSet("search", flag.ExitOnError), args)
+	if len(args) == 0 {
+		return errors.New("usage: bookmarks search QUERY")
+	}
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	results, err := b.Search(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		return printJSON(results)
+	}
+	if len(results.Sites) == 0 && len(results.Pages) == 0 {
+		fmt.Println("Nothing found")
+		return nil
+	}
+	if len(results.Sites) > 0 {
+		w := newTable()
+		fmt.Fprintln(w, "ID\tSITE\tNAME\tTAGS")
+		for _, s := range results.Sites {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.ID, s.Domain, truncate(s.Name, 50), strings.Join(s.Tags, ", "))
+		}
+		w.Flush()
+	}
+	if len(results.Pages) > 0 {
+		if len(results.Sites) > 0 {
+			fmt.Println()
+		}
+		printPages(results.Pages)
+	}
+	return nil
+}
+
+func cmdImport(args []string) error {
+	args = parseFlags(flag.NewFlagSet("import", flag.ExitOnError), args)
+	if len(args) != 1 {
+		return errors.New("usage: bookmarks import FILE")
+	}
+
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	result, err := b.Import(data)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		return printJSON(result)
+	}
+	fmt.Printf("Imported %d categories, %d sites and %d pages; skipped %d already bookmarked\n",
+		result.Categories, result.Sites, result.Pages, result.Skipped)
+	return nil
+}
+
+func cmdExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("o", "", "file to write (default standard output)")
+	parseFlags(fs, args)
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	doc, err := b.Export()
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func cmdCheckLinks(args []string) error {
+	fs := flag.NewFlagSet("check-links", flag.ExitOnError)
+	all := fs.Bool("all", false, "list every page, not only ones that failed to load")
+	concurrency := fs.Int("concurrency", 8, "pages fetched at a time")
+	parseFlags(fs, args)
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	results, err := b.CheckLinks(*concurrency)
+	if err != nil {
+		return err
+	}
+
+	shown := []api.LinkCheck{}
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" || r.Status >= 400 {
+			failed++
+		}
+		if *all || r.Error != "" || r.Status >= 400 {
+			shown = append(shown, r)
+		}
+	}
+	if *jsonOut {
+		return printJSON(shown)
+	}
+
+	if len(shown) > 0 {
+		w := newTable()
+		fmt.Fprintln(w, "ID\tSTATUS\tURL")
+		for _, r := range shown {
+			status := strconv.Itoa(r.Status)
+			if r.Error != "" {
+				status = truncate(r.Error, 60)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", r.PageID, status, r.URL)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+	broken := 0
+	for _, r := range results {
+		if r.Broken {
+			broken++
+		}
+	}
+	fmt.Printf("%d of %d pages failed to load, %d of them gone and flagged broken\n", failed, len(results), broken)
+	return nil
+}
+
+// cmdBackup takes a backup into DATA_DIR/backups, or on a server
+// downloads a new backup from it.
+func cmdBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "", "also copy the backup to this file (from a server, default its name in the current directory)")
+	parseFlags(fs, args)
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	var name, path string
+	switch b := b.(type) {
+	case *api.Client:
+		name, path, err = downloadBackup(b, *out)
+	case *local:
+		if !b.sqlite {
+			return errors.New("backups are only taken of SQLite databases")
+		}
+		var created *backup.Backup
+		created, err = backup.New(b.db, *dataDir, 0, 0).Create()
+		if err != nil {
+			return err
+		}
+		name, path = created.Name, filepath.Join(backup.Dir(*dataDir), created.Name)
+		if *out != "" {
+			err = copyFile(path, *out)
+			path = *out
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		return printJSON(map[string]string{"name": name, "path": path})
+	}
+	fmt.Printf("Backed up to %s\n", path)
+	return nil
+}
+
+func downloadBackup(c *api.Client, out string) (name, path string, err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(out), ".bookmarks-backup-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	name, err = c.Backup(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", err
+	}
+	path = out
+	if path == "" {
+		path = filepath.Base(name)
+	}
+	return name, path, os.Rename(tmp.Name(), path)
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+// cmdMigrate opens the local database, which applies any migrations it is
+// missing.
+func cmdMigrate(args []string) error {
+	parseFlags(flag.NewFlagSet("migrate", flag.ExitOnError), args)
+	if *serverURL != "" {
+		return errors.New("migrate works on the local database; the server migrates its own as it starts")
+	}
+
+	l, err := openLocal()
+	if err != nil {
+		return err
+	}
+	defer l.db.Close()
+
+	if *jsonOut {
+		return printJSON(map[string]int{"version": database.SchemaVersion()})
+	}
+	fmt.Printf("Database is at schema version %d\n", database.SchemaVersion())
+	return nil
+}
+
+func cmdMergeTags(args []string) error {
+	args = parseFlags(flag.NewFlagSet("tags merge", flag.ExitOnError), args)
+	if len(args) != 2 {
+		return errors.New("usage: bookmarks tags merge FROM INTO")
+	}
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	merge := api.TagMerge{From: args[0], Into: args[1]}
+	if err := b.MergeTags(merge); err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		return printJSON(merge)
+	}
+	fmt.Printf("Merged %s into %s\n", merge.From, merge.Into)
+	return nil
+}
+
+func printPages(pages []api.Page) {
+	w := newTable()
+	fmt.Fprintln(w, "ID\tTITLE\tURL\tTAGS")
+	for _, p := range pages {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", p.ID, truncate(p.Title, 50), p.URL, strings.Join(p.Tags, ", "))
+	}
+	w.Flush()
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// truncate shortens s to at most n characters for a table column.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
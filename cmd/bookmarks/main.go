@@ -0,0 +1,272 @@
+// Command bookmarks works with bookmarks from the terminal. It opens the
+// local database the way the server does, from DATA_DIR or DATABASE_URL,
+// or with -server (or BOOKMARKS_URL) talks to a running server's API.
+//
+//	bookmarks add https://go.dev/doc/effective_go --tags lang/go,docs
+//	bookmarks ls --tag lang/go --sort rating
+//	bookmarks -server http://nas:8080 search generics
+//	bookmarks import bookmarks.html
+//	bookmarks tags merge golang lang/go
+//
+// Results are printed as tables, or as JSON with -json. Run
+// "bookmarks help" for every command.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lehmann314159/bookmarks/internal/api"
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/database"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// cliActor is who changes made on the local database are attributed to
+// in the activity log.
+const cliActor = "cli"
+
+const usage = `Usage: bookmarks [-server URL] [-token TOKEN] [-data DIR] [-json] COMMAND [ARGS]
+
+Commands:
+  serve                          run the web server
+  add URL [--tags a,b] [--title T] [--description D] [--later]
+                                 bookmark a page, or a site given its address
+  ls [QUERY] [--tag T]... [--status S] [--sort S] [--limit N] [--offset N]
+                                 list pages
+  search QUERY                   search sites and pages
+  import FILE                    import an export or a browser's bookmarks
+                                 HTML file ("-" reads standard input)
+  export [-o FILE]               write every bookmark as JSON
+  check-links [--all] [--concurrency N]
+                                 fetch every page and flag the ones gone for good
+  backup [-o FILE]               back up the SQLite database
+  migrate                        bring the local database to the latest schema
+  tags merge FROM INTO           move everything tagged FROM to INTO
+  tui                            browse and edit bookmarks interactively
+
+Without -server the local database is used. Flags may also follow the
+command.
+`
+
+// Flags shared by every command.
+var (
+	serverURL = flag.String("server", os.Getenv("BOOKMARKS_URL"), "URL of a running server to use instead of the local database")
+	token     = flag.String("token", os.Getenv("ADMIN_TOKEN"), "the server's ADMIN_TOKEN, for backups")
+	dataDir   = flag.String("data", os.Getenv("DATA_DIR"), "directory holding bookmarks.db (default ./data)")
+	jsonOut   = flag.Bool("json", false, "print results as JSON")
+)
+
+// backend is where commands get and change bookmarks: the local database
+// or a server's API.
+type backend interface {
+	Add(req api.AddRequest) (*api.Added, error)
+	ListPages(opts api.ListOptions) (*api.PageList, error)
+	Search(query string) (*api.SearchResults, error)
+	Export() (*api.Export, error)
+	Import(data []byte) (*api.ImportResult, error)
+	CheckLinks(concurrency int) ([]api.LinkCheck, error)
+	MergeTags(merge api.TagMerge) error
+
+	// For the terminal UI
+	Categories() ([]api.Category, error)
+	Sites() ([]api.Site, error)
+	EditPage(id int64, edit api.Edit) (*api.Page, error)
+	EditSite(id int64, edit api.Edit) (*api.Site, error)
+	// VisitURL is the address to open a page at, after recording the
+	// visit if that happens here.
+	VisitURL(page api.Page) (string, error)
+}
+
+var (
+	_ backend = (*api.Client)(nil)
+	_ backend = (*local)(nil)
+)
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	if command == "tags" {
+		if len(args) == 0 || args[0] != "merge" {
+			fatalf("Usage: bookmarks tags merge FROM INTO")
+		}
+		command, args = "tags merge", args[1:]
+	}
+
+	if command == "help" {
+		fmt.Print(usage)
+		return
+	}
+	cmd, ok := commands[command]
+	if !ok {
+		fatalf("Unknown command %q; run \"bookmarks help\" for the list", command)
+	}
+	if err := cmd(args); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// openBackend connects to the server given by -server, or else opens the
+// local database. The returned function releases it.
+func openBackend() (backend, func(), error) {
+	if *serverURL != "" {
+		return api.NewClient(*serverURL, *token), func() {}, nil
+	}
+	l, err := openLocal()
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, func() { l.db.Close() }, nil
+}
+
+// local runs commands directly against the database.
+type local struct {
+	repo repository.Store
+	db   *sql.DB
+	// sqlite is set unless the database is PostgreSQL, which the backup
+	// command cannot copy.
+	sqlite bool
+}
+
+// openLocal opens the database the server would: PostgreSQL when
+// DATABASE_URL is set, unless STORAGE says sqlite, and otherwise
+// bookmarks.db in the data directory.
+func openLocal() (*local, error) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL != "" && os.Getenv("STORAGE") != "sqlite" {
+		db, err := database.NewPostgres(databaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		return &local{repo: repository.NewPostgres(db).As(cliActor), db: db}, nil
+	}
+	db, err := database.New(*dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return &local{repo: repository.New(db).As(cliActor), db: db, sqlite: true}, nil
+}
+
+func (l *local) Add(req api.AddRequest) (*api.Added, error) {
+	site, page, err := api.Add(l.repo, req)
+	if err != nil {
+		return nil, err
+	}
+	added := &api.Added{Site: api.NewSite(*site)}
+	if page != nil {
+		p := api.NewPage(*page)
+		added.Page = &p
+	}
+	return added, nil
+}
+
+func (l *local) ListPages(opts api.ListOptions) (*api.PageList, error) {
+	return api.ListPages(l.repo, opts)
+}
+
+func (l *local) Search(query string) (*api.SearchResults, error) {
+	return api.Search(l.repo, query)
+}
+
+func (l *local) Export() (*api.Export, error) {
+	return api.NewExport(l.repo)
+}
+
+func (l *local) Import(data []byte) (*api.ImportResult, error) {
+	doc, err := api.ParseImport(data)
+	if err != nil {
+		return nil, err
+	}
+	return api.Import(l.repo, doc)
+}
+
+// CheckLinks looks broken pages up in the Wayback Machine at WAYBACK_URL,
+// as the server does.
+func (l *local) CheckLinks(concurrency int) ([]api.LinkCheck, error) {
+	waybackURL := os.Getenv("WAYBACK_URL")
+	if waybackURL == "" {
+		waybackURL = archive.DefaultWaybackURL
+	}
+	return api.CheckLinks(l.repo, archive.NewWayback(waybackURL), concurrency)
+}
+
+func (l *local) MergeTags(merge api.TagMerge) error {
+	return api.MergeTags(l.repo, merge)
+}
+
+func (l *local) Categories() ([]api.Category, error) {
+	return api.ListCategories(l.repo)
+}
+
+func (l *local) Sites() ([]api.Site, error) {
+	return api.ListSites(l.repo)
+}
+
+func (l *local) EditPage(id int64, edit api.Edit) (*api.Page, error) {
+	page, err := api.EditPage(l.repo, id, edit)
+	if err != nil {
+		return nil, err
+	}
+	p := api.NewPage(*page)
+	return &p, nil
+}
+
+func (l *local) EditSite(id int64, edit api.Edit) (*api.Site, error) {
+	site, err := api.EditSite(l.repo, id, edit)
+	if err != nil {
+		return nil, err
+	}
+	s := api.NewSite(*site)
+	return &s, nil
+}
+
+func (l *local) VisitURL(page api.Page) (string, error) {
+	return page.URL, l.repo.MarkPageVisited(page.ID)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "bookmarks: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// parseFlags parses a command's flags, which may come before, after or
+// between its arguments, and returns the arguments. The flags shared by
+// every command are accepted too.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			os.Exit(2)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
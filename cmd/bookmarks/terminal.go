@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// terminal puts the controlling terminal in raw mode on the alternate
+// screen and reads keys from it.
+type terminal struct {
+	in, out *os.File
+	state   *term.State
+	pending []key // read but not yet returned by readKey
+}
+
+func openTerminal() (*terminal, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, errors.New("the terminal UI needs an interactive terminal")
+	}
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	t := &terminal{in: os.Stdin, out: os.Stdout, state: state}
+	// Alternate screen, hidden cursor
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	return t, nil
+}
+
+// close restores the screen and the terminal's previous mode.
+func (t *terminal) close() {
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	term.Restore(int(t.in.Fd()), t.state)
+}
+
+// size is the terminal's width and height, with a fallback for terminals
+// that do not say.
+func (t *terminal) size() (int, int) {
+	width, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// draw replaces the screen with lines, each cut to width.
+func (t *terminal) draw(lines []string) {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	t.out.WriteString(b.String())
+}
+
+// key is a special key such as "up" or "ctrl-a", or else a typed
+// character in r.
+type key struct {
+	name string
+	r    rune
+}
+
+func (t *terminal) readKey() (key, error) {
+	for len(t.pending) == 0 {
+		buf := make([]byte, 256)
+		n, err := t.in.Read(buf)
+		if err != nil {
+			return key{}, err
+		}
+		t.pending = parseKeys(buf[:n])
+	}
+	k := t.pending[0]
+	t.pending = t.pending[1:]
+	return k, nil
+}
+
+// escapeKeys names the final byte, or number, of the escape sequences
+// terminals send for special keys.
+var escapeKeys = map[string]string{
+	"A": "up", "B": "down", "C": "right", "D": "left",
+	"H": "home", "F": "end", "1~": "home", "4~": "end",
+	"5~": "pgup", "6~": "pgdown", "3~": "delete",
+}
+
+// parseKeys splits what one read returned into keys. A pasted URL arrives
+// as many characters at once.
+func parseKeys(buf []byte) []key {
+	var keys []key
+	for i := 0; i < len(buf); {
+		b := buf[i]
+		switch {
+		case b == 0x1b && i+2 < len(buf) && (buf[i+1] == '[' || buf[i+1] == 'O'):
+			// CSI or SS3 sequence, ending in a byte from @ to ~
+			j := i + 2
+			for j < len(buf) && (buf[j] < 0x40 || buf[j] > 0x7e) {
+				j++
+			}
+			if j == len(buf) {
+				j--
+			}
+			seq := string(buf[i+2 : j+1])
+			if name, ok := escapeKeys[seq]; ok {
+				keys = append(keys, key{name: name})
+			} else if name, ok := escapeKeys[seq[len(seq)-1:]]; ok {
+				// With modifiers, such as ESC [1;5A
+				keys = append(keys, key{name: name})
+			}
+			i = j + 1
+		case b == 0x1b:
+			keys = append(keys, key{name: "esc"})
+			i++
+		case b == '\r' || b == '\n':
+			keys = append(keys, key{name: "enter"})
+			i++
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, key{name: "backspace"})
+			i++
+		case b == '\t':
+			keys = append(keys, key{name: "tab"})
+			i++
+		case b < 0x20:
+			keys = append(keys, key{name: "ctrl-" + string(rune('a'+b-1))})
+			i++
+		default:
+			r, size := utf8.DecodeRune(buf[i:])
+			keys = append(keys, key{r: r})
+			i += size
+		}
+	}
+	return keys
+}
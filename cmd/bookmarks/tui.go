@@ -0,0 +1,615 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lehmann314159/bookmarks/internal/api"
+)
+
+// The levels the terminal UI moves through, from categories down to the
+// pages of one site.
+const (
+	levelCategories = iota
+	levelSites
+	levelPages
+)
+
+// Rows of the category level that are not categories.
+const (
+	allSites      = -1
+	uncategorized = -2
+)
+
+const tuiHelp = "enter open  ← back  type to filter  ^A add  ^E edit  ^T tags  ^O browser  ^R reload  ^Q quit"
+
+// browser is the state of the terminal UI.
+type browser struct {
+	b    backend
+	term *terminal
+
+	categories []api.Category
+	parents    map[int64]*int64 // each category's parent, by ID
+	sites      []api.Site
+	pages      []api.Page // of site
+
+	level    int
+	pos      [3]position
+	category int // row index chosen on the category level
+	site     *api.Site
+
+	status string
+	prompt *prompt
+	quit   bool
+}
+
+// position is the filter typed on a level and the highlighted row, which
+// are kept when moving down a level and back.
+type position struct {
+	filter string
+	cursor int
+	top    int // first row on screen
+}
+
+// row is one line of a level. index points into the categories, sites or
+// pages, or is allSites or uncategorized.
+type row struct {
+	text   string
+	detail string // shown dimmed after text
+	index  int
+}
+
+// prompt reads a line of text at the bottom of the screen and passes it
+// to done.
+type prompt struct {
+	label string
+	value string
+	done  func(value string)
+}
+
+func cmdTUI(args []string) error {
+	parseFlags(flag.NewFlagSet("tui", flag.ExitOnError), args)
+
+	b, closeBackend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+
+	ui := &browser{b: b}
+	if err := ui.load(); err != nil {
+		return err
+	}
+	ui.term, err = openTerminal()
+	if err != nil {
+		return err
+	}
+	defer ui.term.close()
+	return ui.run()
+}
+
+func (ui *browser) run() error {
+	for !ui.quit {
+		ui.render()
+		k, err := ui.term.readKey()
+		if err != nil {
+			return err
+		}
+		ui.status = ""
+		if ui.prompt != nil {
+			ui.promptKey(k)
+		} else {
+			ui.key(k)
+		}
+	}
+	return nil
+}
+
+// load fetches the categories and sites, and the pages of the site being
+// shown.
+func (ui *browser) load() error {
+	categories, err := ui.b.Categories()
+	if err != nil {
+		return err
+	}
+	sites, err := ui.b.Sites()
+	if err != nil {
+		return err
+	}
+	ui.categories, ui.sites = categories, sites
+	ui.parents = map[int64]*int64{}
+	for _, c := range categories {
+		ui.parents[c.ID] = c.ParentID
+	}
+	if ui.category >= len(categories) {
+		// The category chosen is gone
+		ui.category = allSites
+	}
+	if ui.site != nil {
+		return ui.loadPages()
+	}
+	return nil
+}
+
+func (ui *browser) loadPages() error {
+	list, err := ui.b.ListPages(api.ListOptions{SiteID: ui.site.ID})
+	if err != nil {
+		return err
+	}
+	ui.pages = list.Pages
+	return nil
+}
+
+func (ui *browser) key(k key) {
+	pos := &ui.pos[ui.level]
+	switch k.name {
+	case "":
+		if unicode.IsPrint(k.r) {
+			pos.filter += string(k.r)
+			pos.cursor, pos.top = 0, 0
+		}
+	case "backspace":
+		if pos.filter == "" {
+			ui.back()
+		} else {
+			_, size := utf8.DecodeLastRuneInString(pos.filter)
+			pos.filter = pos.filter[:len(pos.filter)-size]
+			pos.cursor, pos.top = 0, 0
+		}
+	case "ctrl-u":
+		pos.filter, pos.cursor, pos.top = "", 0, 0
+	case "esc":
+		if pos.filter != "" {
+			pos.filter, pos.cursor, pos.top = "", 0, 0
+		} else {
+			ui.back()
+		}
+	case "left":
+		ui.back()
+	case "up", "ctrl-p":
+		pos.cursor--
+	case "down", "ctrl-n":
+		pos.cursor++
+	case "pgup":
+		pos.cursor -= ui.listHeight()
+	case "pgdown":
+		pos.cursor += ui.listHeight()
+	case "home":
+		pos.cursor = 0
+	case "end":
+		pos.cursor = len(ui.rows()) - 1
+	case "enter", "right":
+		ui.choose()
+	case "ctrl-o":
+		ui.openInBrowser()
+	case "ctrl-a":
+		ui.add()
+	case "ctrl-e":
+		ui.edit(true)
+	case "ctrl-t":
+		ui.edit(false)
+	case "ctrl-r":
+		if err := ui.load(); err != nil {
+			ui.status = "Reload failed: " + err.Error()
+		} else {
+			ui.status = "Reloaded"
+		}
+	case "ctrl-c", "ctrl-q":
+		ui.quit = true
+	}
+}
+
+func (ui *browser) promptKey(k key) {
+	p := ui.prompt
+	switch k.name {
+	case "":
+		if unicode.IsPrint(k.r) {
+			p.value += string(k.r)
+		}
+	case "backspace":
+		_, size := utf8.DecodeLastRuneInString(p.value)
+		p.value = p.value[:len(p.value)-size]
+	case "ctrl-u":
+		p.value = ""
+	case "esc", "ctrl-c":
+		ui.prompt = nil
+		ui.status = "Cancelled"
+	case "enter":
+		// done may ask for the next value
+		ui.prompt = nil
+		p.done(strings.TrimSpace(p.value))
+	}
+}
+
+// back returns to the level above.
+func (ui *browser) back() {
+	if ui.level > levelCategories {
+		ui.level--
+	}
+	if ui.level < levelPages {
+		ui.site, ui.pages = nil, nil
+	}
+}
+
+// selected is the highlighted row, if the level has any rows.
+func (ui *browser) selected() (row, bool) {
+	rows := ui.rows()
+	if len(rows) == 0 {
+		return row{}, false
+	}
+	return rows[max(0, min(ui.pos[ui.level].cursor, len(rows)-1))], true
+}
+
+// choose moves down to the highlighted category or site, or opens the
+// highlighted page.
+func (ui *browser) choose() {
+	r, ok := ui.selected()
+	if !ok {
+		return
+	}
+	switch ui.level {
+	case levelCategories:
+		ui.category = r.index
+		ui.level = levelSites
+		ui.pos[levelSites] = position{}
+	case levelSites:
+		site := ui.sites[r.index]
+		ui.site = &site
+		if err := ui.loadPages(); err != nil {
+			ui.site = nil
+			ui.status = err.Error()
+			return
+		}
+		ui.level = levelPages
+		ui.pos[levelPages] = position{}
+	case levelPages:
+		ui.openInBrowser()
+	}
+}
+
+func (ui *browser) openInBrowser() {
+	r, ok := ui.selected()
+	if !ok {
+		return
+	}
+	var url string
+	switch ui.level {
+	case levelCategories:
+		return
+	case levelSites:
+		url = "https://" + ui.sites[r.index].Domain
+	case levelPages:
+		var err error
+		if url, err = ui.b.VisitURL(ui.pages[r.index]); err != nil {
+			ui.status = err.Error()
+			return
+		}
+	}
+	if err := openBrowser(url); err != nil {
+		ui.status = "Could not open a browser: " + err.Error()
+		return
+	}
+	ui.status = "Opened " + url
+}
+
+// add asks for a URL and its tags and bookmarks it.
+func (ui *browser) add() {
+	ui.prompt = &prompt{label: "Add URL", done: func(url string) {
+		if url == "" {
+			return
+		}
+		ui.prompt = &prompt{label: "Tags (comma-separated)", done: func(tags string) {
+			ui.status = "Adding " + url + "..."
+			ui.render()
+			added, err := ui.b.Add(api.AddRequest{URL: url, Tags: api.SplitTags(tags)})
+			if err != nil {
+				ui.status = err.Error()
+				return
+			}
+			if err := ui.load(); err != nil {
+				ui.status = err.Error()
+				return
+			}
+			if added.Page != nil {
+				ui.status = fmt.Sprintf("Added %s to %s", added.Page.URL, added.Site.Domain)
+			} else {
+				ui.status = "Added " + added.Site.Domain
+			}
+		}}
+	}}
+}
+
+// edit asks for new tags for the highlighted site or page and, with
+// title, for its title or name first.
+func (ui *browser) edit(title bool) {
+	r, ok := ui.selected()
+	if !ok || ui.level == levelCategories {
+		ui.status = "Choose a site or page to edit"
+		return
+	}
+
+	var edit api.Edit
+	var label string
+	if ui.level == levelSites {
+		site := ui.sites[r.index]
+		edit, label = api.Edit{Title: site.Name, Tags: site.Tags}, "Name"
+	} else {
+		page := ui.pages[r.index]
+		edit, label = api.Edit{Title: page.Title, Tags: page.Tags}, "Title"
+	}
+
+	askTags := func(value string) {
+		edit.Title = value
+		ui.prompt = &prompt{label: "Tags (comma-separated)", value: strings.Join(edit.Tags, ", "), done: func(tags string) {
+			edit.Tags = api.SplitTags(tags)
+			ui.save(r.index, edit)
+		}}
+	}
+	if title {
+		ui.prompt = &prompt{label: label, value: edit.Title, done: askTags}
+	} else {
+		askTags(edit.Title)
+	}
+}
+
+func (ui *browser) save(index int, edit api.Edit) {
+	switch ui.level {
+	case levelSites:
+		site, err := ui.b.EditSite(ui.sites[index].ID, edit)
+		if err != nil {
+			ui.status = err.Error()
+			return
+		}
+		ui.sites[index] = *site
+		ui.status = "Saved " + site.Domain
+	case levelPages:
+		page, err := ui.b.EditPage(ui.pages[index].ID, edit)
+		if err != nil {
+			ui.status = err.Error()
+			return
+		}
+		ui.pages[index] = *page
+		ui.status = "Saved " + page.URL
+	}
+}
+
+// rows lists the current level, narrowed by its filter. Every word of the
+// filter must appear, in any case.
+func (ui *browser) rows() []row {
+	var all []row
+	switch ui.level {
+	case levelCategories:
+		all = ui.categoryRows()
+	case levelSites:
+		for i, s := range ui.sites {
+			if !ui.inCategory(s) {
+				continue
+			}
+			text := s.Domain
+			if s.Name != "" {
+				text += " - " + s.Name
+			}
+			all = append(all, row{text: text, detail: hashTags(s.Tags), index: i})
+		}
+	case levelPages:
+		for i, p := range ui.pages {
+			r := row{text: p.Title, detail: strings.TrimSpace(p.URL + "  " + hashTags(p.Tags)), index: i}
+			if p.Title == "" {
+				r.text, r.detail = p.URL, hashTags(p.Tags)
+			}
+			all = append(all, r)
+		}
+	}
+
+	words := strings.Fields(strings.ToLower(ui.pos[ui.level].filter))
+	var rows []row
+	for _, r := range all {
+		haystack := strings.ToLower(r.text + " " + r.detail)
+		match := true
+		for _, w := range words {
+			if !strings.Contains(haystack, w) {
+				match = false
+				break
+			}
+		}
+		if match {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// categoryRows lists every site, the sites without a category and then
+// the category tree, each with the number of sites in it and below it.
+func (ui *browser) categoryRows() []row {
+	rows := []row{{text: "All sites", detail: plural(len(ui.sites), "site"), index: allSites}}
+	none := 0
+	for _, s := range ui.sites {
+		if s.CategoryID == nil {
+			none++
+		}
+	}
+	if none > 0 {
+		rows = append(rows, row{text: "Uncategorized", detail: plural(none, "site"), index: uncategorized})
+	}
+
+	depth := map[int64]int{}
+	for i, c := range ui.categories {
+		if c.ParentID != nil {
+			depth[c.ID] = depth[*c.ParentID] + 1
+		}
+		count := 0
+		for _, s := range ui.sites {
+			if s.CategoryID != nil && ui.isWithin(*s.CategoryID, c.ID) {
+				count++
+			}
+		}
+		rows = append(rows, row{text: strings.Repeat("  ", depth[c.ID]) + c.Name, detail: plural(count, "site"), index: i})
+	}
+	return rows
+}
+
+// inCategory reports whether a site belongs on the sites level for the
+// chosen category row, counting subcategories.
+func (ui *browser) inCategory(s api.Site) bool {
+	switch ui.category {
+	case allSites:
+		return true
+	case uncategorized:
+		return s.CategoryID == nil
+	}
+	return s.CategoryID != nil && ui.isWithin(*s.CategoryID, ui.categories[ui.category].ID)
+}
+
+// isWithin reports whether category id is ancestor or one of its
+// descendants. The walk up is bounded in case of a cycle.
+func (ui *browser) isWithin(id, ancestor int64) bool {
+	for seen := 0; seen <= len(ui.categories); seen++ {
+		if id == ancestor {
+			return true
+		}
+		parent := ui.parents[id]
+		if parent == nil {
+			return false
+		}
+		id = *parent
+	}
+	return false
+}
+
+// listHeight is how many rows fit between the header lines and the status
+// line.
+func (ui *browser) listHeight() int {
+	_, height := ui.term.size()
+	return max(1, height-3)
+}
+
+func (ui *browser) render() {
+	width, _ := ui.term.size()
+	height := ui.listHeight()
+	rows := ui.rows()
+
+	// Keep the cursor on a row and on screen
+	pos := &ui.pos[ui.level]
+	pos.cursor = max(0, min(pos.cursor, len(rows)-1))
+	if pos.cursor < pos.top {
+		pos.top = pos.cursor
+	}
+	if pos.cursor >= pos.top+height {
+		pos.top = pos.cursor - height + 1
+	}
+
+	lines := []string{"\x1b[7m" + pad(" "+ui.breadcrumb(), width) + "\x1b[0m"}
+	if ui.prompt != nil {
+		lines = append(lines, truncate(ui.prompt.label+": "+ui.prompt.value+"▏", width))
+	} else if pos.filter != "" {
+		lines = append(lines, truncate(fmt.Sprintf("Filter: %s▏  (%d)", pos.filter, len(rows)), width))
+	} else {
+		lines = append(lines, "\x1b[2m"+truncate("Type to filter", width)+"\x1b[0m")
+	}
+
+	for i := pos.top; i < pos.top+height; i++ {
+		if i >= len(rows) {
+			lines = append(lines, "")
+			continue
+		}
+		lines = append(lines, formatRow(rows[i], width, i == pos.cursor))
+	}
+	if len(rows) == 0 {
+		lines[2] = "  Nothing here"
+	}
+
+	if ui.status != "" {
+		lines = append(lines, truncate(ui.status, width))
+	} else {
+		lines = append(lines, "\x1b[2m"+truncate(tuiHelp, width)+"\x1b[0m")
+	}
+	ui.term.draw(lines)
+}
+
+func (ui *browser) breadcrumb() string {
+	parts := []string{"Bookmarks"}
+	if ui.level >= levelSites {
+		switch ui.category {
+		case allSites:
+			parts = append(parts, "All sites")
+		case uncategorized:
+			parts = append(parts, "Uncategorized")
+		default:
+			parts = append(parts, ui.categories[ui.category].Name)
+		}
+	}
+	if ui.level == levelPages {
+		parts = append(parts, ui.site.Domain)
+	}
+	return strings.Join(parts, " › ")
+}
+
+// formatRow lays out a row in width columns, dimming its detail and
+// highlighting it when it is under the cursor.
+func formatRow(r row, width int, current bool) string {
+	text := truncate("  "+r.text, width)
+	line := text
+	if room := width - utf8.RuneCountInString(text) - 2; room > 1 && r.detail != "" {
+		line += "  \x1b[2m" + truncate(r.detail, room) + "\x1b[22m"
+	}
+	if current {
+		return "\x1b[7m" + pad(line, width+len(line)-len(stripDim(line))) + "\x1b[0m"
+	}
+	return line
+}
+
+// stripDim removes the dimming codes formatRow adds, for measuring.
+func stripDim(s string) string {
+	return strings.NewReplacer("\x1b[2m", "", "\x1b[22m", "").Replace(s)
+}
+
+// pad fills s with spaces to width characters.
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+func hashTags(tags []string) string {
+	var out []string
+	for _, t := range tags {
+		out = append(out, "#"+t)
+	}
+	return strings.Join(out, " ")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// openBrowser opens url with $BROWSER, or else the system's handler for
+// links.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch {
+	case os.Getenv("BROWSER") != "":
+		cmd = exec.Command(os.Getenv("BROWSER"), url)
+	case runtime.GOOS == "darwin":
+		cmd = exec.Command("open", url)
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
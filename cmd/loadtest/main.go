@@ -0,0 +1,204 @@
+// Command loadtest runs concurrent writers and readers against an SQLite
+// database through the repository and reports any errors, in particular
+// "database is locked".
+//
+//	go run ./cmd/loadtest -writers 16 -readers 16 -duration 10s
+//
+// Without -data it works on a fresh database in a temporary directory.
+// Writers add sites and pages, tag and rate them and move some to the
+// trash; readers list, count and search. It exits with status 1 if any
+// operation failed.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/lehmann314159/bookmarks/internal/database"
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+func main() {
+	dataDir := flag.String("data", "", "directory holding bookmarks.db (default a temporary one)")
+	writers := flag.Int("writers", 8, "number of concurrent writers")
+	readers := flag.Int("readers", 8, "number of concurrent readers")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run")
+	flag.Parse()
+
+	if *dataDir == "" {
+		dir, err := os.MkdirTemp("", "bookmarks-loadtest")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		*dataDir = dir
+	}
+
+	db, err := database.New(*dataDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+	writer, err := database.NewWriter(*dataDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer writer.Close()
+	repo := repository.NewWithWriter(db, writer)
+
+	var (
+		stop    atomic.Bool
+		wg      sync.WaitGroup
+		results = make(chan result, *writers+*readers)
+	)
+	for i := 0; i < *writers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			results <- run(&stop, true, func(n int) error { return write(repo, worker, n) })
+		}(i)
+	}
+	for i := 0; i < *readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- run(&stop, false, func(n int) error { return read(repo, n) })
+		}()
+	}
+
+	fmt.Printf("%d writers, %d readers for %s\n\n", *writers, *readers, *duration)
+	time.Sleep(*duration)
+	stop.Store(true)
+	wg.Wait()
+	close(results)
+
+	var writes, reads result
+	for r := range results {
+		if r.writer {
+			writes.merge(r)
+		} else {
+			reads.merge(r)
+		}
+	}
+	writes.print("writes", *duration)
+	reads.print("reads", *duration)
+
+	if writes.errors+reads.errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// result counts what one worker, or a group of them, did.
+type result struct {
+	writer    bool
+	ops       int
+	errors    int
+	locked    int // errors that were SQLITE_BUSY or SQLITE_LOCKED
+	firstErr  error
+	latencies []time.Duration
+}
+
+func (r *result) merge(other result) {
+	r.ops += other.ops
+	r.errors += other.errors
+	r.locked += other.locked
+	if r.firstErr == nil {
+		r.firstErr = other.firstErr
+	}
+	r.latencies = append(r.latencies, other.latencies...)
+}
+
+func (r *result) print(name string, duration time.Duration) {
+	slices.Sort(r.latencies)
+	percentile := func(p int) time.Duration {
+		if len(r.latencies) == 0 {
+			return 0
+		}
+		return r.latencies[(len(r.latencies)-1)*p/100]
+	}
+	fmt.Printf("%-7s %7d ops %8.0f/s  p50 %-10s p99 %-10s max %-10s errors %d (locked %d)\n",
+		name, r.ops, float64(r.ops)/duration.Seconds(),
+		percentile(50), percentile(99), percentile(100), r.errors, r.locked)
+	if r.firstErr != nil {
+		fmt.Printf("        first error: %v\n", r.firstErr)
+	}
+}
+
+// run calls op until stop is set, timing each call.
+func run(stop *atomic.Bool, writer bool, op func(n int) error) result {
+	r := result{writer: writer}
+	for n := 0; !stop.Load(); n++ {
+		start := time.Now()
+		err := op(n)
+		r.latencies = append(r.latencies, time.Since(start))
+		r.ops++
+		if err == nil {
+			continue
+		}
+		r.errors++
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+			r.locked++
+		}
+		if r.firstErr == nil {
+			r.firstErr = err
+		}
+	}
+	return r
+}
+
+// write adds a site with a page, tags and rates the page, and sends every
+// fifth one to the trash. Each writer uses its own tags, so that two
+// creating the same tag at once do not fail for reasons other than locking.
+func write(repo *repository.Repository, worker, n int) error {
+	siteID, err := repo.CreateSite(nil, fmt.Sprintf("w%d-%d.example.com", worker, n), "", "")
+	if err != nil {
+		return err
+	}
+	pageID, err := repo.CreatePage(siteID, "/", fmt.Sprintf("Page %d of writer %d", n, worker), "")
+	if err != nil {
+		return err
+	}
+	tagID, err := repo.GetOrCreateTag(fmt.Sprintf("w%d/tag%d", worker, n%20))
+	if err != nil {
+		return err
+	}
+	if err := repo.AddPageTag(pageID, tagID); err != nil {
+		return err
+	}
+	if err := repo.SetPageRating(pageID, n%models.MaxRating+1); err != nil {
+		return err
+	}
+	if n%5 == 0 {
+		return repo.DeletePage(pageID)
+	}
+	return nil
+}
+
+// read runs one of the queries behind the list pages and search in turn.
+func read(repo *repository.Repository, n int) error {
+	var err error
+	switch n % 5 {
+	case 0:
+		_, err = repo.GetPages(repository.PageFilter{Limit: 50})
+	case 1:
+		_, err = repo.CountPages(repository.PageFilter{})
+	case 2:
+		_, err = repo.GetSites(repository.SiteFilter{Sort: repository.SortNewest, Limit: 50})
+	case 3:
+		_, err = repo.ListTags(repository.TagListFilter{Limit: 50})
+	case 4:
+		_, _, err = repo.Search("writer")
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+// Command restore replaces bookmarks.db with a backup taken by the server.
+// Stop the server first.
+//
+//	DATA_DIR=/data restore bookmarks-20261018T030000Z.db
+//
+// The backup may be given by path or by name within DATA_DIR/backups, and
+// -list shows the backups there. The database being replaced is itself
+// backed up before it is overwritten.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/lehmann314159/bookmarks/internal/backup"
+)
+
+func main() {
+	dataDir := flag.String("data", os.Getenv("DATA_DIR"), "directory holding bookmarks.db (default ./data)")
+	listOnly := flag.Bool("list", false, "list the backups in DATA_DIR/backups and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-data DIR] BACKUP\n       %s [-data DIR] -list\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := backup.Dir(*dataDir)
+	if *listOnly {
+		backups, err := backup.New(nil, *dataDir, 0, 0).List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, b := range backups {
+			fmt.Printf("%s  %s  %d bytes\n", b.Name, b.CreatedAt.Local().Format("2006-01-02 15:04"), b.Size)
+		}
+		return
+	}
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	path := flag.Arg(0)
+	if _, err := os.Stat(path); os.IsNotExist(err) && filepath.Base(path) == path {
+		path = filepath.Join(dir, path)
+	}
+
+	saved, err := backup.Restore(*dataDir, path)
+	if saved != "" {
+		fmt.Printf("The previous database was saved as %s\n", filepath.Join(dir, saved))
+	}
+	if err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	fmt.Printf("Restored %s\n", path)
+}
@@ -3,3 +3,17 @@ module github.com/lehmann314159/bookmarks
 go 1.23
 
 require github.com/mattn/go-sqlite3 v1.14.33
+
+require (
+	github.com/lib/pq v1.10.9
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/yuin/goldmark v1.7.13
+	golang.org/x/net v0.33.0
+	golang.org/x/term v0.27.0
+)
+
+require (
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+)
@@ -0,0 +1,169 @@
+// Package api holds the JSON representation of bookmarks and the operations
+// behind the /api endpoints, so that the server and the command-line client
+// working on a local database behave the same. Client talks to the
+// endpoints of a running server.
+package api
+
+import (
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+)
+
+// Export is every category, site and page, with their tags and notes, as
+// served by /export and read back by Import.
+type Export struct {
+	ExportedAt time.Time  `json:"exported_at"`
+	Categories []Category `json:"categories"`
+	Sites      []Site     `json:"sites"`
+	Pages      []Page     `json:"pages"`
+}
+
+type Category struct {
+	ID          int64  `json:"id"`
+	ParentID    *int64 `json:"parent_id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Site struct {
+	ID          int64     `json:"id"`
+	CategoryID  *int64    `json:"category_id,omitempty"`
+	Domain      string    `json:"domain"`
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Note        string    `json:"note,omitempty"`
+	Favorite    bool      `json:"favorite,omitempty"`
+	Pinned      bool      `json:"pinned,omitempty"`
+	Rating      int       `json:"rating,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Page struct {
+	ID          int64     `json:"id"`
+	SiteID      int64     `json:"site_id"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	ReadStatus  string    `json:"read_status,omitempty"`
+	Note        string    `json:"note,omitempty"`
+	Favorite    bool      `json:"favorite,omitempty"`
+	Pinned      bool      `json:"pinned,omitempty"`
+	Rating      int       `json:"rating,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PageList is one part of a filtered list of pages, and how many pages
+// the whole list has.
+type PageList struct {
+	Total int    `json:"total"`
+	Pages []Page `json:"pages"`
+}
+
+// SearchResults are the sites and pages matching a search.
+type SearchResults struct {
+	Sites []Site `json:"sites"`
+	Pages []Page `json:"pages"`
+}
+
+// AddRequest bookmarks a URL. The address of a whole site adds the site
+// and puts the tags on it; anything else adds a page to its site.
+type AddRequest struct {
+	URL         string   `json:"url"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Later puts a new page in the reading queue.
+	Later bool `json:"later,omitempty"`
+}
+
+// Added is the site a URL was added to and, unless the URL was the site
+// itself, the new page.
+type Added struct {
+	Site Site  `json:"site"`
+	Page *Page `json:"page,omitempty"`
+}
+
+// ImportResult counts what an import added. Sites and pages that were
+// already bookmarked are skipped.
+type ImportResult struct {
+	Categories int `json:"categories"`
+	Sites      int `json:"sites"`
+	Pages      int `json:"pages"`
+	Skipped    int `json:"skipped"`
+}
+
+// LinkCheck is the outcome of fetching one page. Status is the HTTP
+// status, or 0 when the request itself failed with Error. Broken says the
+// page is gone for good, which not every failure shows.
+type LinkCheck struct {
+	PageID int64  `json:"page_id"`
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Broken bool   `json:"broken"`
+}
+
+// Edit changes the title of a page, or the name of a site, and replaces
+// its tags.
+type Edit struct {
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+}
+
+// TagMerge moves everything tagged From onto Into and deletes From. Both
+// are tag names or aliases.
+type TagMerge struct {
+	From string `json:"from"`
+	Into string `json:"into"`
+}
+
+func NewCategory(c models.Category) Category {
+	return Category{
+		ID:          c.ID,
+		ParentID:    c.ParentID,
+		Name:        c.Name,
+		Description: c.Description,
+	}
+}
+
+func NewSite(s models.Site) Site {
+	return Site{
+		ID:          s.ID,
+		CategoryID:  s.CategoryID,
+		Domain:      s.Domain,
+		Name:        s.Name,
+		Description: s.Description,
+		Tags:        tagNames(s.Tags),
+		Favorite:    s.Favorite,
+		Pinned:      s.PinnedAt != nil,
+		Rating:      s.Rating,
+		CreatedAt:   s.CreatedAt,
+	}
+}
+
+func NewPage(p models.Page) Page {
+	return Page{
+		ID:          p.ID,
+		SiteID:      p.SiteID,
+		URL:         p.URL(),
+		Title:       p.Title,
+		Description: p.Description,
+		Tags:        tagNames(p.Tags),
+		ReadStatus:  p.ReadStatus,
+		Favorite:    p.Favorite,
+		Pinned:      p.PinnedAt != nil,
+		Rating:      p.Rating,
+		CreatedAt:   p.CreatedAt,
+	}
+}
+
+func tagNames(tags []models.Tag) []string {
+	var names []string
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	return names
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API of a running server at BaseURL, such as
+// "http://localhost:8080". Token is the server's ADMIN_TOKEN, needed only
+// for backups when the server sets one.
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		// Checking links waits for every page to be fetched
+		HTTP: &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+// do sends a request and decodes a JSON response into out, unless out is
+// nil. A response other than 2xx becomes an error carrying its text.
+func (c *Client) do(method, path string, body io.Reader, contentType string, out interface{}) error {
+	resp, err := c.send(method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) send(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.SetBasicAuth("admin", c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		msg := strings.TrimSpace(string(text))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, msg)
+	}
+	return resp, nil
+}
+
+func (c *Client) doJSON(method, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.do(method, path, bytes.NewReader(body), "application/json", out)
+}
+
+func (c *Client) Add(req AddRequest) (*Added, error) {
+	var added Added
+	return &added, c.doJSON(http.MethodPost, "/api/pages", req, &added)
+}
+
+func (c *Client) ListPages(opts ListOptions) (*PageList, error) {
+	query := url.Values{}
+	if opts.SiteID != 0 {
+		query.Set("site", strconv.FormatInt(opts.SiteID, 10))
+	}
+	if opts.Query != "" {
+		query.Set("q", opts.Query)
+	}
+	for _, tag := range opts.Tags {
+		query.Add("tag", tag)
+	}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+
+	var list PageList
+	return &list, c.do(http.MethodGet, "/api/pages?"+query.Encode(), nil, "", &list)
+}
+
+func (c *Client) Categories() ([]Category, error) {
+	var categories []Category
+	if err := c.do(http.MethodGet, "/api/categories", nil, "", &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (c *Client) Sites() ([]Site, error) {
+	var sites []Site
+	if err := c.do(http.MethodGet, "/api/sites", nil, "", &sites); err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
+
+func (c *Client) EditPage(id int64, edit Edit) (*Page, error) {
+	var page Page
+	return &page, c.doJSON(http.MethodPut, "/api/pages/"+strconv.FormatInt(id, 10), edit, &page)
+}
+
+func (c *Client) EditSite(id int64, edit Edit) (*Site, error) {
+	var site Site
+	return &site, c.doJSON(http.MethodPut, "/api/sites/"+strconv.FormatInt(id, 10), edit, &site)
+}
+
+// VisitURL is the address that opens a page through the server, which
+// records the visit.
+func (c *Client) VisitURL(page Page) (string, error) {
+	return c.BaseURL + "/pages/" + strconv.FormatInt(page.ID, 10) + "/visit", nil
+}
+
+func (c *Client) Search(query string) (*SearchResults, error) {
+	var results SearchResults
+	return &results, c.do(http.MethodGet, "/api/search?q="+url.QueryEscape(query), nil, "", &results)
+}
+
+func (c *Client) Export() (*Export, error) {
+	var doc Export
+	return &doc, c.do(http.MethodGet, "/export", nil, "", &doc)
+}
+
+// Import sends an export or a bookmarks HTML file for the server to read.
+func (c *Client) Import(data []byte) (*ImportResult, error) {
+	var result ImportResult
+	return &result, c.do(http.MethodPost, "/api/import", bytes.NewReader(data), "application/octet-stream", &result)
+}
+
+func (c *Client) CheckLinks(concurrency int) ([]LinkCheck, error) {
+	var results []LinkCheck
+	path := "/api/check-links?concurrency=" + strconv.Itoa(concurrency)
+	if err := c.do(http.MethodPost, path, nil, "", &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (c *Client) MergeTags(merge TagMerge) error {
+	return c.doJSON(http.MethodPost, "/api/tags/merge", merge, nil)
+}
+
+// Backup has the server take a backup and writes it to w, returning the
+// backup's name.
+func (c *Client) Backup(w io.Writer) (string, error) {
+	resp, err := c.send(http.MethodPost, "/admin/backups?download=1", nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return "", err
+	}
+	return params["filename"], nil
+}
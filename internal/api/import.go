@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// ParseImport reads either an Export document or a bookmarks file in the
+// Netscape format browsers export, whose links become pages with the tags
+// in their TAGS attribute.
+func ParseImport(data []byte) (*Export, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var doc Export
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("reading export: %w", err)
+		}
+		return &doc, nil
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return parseNetscape(trimmed)
+	default:
+		return nil, errors.New("not a bookmarks export or bookmarks HTML file")
+	}
+}
+
+func parseNetscape(data []byte) (*Export, error) {
+	doc := &Export{}
+	z := html.NewTokenizer(bytes.NewReader(data))
+	var link *Page
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if len(doc.Pages) == 0 {
+				return nil, errors.New("no links found in bookmarks HTML file")
+			}
+			return doc, nil
+		case html.StartTagToken:
+			tag := z.Token()
+			if tag.Data != "a" {
+				continue
+			}
+			link = &Page{}
+			for _, a := range tag.Attr {
+				switch strings.ToLower(a.Key) {
+				case "href":
+					link.URL = a.Val
+				case "tags":
+					link.Tags = SplitTags(a.Val)
+				}
+			}
+		case html.TextToken:
+			if link != nil {
+				link.Title += string(z.Text())
+			}
+		case html.EndTagToken:
+			if link != nil && z.Token().Data == "a" {
+				link.Title = strings.TrimSpace(link.Title)
+				if strings.HasPrefix(link.URL, "http://") || strings.HasPrefix(link.URL, "https://") {
+					doc.Pages = append(doc.Pages, *link)
+				}
+				link = nil
+			}
+		}
+	}
+}
+
+// Import adds what doc holds that is not bookmarked yet. Categories are
+// matched by name, sites by domain and pages by URL; matching ones are
+// left as they are. Links to a whole site add the site.
+func Import(repo repository.Store, doc *Export) (*ImportResult, error) {
+	result := &ImportResult{}
+
+	// Categories, with parents linked once they all exist
+	categories, err := repo.GetCategories()
+	if err != nil {
+		return result, err
+	}
+	categoryByName := map[string]int64{}
+	for _, c := range categories {
+		categoryByName[c.Name] = c.ID
+	}
+	categoryIDs := map[int64]int64{} // ID in doc to ID here
+	var created []Category
+	for _, c := range doc.Categories {
+		if id, ok := categoryByName[c.Name]; ok {
+			categoryIDs[c.ID] = id
+			continue
+		}
+		id, err := repo.CreateCategory(c.Name, c.Description, nil)
+		if err != nil {
+			return result, fmt.Errorf("category %s: %w", c.Name, err)
+		}
+		categoryByName[c.Name] = id
+		categoryIDs[c.ID] = id
+		created = append(created, c)
+		result.Categories++
+	}
+	for _, c := range created {
+		if c.ParentID == nil {
+			continue
+		}
+		if parentID, ok := categoryIDs[*c.ParentID]; ok {
+			if err := repo.UpdateCategory(categoryIDs[c.ID], c.Name, c.Description, &parentID); err != nil {
+				return result, fmt.Errorf("category %s: %w", c.Name, err)
+			}
+		}
+	}
+
+	for _, s := range doc.Sites {
+		if _, err := repo.GetSiteByDomain(s.Domain); err == nil {
+			result.Skipped++
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return result, err
+		}
+		var categoryID *int64
+		if s.CategoryID != nil {
+			if id, ok := categoryIDs[*s.CategoryID]; ok {
+				categoryID = &id
+			}
+		}
+		id, err := repo.CreateSite(categoryID, s.Domain, s.Name, s.Description)
+		if err != nil {
+			return result, fmt.Errorf("site %s: %w", s.Domain, err)
+		}
+		if err := importSiteDetails(repo, id, s); err != nil {
+			return result, fmt.Errorf("site %s: %w", s.Domain, err)
+		}
+		result.Sites++
+	}
+
+	// Paths already bookmarked, by site, loaded as sites come up
+	existing := map[int64]map[string]bool{}
+	for _, p := range doc.Pages {
+		domain, path, _, err := ParseURL(p.URL)
+		if err != nil {
+			return result, err
+		}
+		site, err := repo.GetSiteByDomain(domain)
+		if errors.Is(err, sql.ErrNoRows) {
+			name := ""
+			if path == "/" {
+				name = p.Title
+			}
+			id, err := repo.CreateSite(nil, domain, name, "")
+			if err != nil {
+				return result, fmt.Errorf("site %s: %w", domain, err)
+			}
+			if path == "/" {
+				if err := importSiteDetails(repo, id, Site{Tags: p.Tags, Note: p.Note, Favorite: p.Favorite, Pinned: p.Pinned, Rating: p.Rating}); err != nil {
+					return result, fmt.Errorf("site %s: %w", domain, err)
+				}
+				result.Sites++
+				continue
+			}
+			site, err = repo.GetSite(id)
+			if err != nil {
+				return result, err
+			}
+			result.Sites++
+		} else if err != nil {
+			return result, err
+		}
+
+		if path == "/" {
+			result.Skipped++
+			continue
+		}
+		if existing[site.ID] == nil {
+			pages, err := repo.GetPages(repository.PageFilter{SiteID: &site.ID})
+			if err != nil {
+				return result, err
+			}
+			existing[site.ID] = map[string]bool{}
+			for _, page := range pages {
+				existing[site.ID][page.Path] = true
+			}
+		}
+		if existing[site.ID][path] {
+			result.Skipped++
+			continue
+		}
+
+		id, err := repo.CreatePage(site.ID, path, p.Title, p.Description)
+		if err != nil {
+			return result, fmt.Errorf("page %s: %w", p.URL, err)
+		}
+		existing[site.ID][path] = true
+		if err := importPageDetails(repo, id, p); err != nil {
+			return result, fmt.Errorf("page %s: %w", p.URL, err)
+		}
+		result.Pages++
+	}
+	return result, nil
+}
+
+func importSiteDetails(repo repository.Store, id int64, s Site) error {
+	for _, tagID := range tagIDs(repo, s.Tags) {
+		if err := repo.AddSiteTag(id, tagID); err != nil {
+			return err
+		}
+	}
+	if s.Note != "" {
+		if err := repo.SaveSiteNote(id, s.Note); err != nil {
+			return err
+		}
+	}
+	if s.Favorite {
+		if err := repo.SetSiteFavorite(id, true); err != nil {
+			return err
+		}
+	}
+	if s.Pinned {
+		if err := repo.SetSitePinned(id, true); err != nil {
+			return err
+		}
+	}
+	if s.Rating > 0 && s.Rating <= models.MaxRating {
+		return repo.SetSiteRating(id, s.Rating)
+	}
+	return nil
+}
+
+func importPageDetails(repo repository.Store, id int64, p Page) error {
+	for _, tagID := range tagIDs(repo, p.Tags) {
+		if err := repo.AddPageTag(id, tagID); err != nil {
+			return err
+		}
+	}
+	if p.ReadStatus != "" {
+		if err := repo.SetPageReadStatus(id, p.ReadStatus); err != nil {
+			return err
+		}
+	}
+	if p.Note != "" {
+		if err := repo.SavePageNote(id, p.Note); err != nil {
+			return err
+		}
+	}
+	if p.Favorite {
+		if err := repo.SetPageFavorite(id, true); err != nil {
+			return err
+		}
+	}
+	if p.Pinned {
+		if err := repo.SetPagePinned(id, true); err != nil {
+			return err
+		}
+	}
+	if p.Rating > 0 && p.Rating <= models.MaxRating {
+		return repo.SetPageRating(id, p.Rating)
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/linkcheck"
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+var (
+	ErrInvalidURL = errors.New("invalid URL")
+	ErrUnknownTag = errors.New("unknown tag")
+)
+
+// ParseURL splits a bookmarked address into its domain and its path with
+// the query string, which is "/" for the site itself. Addresses without a
+// scheme are taken to be https. rawURL is the full address.
+func ParseURL(str string) (domain, path, rawURL string, err error) {
+	rawURL = strings.TrimSpace(str)
+	if rawURL == "" {
+		return "", "", "", fmt.Errorf("%w: URL is required", ErrInvalidURL)
+	}
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		rawURL = "https://" + rawURL
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.Host == "" {
+		return "", "", "", fmt.Errorf("%w: %s", ErrInvalidURL, str)
+	}
+
+	path = parsedURL.Path
+	if parsedURL.RawQuery != "" {
+		path += "?" + parsedURL.RawQuery
+	}
+	if path == "" {
+		path = "/"
+	}
+	return parsedURL.Host, path, rawURL, nil
+}
+
+// Add bookmarks a URL, fetching its title when none is given, and returns
+// the site it belongs to and the new page, which is nil when the URL was
+// the site's own address.
+func Add(repo repository.Store, req AddRequest) (*models.Site, *models.Page, error) {
+	domain, path, rawURL, err := ParseURL(req.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Check if this is a root domain (no specific page)
+	isRootDomain := path == "/"
+
+	title := req.Title
+	if title == "" {
+		title = FetchTitle(rawURL)
+	}
+
+	// Find or create site
+	site, err := repo.GetSiteByDomain(domain)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Create new site - use title as site name if this is root domain
+		siteName := ""
+		if isRootDomain {
+			siteName = title
+		}
+		siteID, err := repo.CreateSite(nil, domain, siteName, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		site, err = repo.GetSite(siteID)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	// If root domain, just create the site and apply tags to it
+	if isRootDomain {
+		for _, tagID := range tagIDs(repo, req.Tags) {
+			repo.AddSiteTag(site.ID, tagID)
+		}
+		site, err = repo.GetSite(site.ID)
+		return site, nil, err
+	}
+
+	id, err := repo.CreatePage(site.ID, path, title, req.Description)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tagID := range tagIDs(repo, req.Tags) {
+		repo.AddPageTag(id, tagID)
+	}
+	if req.Later {
+		repo.SetPageReadStatus(id, models.StatusUnread)
+	}
+
+	page, err := repo.GetPage(id)
+	return site, page, err
+}
+
+// SplitTags reads a comma-separated list of tag names.
+func SplitTags(str string) []string {
+	var names []string
+	for _, name := range strings.Split(str, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// tagIDs looks up or creates the named tags, skipping any that fail.
+func tagIDs(repo repository.Store, names []string) []int64 {
+	var ids []int64
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id, err := repo.GetOrCreateTag(name)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// FindTag looks up an existing tag by its name or one of its aliases.
+func FindTag(repo repository.Store, name string) (*models.Tag, error) {
+	tags, err := repo.GetTags()
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	for i, t := range tags {
+		if strings.EqualFold(t.Name, name) {
+			return &tags[i], nil
+		}
+		for _, alias := range t.Aliases {
+			if strings.EqualFold(alias, name) {
+				return &tags[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownTag, name)
+}
+
+// ListOptions select pages for ListPages. Pages carry every tag named,
+// and Sort is one of repository.PageSortOrders. A SiteID of zero lists
+// pages of every site.
+type ListOptions struct {
+	SiteID int64
+	Query  string
+	Tags   []string
+	Status string
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+func ListPages(repo repository.Store, opts ListOptions) (*PageList, error) {
+	filter := repository.PageFilter{
+		Query:      opts.Query,
+		ReadStatus: opts.Status,
+		Sort:       opts.Sort,
+		Tags:       repository.TagFilter{IncludeSubtags: true, IncludeSiteTags: true},
+	}
+	if opts.SiteID != 0 {
+		filter.SiteID = &opts.SiteID
+	}
+	for _, name := range opts.Tags {
+		tag, err := FindTag(repo, name)
+		if err != nil {
+			return nil, err
+		}
+		filter.Tags.All = append(filter.Tags.All, tag.ID)
+	}
+
+	total, err := repo.CountPages(filter)
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit = opts.Limit
+	filter.Offset = opts.Offset
+	pages, err := repo.GetPages(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &PageList{Total: total, Pages: []Page{}}
+	for _, p := range pages {
+		list.Pages = append(list.Pages, NewPage(p))
+	}
+	return list, nil
+}
+
+// ListCategories lists the categories in tree order, each parent before
+// its children.
+func ListCategories(repo repository.Store) ([]Category, error) {
+	categories, err := repo.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	out := []Category{}
+	for _, c := range categories {
+		out = append(out, NewCategory(c))
+	}
+	return out, nil
+}
+
+// ListSites lists every site outside the trash by domain.
+func ListSites(repo repository.Store) ([]Site, error) {
+	sites, err := repo.GetSites(repository.SiteFilter{})
+	if err != nil {
+		return nil, err
+	}
+	out := []Site{}
+	for _, s := range sites {
+		out = append(out, NewSite(s))
+	}
+	return out, nil
+}
+
+// EditPage retitles and retags a page, keeping its address and
+// description.
+func EditPage(repo repository.Store, id int64, edit Edit) (*models.Page, error) {
+	page, err := repo.GetPage(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.UpdatePage(id, page.SiteID, page.Path, edit.Title, page.Description); err != nil {
+		return nil, err
+	}
+	if err := repo.SetPageTags(id, tagIDs(repo, edit.Tags)); err != nil {
+		return nil, err
+	}
+	return repo.GetPage(id)
+}
+
+// EditSite renames and retags a site, keeping its domain, category and
+// description.
+func EditSite(repo repository.Store, id int64, edit Edit) (*models.Site, error) {
+	site, err := repo.GetSite(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.UpdateSite(id, site.CategoryID, site.Domain, edit.Title, site.Description); err != nil {
+		return nil, err
+	}
+	if err := repo.SetSiteTags(id, tagIDs(repo, edit.Tags)); err != nil {
+		return nil, err
+	}
+	return repo.GetSite(id)
+}
+
+func Search(repo repository.Store, query string) (*SearchResults, error) {
+	sites, pages, err := repo.Search(query)
+	if err != nil {
+		return nil, err
+	}
+	results := &SearchResults{Sites: []Site{}, Pages: []Page{}}
+	for _, s := range sites {
+		results.Sites = append(results.Sites, NewSite(s))
+	}
+	for _, p := range pages {
+		results.Pages = append(results.Pages, NewPage(p))
+	}
+	return results, nil
+}
+
+// NewExport collects every category, site and page with their notes.
+func NewExport(repo repository.Store) (*Export, error) {
+	categories, err := repo.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	sites, err := repo.GetSites(repository.SiteFilter{})
+	if err != nil {
+		return nil, err
+	}
+	pages, err := repo.GetPages(repository.PageFilter{})
+	if err != nil {
+		return nil, err
+	}
+	notes, err := repo.GetNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	siteNotes := map[int64]string{}
+	pageNotes := map[int64]string{}
+	for _, n := range notes {
+		if n.SiteID != nil {
+			siteNotes[*n.SiteID] = n.Body
+		} else {
+			pageNotes[*n.PageID] = n.Body
+		}
+	}
+
+	out := &Export{
+		ExportedAt: time.Now().UTC(),
+		Categories: []Category{},
+		Sites:      []Site{},
+		Pages:      []Page{},
+	}
+	for _, c := range categories {
+		out.Categories = append(out.Categories, NewCategory(c))
+	}
+	for _, s := range sites {
+		site := NewSite(s)
+		site.Note = siteNotes[s.ID]
+		out.Sites = append(out.Sites, site)
+	}
+	for _, p := range pages {
+		page := NewPage(p)
+		page.Note = pageNotes[p.ID]
+		out.Pages = append(out.Pages, page)
+	}
+	return out, nil
+}
+
+// MergeTags merges the tag named merge.From into merge.Into.
+func MergeTags(repo repository.Store, merge TagMerge) error {
+	from, err := FindTag(repo, merge.From)
+	if err != nil {
+		return err
+	}
+	into, err := FindTag(repo, merge.Into)
+	if err != nil {
+		return err
+	}
+	return repo.MergeTags(from.ID, into.ID)
+}
+
+// linkClient fetches pages for CheckLinks.
+var linkClient = &http.Client{
+	Timeout: 15 * time.Second,
+}
+
+// CheckLinks fetches every page outside the trash, up to concurrency at a
+// time. It flags the ones found gone for good as broken, looking them up
+// with provider when it is not nil, and clears the flag on broken ones that
+// load again. Other failures are reported but change nothing. The results
+// are in the order of the pages.
+func CheckLinks(repo repository.Store, provider archive.Provider, concurrency int) ([]LinkCheck, error) {
+	pages, err := repo.GetPages(repository.PageFilter{Sort: repository.SortOldest})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]LinkCheck, len(pages))
+	sem := make(chan struct{}, max(1, concurrency))
+	var wg sync.WaitGroup
+	for i := range pages {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = checkLink(&pages[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range pages {
+		page := &pages[i]
+		switch {
+		case results[i].Broken:
+			if err := linkcheck.MarkBroken(repo, provider, page); err != nil {
+				return results, err
+			}
+		case page.BrokenAt != nil && results[i].Error == "" && results[i].Status < 400:
+			if err := repo.SetPageBroken(page.ID, false); err != nil {
+				return results, err
+			}
+		}
+	}
+	return results, nil
+}
+
+func checkLink(page *models.Page) LinkCheck {
+	check := LinkCheck{PageID: page.ID, URL: page.URL()}
+	resp, err := linkClient.Get(check.URL)
+	if err != nil {
+		// The URL is already in the result
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		check.Broken = linkcheck.Gone(0, err)
+		check.Error = err.Error()
+		return check
+	}
+	resp.Body.Close()
+	check.Status = resp.StatusCode
+	check.Broken = linkcheck.Gone(resp.StatusCode, nil)
+	return check
+}
+
+// FetchTitle fetches a URL and extracts the <title> tag content
+func FetchTitle(rawURL string) string {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	// Read first 64KB to find title
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return ""
+	}
+
+	// Extract title using regex
+	titleRegex := regexp.MustCompile(`(?i)<title[^>]*>([^<]+)</title>`)
+	matches := titleRegex.FindSubmatch(body)
+	if len(matches) >= 2 {
+		title := strings.TrimSpace(string(matches[1]))
+		// Decode HTML entities
+		title = strings.ReplaceAll(title, "&amp;", "&")
+		title = strings.ReplaceAll(title, "&lt;", "<")
+		title = strings.ReplaceAll(title, "&gt;", ">")
+		title = strings.ReplaceAll(title, "&quot;", "\"")
+		title = strings.ReplaceAll(title, "&#39;", "'")
+		title = strings.ReplaceAll(title, "&ndash;", "-")
+		title = strings.ReplaceAll(title, "&mdash;", "-")
+		return title
+	}
+
+	return ""
+}
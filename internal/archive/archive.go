@@ -0,0 +1,195 @@
+package archive
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxDocumentSize = 10 << 20
+	maxResourceSize = 5 << 20
+)
+
+var (
+	scriptRegex     = regexp.MustCompile(`(?is)<script\b.*?</script>`)
+	stylesheetRegex = regexp.MustCompile(`(?is)<link\b[^>]*\brel\s*=\s*["']?stylesheet["']?[^>]*>`)
+	hrefRegex       = regexp.MustCompile(`(?is)\bhref\s*=\s*["']([^"']+)["']`)
+	imgSrcRegex     = regexp.MustCompile(`(?is)(<img\b[^>]*?\bsrc\s*=\s*)["']([^"']+)["']`)
+	srcsetRegex     = regexp.MustCompile(`(?is)\s(srcset|sizes)\s*=\s*["'][^"']*["']`)
+	cssURLRegex     = regexp.MustCompile(`(?i)url\(\s*["']?([^"')]+)["']?\s*\)`)
+	headRegex       = regexp.MustCompile(`(?i)<head[^>]*>`)
+)
+
+// Archiver stores self-contained HTML snapshots of pages under a directory,
+// one file per page ID.
+type Archiver struct {
+	dir    string
+	client *http.Client
+}
+
+func New(dataDir string) *Archiver {
+	return &Archiver{
+		dir: filepath.Join(dataDir, "archive"),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Path returns the location of the snapshot for a page.
+func (a *Archiver) Path(pageID int64) string {
+	return filepath.Join(a.dir, strconv.FormatInt(pageID, 10)+".html")
+}
+
+// Archive fetches rawURL, inlines its stylesheets and images and writes the
+// result to the page's snapshot file, replacing any previous snapshot. It
+// returns the size of the stored file.
+func (a *Archiver) Archive(pageID int64, rawURL string) (int64, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, err
+	}
+
+	body, _, err := a.fetch(rawURL, maxDocumentSize)
+	if err != nil {
+		return 0, err
+	}
+	doc := a.inline(base, string(body))
+
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	// Write to a temporary file first so a failed fetch never clobbers a
+	// good snapshot.
+	tmp, err := os.CreateTemp(a.dir, "snapshot-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, strings.NewReader(doc))
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), a.Path(pageID)); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Remove deletes the snapshot for a page, if any.
+func (a *Archiver) Remove(pageID int64) error {
+	err := os.Remove(a.Path(pageID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (a *Archiver) inline(base *url.URL, doc string) string {
+	// Scripts are dropped: the snapshot is meant to be read, not run.
+	doc = scriptRegex.ReplaceAllString(doc, "")
+
+	doc = stylesheetRegex.ReplaceAllStringFunc(doc, func(tag string) string {
+		m := hrefRegex.FindStringSubmatch(tag)
+		if m == nil {
+			return tag
+		}
+		cssURL, err := base.Parse(html.UnescapeString(m[1]))
+		if err != nil {
+			return tag
+		}
+		css, _, err := a.fetch(cssURL.String(), maxResourceSize)
+		if err != nil {
+			return ""
+		}
+		return "<style>\n" + a.inlineCSS(cssURL, string(css)) + "\n</style>"
+	})
+
+	doc = imgSrcRegex.ReplaceAllStringFunc(doc, func(tag string) string {
+		m := imgSrcRegex.FindStringSubmatch(tag)
+		if strings.HasPrefix(m[2], "data:") {
+			return tag
+		}
+		if uri := a.dataURI(base, html.UnescapeString(m[2])); uri != "" {
+			return m[1] + `"` + uri + `"`
+		}
+		return tag
+	})
+	// srcset would make browsers prefer the remote originals.
+	doc = srcsetRegex.ReplaceAllString(doc, "")
+
+	// Anything left relative (links, unfetchable resources) resolves against
+	// the original site.
+	baseTag := `<base href="` + html.EscapeString(base.String()) + `">`
+	if loc := headRegex.FindStringIndex(doc); loc != nil {
+		return doc[:loc[1]] + baseTag + doc[loc[1]:]
+	}
+	return baseTag + doc
+}
+
+func (a *Archiver) inlineCSS(base *url.URL, css string) string {
+	return cssURLRegex.ReplaceAllStringFunc(css, func(match string) string {
+		m := cssURLRegex.FindStringSubmatch(match)
+		if strings.HasPrefix(m[1], "data:") {
+			return match
+		}
+		if uri := a.dataURI(base, m[1]); uri != "" {
+			return `url("` + uri + `")`
+		}
+		return match
+	})
+}
+
+func (a *Archiver) dataURI(base *url.URL, ref string) string {
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	data, contentType, err := a.fetch(u.String(), maxResourceSize)
+	if err != nil {
+		return ""
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(u.Path))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+func (a *Archiver) fetch(rawURL string, limit int64) ([]byte, string, error) {
+	resp, err := a.client.Get(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetching %s: %s", rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, "", err
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return body, contentType, nil
+}
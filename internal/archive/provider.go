@@ -0,0 +1,27 @@
+package archive
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotArchived is returned by Provider.Lookup when the provider holds no
+// copy of the URL.
+var ErrNotArchived = errors.New("no archived copy available")
+
+// Snapshot is a copy of a URL held by an external archive.
+type Snapshot struct {
+	URL       string
+	Timestamp time.Time
+}
+
+// Provider is an external web archive that can be asked for, and asked to
+// take, copies of pages. It complements the local snapshots made by Archiver
+// and is mostly useful once the original link has died.
+type Provider interface {
+	Name() string
+	// Lookup returns the provider's copy of rawURL closest to now.
+	Lookup(rawURL string) (*Snapshot, error)
+	// Save asks the provider to capture rawURL now.
+	Save(rawURL string) (*Snapshot, error)
+}
@@ -0,0 +1,110 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultWaybackURL is the public Internet Archive endpoint.
+const DefaultWaybackURL = "https://web.archive.org"
+
+const waybackTimestamp = "20060102150405"
+
+// Wayback talks to the Internet Archive's Wayback Machine: the availability
+// API for lookups and Save Page Now for captures.
+type Wayback struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewWayback returns a provider rooted at baseURL, which can point at a local
+// stand-in instead of DefaultWaybackURL.
+func NewWayback(baseURL string) *Wayback {
+	return &Wayback{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client: &http.Client{
+			// Save Page Now can take a while to finish a capture
+			Timeout: 2 * time.Minute,
+		},
+	}
+}
+
+func (wb *Wayback) Name() string {
+	return "Wayback Machine"
+}
+
+func (wb *Wayback) Lookup(rawURL string) (*Snapshot, error) {
+	resp, err := wb.client.Get(wb.baseURL + "/wayback/available?url=" + url.QueryEscape(rawURL))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wayback lookup: %s", resp.Status)
+	}
+
+	var result struct {
+		ArchivedSnapshots struct {
+			Closest *struct {
+				Available bool   `json:"available"`
+				URL       string `json:"url"`
+				Timestamp string `json:"timestamp"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("wayback lookup: %w", err)
+	}
+
+	closest := result.ArchivedSnapshots.Closest
+	if closest == nil || !closest.Available {
+		return nil, ErrNotArchived
+	}
+	ts, _ := time.Parse(waybackTimestamp, closest.Timestamp)
+	return &Snapshot{URL: closest.URL, Timestamp: ts}, nil
+}
+
+func (wb *Wayback) Save(rawURL string) (*Snapshot, error) {
+	resp, err := wb.client.Get(wb.baseURL + "/save/" + rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wayback save: %s", resp.Status)
+	}
+
+	// The capture's location is reported in Content-Location, or we were
+	// redirected straight to it.
+	location := resp.Header.Get("Content-Location")
+	if location == "" && strings.HasPrefix(resp.Request.URL.Path, "/web/") {
+		location = resp.Request.URL.RequestURI()
+	}
+	if location == "" {
+		// Capture accepted but not reported; fall back to whatever is newest.
+		return wb.Lookup(rawURL)
+	}
+
+	snapshotURL := location
+	if strings.HasPrefix(location, "/") {
+		snapshotURL = wb.baseURL + location
+	}
+	return &Snapshot{URL: snapshotURL, Timestamp: parseWaybackPath(location)}, nil
+}
+
+// parseWaybackPath pulls the capture time out of a /web/<timestamp>/<url> path.
+func parseWaybackPath(location string) time.Time {
+	i := strings.Index(location, "/web/")
+	if i < 0 {
+		return time.Time{}
+	}
+	stamp, _, _ := strings.Cut(location[i+len("/web/"):], "/")
+	ts, _ := time.Parse(waybackTimestamp, strings.TrimRight(stamp, "_abcdefghijklmnopqrstuvwxyz"))
+	return ts
+}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWaybackStub serves handler in place of the Wayback Machine and returns
+// a provider pointed at it.
+func newWaybackStub(t *testing.T, handler http.HandlerFunc) *Wayback {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewWayback(srv.URL + "/")
+}
+
+func TestWaybackLookup(t *testing.T) {
+	var gotURL string
+	wb := newWaybackStub(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wayback/available" {
+			http.NotFound(w, r)
+			return
+		}
+		gotURL = r.URL.Query().Get("url")
+		w.Write([]byte(`{"url": "example.com/a?b=c", "archived_snapshots": {"closest": {
+			"available": true, "status": "200",
+			"url": "http://web.archive.org/web/20240102030405/https://example.com/a?b=c",
+			"timestamp": "20240102030405"}}}`))
+	})
+
+	snap, err := wb.Lookup("https://example.com/a?b=c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotURL != "https://example.com/a?b=c" {
+		t.Errorf("looked up %q", gotURL)
+	}
+	if want := "http://web.archive.org/web/20240102030405/https://example.com/a?b=c"; snap.URL != want {
+		t.Errorf("URL = %q, want %q", snap.URL, want)
+	}
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !snap.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v", snap.Timestamp, want)
+	}
+}
+
+func TestWaybackLookupNotArchived(t *testing.T) {
+	for name, body := range map[string]string{
+		"no snapshots":  `{"url": "example.com", "archived_snapshots": {}}`,
+		"not available": `{"archived_snapshots": {"closest": {"available": false, "url": "x", "timestamp": "20240102030405"}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			wb := newWaybackStub(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body))
+			})
+			if _, err := wb.Lookup("https://example.com/"); !errors.Is(err, ErrNotArchived) {
+				t.Errorf("err = %v, want ErrNotArchived", err)
+			}
+		})
+	}
+}
+
+func TestWaybackLookupErrors(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"malformed JSON": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"archived_snapshots": {"closest": `))
+		},
+		"server error": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			wb := newWaybackStub(t, handler)
+			_, err := wb.Lookup("https://example.com/")
+			if err == nil || errors.Is(err, ErrNotArchived) {
+				t.Errorf("err = %v, want a lookup failure", err)
+			}
+		})
+	}
+}
+
+func TestWaybackSave(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantURL string // with the stub's address in place of BASE
+		wantTS  time.Time
+	}{
+		{
+			name: "content location",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Location", "/web/20240102030405/https://example.com/a")
+			},
+			wantURL: "BASE/web/20240102030405/https://example.com/a",
+			wantTS:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{
+			name: "redirect",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/save/") {
+					// Set by hand: http.Redirect would clean the // away
+					w.Header().Set("Location", "/web/20240102030405id_/https://example.com/a")
+					w.WriteHeader(http.StatusFound)
+				}
+			},
+			wantURL: "BASE/web/20240102030405id_/https://example.com/a",
+			wantTS:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{
+			name: "falls back to lookup",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/wayback/available" {
+					w.Write([]byte(`{"archived_snapshots": {"closest": {"available": true,
+						"url": "http://web.archive.org/web/20230101000000/https://example.com/a",
+						"timestamp": "20230101000000"}}}`))
+				}
+			},
+			wantURL: "http://web.archive.org/web/20230101000000/https://example.com/a",
+			wantTS:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/save/") {
+					saved = strings.TrimPrefix(r.URL.Path, "/save/")
+				}
+				tt.handler(w, r)
+			}))
+			defer srv.Close()
+
+			snap, err := NewWayback(srv.URL).Save("https://example.com/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved != "https://example.com/a" {
+				t.Errorf("asked to save %q", saved)
+			}
+			if want := strings.Replace(tt.wantURL, "BASE", srv.URL, 1); snap.URL != want {
+				t.Errorf("URL = %q, want %q", snap.URL, want)
+			}
+			if !snap.Timestamp.Equal(tt.wantTS) {
+				t.Errorf("Timestamp = %v, want %v", snap.Timestamp, tt.wantTS)
+			}
+		})
+	}
+}
+
+func TestWaybackSaveRefused(t *testing.T) {
+	wb := newWaybackStub(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "too many captures", http.StatusTooManyRequests)
+	})
+	if _, err := wb.Save("https://example.com/a"); err == nil {
+		t.Error("Save succeeded despite the archive refusing")
+	}
+}
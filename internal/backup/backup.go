@@ -0,0 +1,331 @@
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/database"
+)
+
+// Defaults for the backup schedule and how many backups are kept.
+const (
+	DefaultInterval   = 24 * time.Hour
+	DefaultKeepDaily  = 7
+	DefaultKeepWeekly = 4
+)
+
+// Backup files are named after the moment they were taken, in UTC, and
+// may carry a label after it.
+const (
+	filePrefix = "bookmarks-"
+	fileSuffix = ".db"
+	timeLayout = "20060102T150405Z"
+)
+
+// ErrNotFound is returned for a backup name that does not exist.
+var ErrNotFound = errors.New("backup not found")
+
+// Backup is one snapshot of the database in the backups directory.
+type Backup struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+	// Label marks backups taken for a reason, such as before a restore.
+	// Pruning leaves them alone.
+	Label string
+}
+
+// Manager takes consistent copies of a live SQLite database into
+// DATA_DIR/backups with VACUUM INTO, which reads the database in a single
+// transaction while the server keeps writing to it, and prunes old copies.
+type Manager struct {
+	db         *sql.DB
+	dir        string
+	keepDaily  int
+	keepWeekly int
+	mu         sync.Mutex // one backup or prune at a time
+}
+
+// New returns a Manager for the database opened by database.New(dataDir).
+// Pruning keeps the newest backup of each of the last keepDaily days and
+// keepWeekly weeks that have one.
+func New(db *sql.DB, dataDir string, keepDaily, keepWeekly int) *Manager {
+	return &Manager{
+		db:         db,
+		dir:        Dir(dataDir),
+		keepDaily:  keepDaily,
+		keepWeekly: keepWeekly,
+	}
+}
+
+// Dir is where backups of the database in dataDir are kept.
+func Dir(dataDir string) string {
+	return filepath.Join(filepath.Dir(database.Path(dataDir)), "backups")
+}
+
+// Start backs up and prunes now and then every interval until the process
+// exits.
+func (m *Manager) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := m.Create(); err != nil {
+				log.Printf("Backup failed: %v", err)
+			} else if _, err := m.Prune(); err != nil {
+				log.Printf("Pruning backups failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Create takes a backup now.
+func (m *Manager) Create() (*Backup, error) {
+	return m.create("")
+}
+
+func (m *Manager) create(label string) (*Backup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	name := filePrefix + now.Format(timeLayout)
+	if label != "" {
+		name += "-" + label
+	}
+	name += fileSuffix
+	path := filepath.Join(m.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", name)
+	}
+
+	// Write under a temporary name so a failed backup never shows up in
+	// the list. VACUUM INTO refuses to overwrite a file.
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if _, err := m.db.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Backup{Name: name, Size: info.Size(), CreatedAt: now, Label: label}, nil
+}
+
+// List returns the backups, newest first.
+func (m *Manager) List() ([]Backup, error) {
+	return list(m.dir)
+}
+
+func list(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		createdAt, label, ok := parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, Backup{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt, Label: label})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// parseName reads the time a backup was taken and its label from its file
+// name.
+func parseName(name string) (time.Time, string, bool) {
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return time.Time{}, "", false
+	}
+	stamp, label, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), "-")
+	t, err := time.Parse(timeLayout, stamp)
+	return t, label, err == nil
+}
+
+// Path returns the file holding the named backup. Only names of existing
+// backups are accepted, so a name cannot reach outside the directory.
+func (m *Manager) Path(name string) (string, error) {
+	if _, _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return "", ErrNotFound
+	}
+	path := filepath.Join(m.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+// Prune deletes the backups the retention policy no longer keeps and
+// returns their names.
+func (m *Manager) Prune() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	backups, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, b := range expired(backups, m.keepDaily, m.keepWeekly) {
+		if err := os.Remove(filepath.Join(m.dir, b.Name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.Name)
+	}
+	return removed, nil
+}
+
+// expired picks the unlabeled backups, listed newest first, that are not
+// the newest of one of the last keepDaily days or keepWeekly ISO weeks.
+// Days and weeks are in local time. The newest backup is always kept.
+func expired(backups []Backup, keepDaily, keepWeekly int) []Backup {
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	newest := true
+	var old []Backup
+	for _, b := range backups {
+		if b.Label != "" {
+			continue
+		}
+		t := b.CreatedAt.Local()
+		day := t.Format(time.DateOnly)
+		year, week := t.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+
+		keep := newest
+		newest = false
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep = true
+		}
+		if !weeks[weekKey] && len(weeks) < keepWeekly {
+			weeks[weekKey] = true
+			keep = true
+		}
+		if !keep {
+			old = append(old, b)
+		}
+	}
+	return old
+}
+
+// Restore replaces the database in dataDir with a backup. The server must
+// be stopped. The database being replaced is backed up first, under a
+// label that keeps it from being pruned, and the name of that backup is
+// returned. Page snapshots are not part of backups and are left alone.
+func Restore(dataDir, backupPath string) (string, error) {
+	if err := check(backupPath); err != nil {
+		return "", fmt.Errorf("%s is not a usable backup: %w", backupPath, err)
+	}
+
+	// Keep what is about to be replaced; database.New also brings it up to
+	// the current schema, which makes no difference to a backup.
+	current, err := database.New(dataDir)
+	if err != nil {
+		return "", err
+	}
+	saved, err := New(current, dataDir, 0, 0).create("before-restore")
+	// Closing the last connection checkpoints the WAL into the database
+	// file and removes it, so nothing of the old database lingers
+	current.Close()
+	if err != nil {
+		return "", fmt.Errorf("backing up the current database: %w", err)
+	}
+
+	path := database.Path(dataDir)
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return saved.Name, err
+		}
+	}
+	tmp := path + ".restore"
+	if err := copyFile(backupPath, tmp); err != nil {
+		os.Remove(tmp)
+		return saved.Name, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return saved.Name, err
+	}
+
+	// Migrate a backup taken before the latest schema changes
+	restored, err := database.New(dataDir)
+	if err != nil {
+		return saved.Name, err
+	}
+	return saved.Name, restored.Close()
+}
+
+// check opens a backup read-only and runs SQLite's integrity check on it.
+func check(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New(result)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// copyTables lists every table in an order that inserts rows after the rows
+// they reference. Each maps to the query reading it from SQLite; categories
+// come parents first, since a category may be moved under a newer one.
+var copyTables = []struct {
+	name  string
+	query string
+}{
+	{"categories", `WITH RECURSIVE tree(id, depth) AS (
+	     SELECT id, 0 FROM categories WHERE parent_id IS NULL
+	     UNION ALL
+	     SELECT c.id, t.depth + 1 FROM categories c JOIN tree t ON c.parent_id = t.id
+	 )
+	 SELECT c.* FROM categories c JOIN tree t ON t.id = c.id ORDER BY t.depth, c.id`},
+	{"sites", `SELECT * FROM sites`},
+	{"pages", `SELECT * FROM pages`},
+	{"tags", `SELECT * FROM tags`},
+	{"site_tags", `SELECT * FROM site_tags`},
+	{"page_tags", `SELECT * FROM page_tags`},
+	{"page_contents", `SELECT * FROM page_contents`},
+	{"page_versions", `SELECT * FROM page_versions`},
+	{"tag_aliases", `SELECT * FROM tag_aliases`},
+	{"saved_searches", `SELECT * FROM saved_searches`},
+	{"collections", `SELECT * FROM collections`},
+	{"collection_items", `SELECT * FROM collection_items`},
+	{"page_read_events", `SELECT * FROM page_read_events`},
+	{"notes", `SELECT * FROM notes`},
+	{"note_revisions", `SELECT * FROM note_revisions`},
+	{"highlights", `SELECT * FROM highlights`},
+	{"events", `SELECT * FROM events`},
+}
+
+// CopyToPostgres copies everything in the SQLite database src into the
+// PostgreSQL database dst, keeping ids, in a single transaction. Both must
+// be migrated to the latest schema, and dst must be empty. It returns the
+// number of rows copied from each table.
+func CopyToPostgres(src, dst *sql.DB) (map[string]int, error) {
+	tx, err := dst.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range copyTables {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM ` + table.name + `)`).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("%s already has rows; copy into an empty database", table.name)
+		}
+	}
+
+	counts := map[string]int{}
+	for _, table := range copyTables {
+		n, err := copyTable(src, tx, table.name, table.query)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", table.name, err)
+		}
+		counts[table.name] = n
+	}
+
+	// Ids were inserted explicitly, so move each identity past the copied ones
+	for _, table := range copyTables {
+		var hasID bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+		                                   WHERE table_schema = current_schema() AND table_name = $1
+		                                     AND column_name = 'id' AND is_identity = 'YES')`,
+			table.name).Scan(&hasID)
+		if err != nil {
+			return nil, err
+		}
+		if !hasID {
+			continue
+		}
+		if _, err := tx.Exec(`SELECT setval(pg_get_serial_sequence($1, 'id'), COALESCE(MAX(id), 0) + 1, false) FROM `+table.name,
+			table.name); err != nil {
+			return nil, fmt.Errorf("%s: %w", table.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func copyTable(src *sql.DB, dst *sql.Tx, table, query string) (int, error) {
+	rows, err := src.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	insert, err := dst.Prepare(`INSERT INTO ` + table + ` (` + strings.Join(columns, ", ") + `) VALUES (` + strings.Join(placeholders, ", ") + `)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	n := 0
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return n, err
+		}
+		for i, v := range values {
+			// Text read as bytes would otherwise be sent as bytea. Integer
+			// flags such as pages.watched are fine: 0 and 1 are valid booleans.
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
@@ -0,0 +1,88 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/lehmann314159/bookmarks/internal/database"
+	"github.com/lehmann314159/bookmarks/internal/database/dbtest"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCopyToPostgres(t *testing.T) {
+	dst := dbtest.Postgres(t)
+	src, err := database.New(t.TempDir())
+	check(t, err)
+	defer src.Close()
+
+	repo := repository.New(src)
+	dev, err := repo.CreateCategory("Dev", "", nil)
+	check(t, err)
+	lang, err := repo.CreateCategory("Languages", "", nil)
+	check(t, err)
+	// A category under a newer one has to be copied after it
+	check(t, repo.UpdateCategory(dev, "Dev", "", &lang))
+	site, err := repo.CreateSite(&dev, "go.dev", "Go", "")
+	check(t, err)
+	page, err := repo.CreatePage(site, "/doc", "Docs", "")
+	check(t, err)
+	tag, err := repo.GetOrCreateTag("golang")
+	check(t, err)
+	check(t, repo.AddPageTag(page, tag))
+	check(t, repo.RenameTag(tag, "lang/go"))
+	check(t, repo.SetPageWatched(page, true))
+	check(t, repo.SetPageContent(page, "Effective Go"))
+	check(t, repo.SavePageNote(page, "Read the spec next"))
+	collection, err := repo.CreateCollection("Reading", "")
+	check(t, err)
+	_, err = repo.AddCollectionItem(collection, nil, &page, "start here")
+	check(t, err)
+
+	counts, err := database.CopyToPostgres(src, dst)
+	check(t, err)
+	for table, n := range counts {
+		var inSrc, inDst int
+		check(t, src.QueryRow(`SELECT COUNT(*) FROM `+table).Scan(&inSrc))
+		check(t, dst.QueryRow(`SELECT COUNT(*) FROM `+table).Scan(&inDst))
+		if n != inSrc || inDst != inSrc {
+			t.Errorf("%s: copied %d rows, %d arrived, want %d", table, n, inDst, inSrc)
+		}
+	}
+
+	copied := repository.NewPostgres(dst)
+	if c, err := copied.GetCategory(dev); err != nil || c.ParentID == nil || *c.ParentID != lang {
+		t.Errorf("copied Dev = %+v, %v; want it under Languages", c, err)
+	}
+	pages, err := copied.GetPages(repository.PageFilter{Query: "effective"})
+	check(t, err)
+	if len(pages) != 1 || pages[0].ID != page || pages[0].URL() != "https://go.dev/doc" || !pages[0].Watched {
+		t.Errorf("pages found by their text = %+v, want the watched docs page", pages)
+	}
+	if tags, err := copied.GetPageTags(page); err != nil || len(tags) != 1 || tags[0].Name != "lang/go" {
+		t.Errorf("copied page tags = %+v, %v", tags, err)
+	}
+	if id, err := copied.GetOrCreateTag("golang"); err != nil || id != tag {
+		t.Errorf("copied alias finds tag %d, %v; want %d", id, err, tag)
+	}
+	if items, err := copied.GetCollectionItems(collection); err != nil || len(items) != 1 || items[0].Note != "start here" {
+		t.Errorf("copied collection items = %+v, %v", items, err)
+	}
+	if events, err := copied.GetEvents(repository.EventFilter{}); err != nil || len(events) != counts["events"] {
+		t.Errorf("copied events = %d, %v; want %d", len(events), err, counts["events"])
+	}
+
+	// New rows are numbered after the copied ones
+	if id, err := copied.CreatePage(site, "/blog", "Blog", ""); err != nil || id <= page {
+		t.Errorf("new page got id %d, %v; want one after %d", id, err, page)
+	}
+
+	if _, err := database.CopyToPostgres(src, dst); err == nil {
+		t.Error("copied into a database that already has rows")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -61,6 +63,164 @@ CREATE INDEX IF NOT EXISTS idx_page_tags_page ON page_tags(page_id);
 CREATE INDEX IF NOT EXISTS idx_page_tags_tag ON page_tags(tag_id);
 `
 
+// migrations are applied in order on top of schema. The index of each entry
+// plus one is the schema version it produces, tracked in PRAGMA user_version,
+// so entries must only ever be appended.
+var migrations = []string{
+	// 1: offline page archives
+	`ALTER TABLE pages ADD COLUMN archived_at DATETIME;
+	 ALTER TABLE pages ADD COLUMN archive_size INTEGER NOT NULL DEFAULT 0;`,
+	// 2: extracted article text
+	`CREATE TABLE page_contents (
+	     page_id INTEGER PRIMARY KEY REFERENCES pages(id) ON DELETE CASCADE,
+	     text TEXT NOT NULL,
+	     extracted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	 );`,
+	// 3: change detection for watched pages
+	`ALTER TABLE pages ADD COLUMN watched INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE pages ADD COLUMN checked_at DATETIME;
+	 ALTER TABLE pages ADD COLUMN changed_at DATETIME;
+	 CREATE TABLE page_versions (
+	     id INTEGER PRIMARY KEY,
+	     page_id INTEGER NOT NULL REFERENCES pages(id) ON DELETE CASCADE,
+	     text TEXT NOT NULL,
+	     hash TEXT NOT NULL,
+	     diff TEXT,
+	     fetched_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	 );
+	 CREATE INDEX idx_page_versions_page ON page_versions(page_id);`,
+	// 4: dead links and their copies in external archives
+	`ALTER TABLE pages ADD COLUMN broken_at DATETIME;
+	 ALTER TABLE pages ADD COLUMN external_archive_url TEXT;
+	 ALTER TABLE pages ADD COLUMN external_archived_at DATETIME;`,
+	// 5: nested categories
+	`ALTER TABLE categories ADD COLUMN parent_id INTEGER REFERENCES categories(id) ON DELETE SET NULL;
+	 CREATE INDEX idx_categories_parent ON categories(parent_id);`,
+	// 6: alternative spellings that resolve to a canonical tag
+	`CREATE TABLE tag_aliases (
+	     alias TEXT PRIMARY KEY,
+	     tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE
+	 );
+	 CREATE INDEX idx_tag_aliases_tag ON tag_aliases(tag_id);`,
+	// 7: saved page filters
+	`CREATE TABLE saved_searches (
+	     id INTEGER PRIMARY KEY,
+	     name TEXT NOT NULL,
+	     query TEXT NOT NULL,
+	     created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	 );`,
+	// 8: hand-curated, ordered collections of sites and pages
+	`CREATE TABLE collections (
+	     id INTEGER PRIMARY KEY,
+	     name TEXT NOT NULL,
+	     description TEXT,
+	     share_token TEXT UNIQUE,
+	     created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	 );
+	 CREATE TABLE collection_items (
+	     id INTEGER PRIMARY KEY,
+	     collection_id INTEGER NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+	     site_id INTEGER REFERENCES sites(id) ON DELETE CASCADE,
+	     page_id INTEGER REFERENCES pages(id) ON DELETE CASCADE,
+	     position INTEGER NOT NULL,
+	     note TEXT,
+	     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	     CHECK ((site_id IS NULL) != (page_id IS NULL))
+	 );
+	 CREATE INDEX idx_collection_items_collection ON collection_items(collection_id, position);`,
+	// 9: read-later queue
+	`ALTER TABLE pages ADD COLUMN read_status TEXT;
+	 ALTER TABLE pages ADD COLUMN read_status_at DATETIME;
+	 ALTER TABLE pages ADD COLUMN queued_at DATETIME;
+	 CREATE INDEX idx_pages_read_status ON pages(read_status, queued_at);
+	 CREATE TABLE page_read_events (
+	     id INTEGER PRIMARY KEY,
+	     page_id INTEGER NOT NULL REFERENCES pages(id) ON DELETE CASCADE,
+	     status TEXT,
+	     changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	 );
+	 CREATE INDEX idx_page_read_events_page ON page_read_events(page_id);`,
+	// 10: Markdown notes on sites and pages, with every saved version kept
+	`CREATE TABLE notes (
+	     id INTEGER PRIMARY KEY,
+	     site_id INTEGER UNIQUE REFERENCES sites(id) ON DELETE CASCADE,
+	     page_id INTEGER UNIQUE REFERENCES pages(id) ON DELETE CASCADE,
+	     body TEXT NOT NULL,
+	     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	     CHECK ((site_id IS NULL) != (page_id IS NULL))
+	 );
+	 CREATE TABLE note_revisions (
+	     id INTEGER PRIMARY KEY,
+	     note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+	     body TEXT NOT NULL,
+	     created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	 );
+	 CREATE INDEX idx_note_revisions_note ON note_revisions(note_id);`,
+	// 11: Passages highlighted while reading a page
+	`CREATE TABLE highlights (
+	     id INTEGER PRIMARY KEY,
+	     page_id INTEGER NOT NULL REFERENCES pages(id) ON DELETE CASCADE,
+	     text TEXT NOT NULL,
+	     comment TEXT NOT NULL DEFAULT '',
+	     source TEXT NOT NULL DEFAULT 'reader',
+	     created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	 );
+	 CREATE INDEX idx_highlights_page ON highlights(page_id);`,
+	// 12: Favorites, dashboard pins and 1-5 star ratings
+	`ALTER TABLE pages ADD COLUMN favorite BOOLEAN NOT NULL DEFAULT 0;
+	 ALTER TABLE pages ADD COLUMN pinned_at DATETIME;
+	 ALTER TABLE pages ADD COLUMN rating INTEGER NOT NULL DEFAULT 0 CHECK (rating BETWEEN 0 AND 5);
+	 ALTER TABLE sites ADD COLUMN favorite BOOLEAN NOT NULL DEFAULT 0;
+	 ALTER TABLE sites ADD COLUMN pinned_at DATETIME;
+	 ALTER TABLE sites ADD COLUMN rating INTEGER NOT NULL DEFAULT 0 CHECK (rating BETWEEN 0 AND 5);`,
+	// 13: deleted sites and pages go to a trash before being purged.
+	// deleted_with_site marks pages that went along with their site.
+	`ALTER TABLE sites ADD COLUMN deleted_at DATETIME;
+	 ALTER TABLE pages ADD COLUMN deleted_at DATETIME;
+	 ALTER TABLE pages ADD COLUMN deleted_with_site BOOLEAN NOT NULL DEFAULT 0;
+	 CREATE INDEX idx_sites_deleted ON sites(deleted_at);
+	 CREATE INDEX idx_pages_deleted ON pages(deleted_at);`,
+	// 14: append-only audit log, with an entity's state before and after as JSON
+	`CREATE TABLE events (
+	     id INTEGER PRIMARY KEY,
+	     entity TEXT NOT NULL,
+	     entity_id INTEGER NOT NULL,
+	     action TEXT NOT NULL,
+	     before TEXT,
+	     after TEXT,
+	     actor TEXT NOT NULL,
+	     created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	 );
+	 CREATE INDEX idx_events_entity ON events(entity, entity_id);
+	 CREATE INDEX idx_events_created ON events(created_at);`,
+	// 15: when a page was last opened from the app, for sorting by last visit
+	`ALTER TABLE pages ADD COLUMN visited_at DATETIME;`,
+}
+
+// sqliteOptions are set on every connection. In WAL mode readers carry on
+// while a write is in progress, and the busy timeout makes a writer wait
+// for the one ahead of it rather than fail with "database is locked".
+// Transactions take the write lock as they begin: one that read first
+// could not wait to upgrade its lock, and would fail straight away.
+// synchronous=NORMAL is safe with WAL and spares an fsync per commit.
+const sqliteOptions = "_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_txlock=immediate"
+
+// maxConns caps the pool New opens, which serves the readers.
+var maxConns = max(4, runtime.NumCPU())
+
+// SchemaVersion is the version New and NewPostgres bring a database to.
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// Path is where the SQLite database in dataDir is kept.
+func Path(dataDir string) string {
+	if dataDir == "" {
+		dataDir = "./data"
+	}
+	return filepath.Join(dataDir, "bookmarks.db")
+}
+
 func New(dataDir string) (*sql.DB, error) {
 	if dataDir == "" {
 		dataDir = "./data"
@@ -70,11 +230,17 @@ func New(dataDir string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	dbPath := filepath.Join(dataDir, "bookmarks.db")
-	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+	db, err := sql.Open("sqlite3", Path(dataDir)+"?"+sqliteOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	// SQLite runs one write at a time however many connections are open,
+	// so the pool is sized for concurrent readers. The server sends writes
+	// through NewWriter's single connection, so writers waiting their turn
+	// never tie up these.
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
 
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
@@ -84,5 +250,56 @@ func New(dataDir string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to create schema: %w", err)
 	}
 
+	if err := migrate(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return db, nil
+}
+
+// NewWriter opens a second pool on the SQLite database New opened in
+// dataDir, with a single connection, for all writes. SQLite runs one write
+// at a time; a writer waiting its turn in the busy timeout would hold a
+// connection all the while, and enough of them would leave none for the
+// readers. With their own pool they queue for its one connection instead.
+func NewWriter(dataDir string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", Path(dataDir)+"?"+sqliteOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
 	return db, nil
 }
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package diff
+
+import "strings"
+
+// Op says what happened to a line between the old and new text.
+type Op byte
+
+const (
+	Equal  Op = ' '
+	Insert Op = '+'
+	Delete Op = '-'
+)
+
+// String names the op, e.g. for use as a CSS class.
+func (o Op) String() string {
+	switch o {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Lines computes a minimal line diff turning a into b with Myers'
+// algorithm, in its linear space form: the memory used grows with the
+// length of the texts rather than with their product, and the time mostly
+// with the number of lines changed.
+func Lines(a, b []string) []Line {
+	// Compare lines by number rather than by text
+	ids := map[string]int{}
+	number := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := &differ{a: number(a), b: number(b), textA: a, textB: b}
+	d.diff(0, len(a), 0, len(b))
+	return d.lines
+}
+
+type differ struct {
+	a, b         []int
+	textA, textB []string
+	lines        []Line
+}
+
+// diff appends the diff of a[aLo:aHi] and b[bLo:bHi].
+func (d *differ) diff(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.lines = append(d.lines, Line{Equal, d.textA[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	if x, y, ok := d.split(aLo, aHi, bLo, bHi); ok {
+		d.diff(aLo, x, bLo, y)
+		d.diff(x, aHi, y, bHi)
+	} else {
+		for i := aLo; i < aHi; i++ {
+			d.lines = append(d.lines, Line{Delete, d.textA[i]})
+		}
+		for j := bLo; j < bHi; j++ {
+			d.lines = append(d.lines, Line{Insert, d.textB[j]})
+		}
+	}
+
+	for i := aHi; i < aHi+suffix; i++ {
+		d.lines = append(d.lines, Line{Equal, d.textA[i]})
+	}
+}
+
+// split finds the middle of a shortest edit script turning a[aLo:aHi] into
+// b[bLo:bHi], which share neither their first nor their last line, by
+// searching from both ends at once until the paths meet. It reports false
+// when one range is empty or the two have no line in common, leaving
+// nothing to split.
+func (d *differ) split(aLo, aHi, bLo, bHi int) (x, y int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	// forward[offset+k] is the furthest x reached on diagonal k = x-y from
+	// the start, backward the same from the end; -1 where not reached yet
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	// With an odd delta the forward paths meet the backward ones
+	odd := delta%2 != 0
+	// Diagonals that left the grid need no further search
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+	for step := 0; step < maxD; step++ {
+		for k := -step + fStart; k <= step-fEnd; k += 2 {
+			i := offset + k
+			var fx int
+			if k == -step || (k != step && forward[i-1] < forward[i+1]) {
+				fx = forward[i+1]
+			} else {
+				fx = forward[i-1] + 1
+			}
+			fy := fx - k
+			for fx < n && fy < m && d.a[aLo+fx] == d.b[bLo+fy] {
+				fx++
+				fy++
+			}
+			forward[i] = fx
+			switch {
+			case fx > n:
+				fEnd += 2
+			case fy > m:
+				fStart += 2
+			case odd:
+				j := offset + delta - k
+				if j >= 0 && j < len(backward) && backward[j] != -1 && fx >= n-backward[j] {
+					return aLo + fx, bLo + fy, true
+				}
+			}
+		}
+
+		for k := -step + bStart; k <= step-bEnd; k += 2 {
+			i := offset + k
+			var bx int
+			if k == -step || (k != step && backward[i-1] < backward[i+1]) {
+				bx = backward[i+1]
+			} else {
+				bx = backward[i-1] + 1
+			}
+			by := bx - k
+			for bx < n && by < m && d.a[aHi-bx-1] == d.b[bHi-by-1] {
+				bx++
+				by++
+			}
+			backward[i] = bx
+			switch {
+			case bx > n:
+				bEnd += 2
+			case by > m:
+				bStart += 2
+			case !odd:
+				j := offset + delta - k
+				if j >= 0 && j < len(forward) && forward[j] != -1 {
+					fx := forward[j]
+					fy := offset + fx - j
+					if fx >= n-bx {
+						return aLo + fx, bLo + fy, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Changed reports whether the diff contains any insertions or deletions.
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// Hunks groups the changed lines of a diff with up to context unchanged lines
+// on either side, dropping the unchanged stretches in between.
+func Hunks(lines []Line, context int) [][]Line {
+	keep := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.Op == Equal {
+			continue
+		}
+		for j := max(0, i-context); j <= min(len(lines)-1, i+context); j++ {
+			keep[j] = true
+		}
+	}
+
+	var hunks [][]Line
+	var hunk []Line
+	for i, l := range lines {
+		if keep[i] {
+			hunk = append(hunk, l)
+		} else if hunk != nil {
+			hunks = append(hunks, hunk)
+			hunk = nil
+		}
+	}
+	if hunk != nil {
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+// Format renders a diff as text, one line per entry prefixed by its op.
+// Lines may not contain newlines themselves.
+func Format(lines []Line) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteByte(byte(l.Op))
+		b.WriteByte(' ')
+		b.WriteString(l.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Parse reads back a diff written by Format.
+func Parse(s string) []Line {
+	var lines []Line
+	for _, row := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		if len(row) < 2 {
+			continue
+		}
+		lines = append(lines, Line{Op(row[0]), row[2:]})
+	}
+	return lines
+}
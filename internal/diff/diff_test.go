@@ -0,0 +1,112 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// lcsLength is the length of the longest common subsequence of a and b,
+// computed the quadratic way.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// sides returns the old and new text a diff was made from, and the number
+// of lines it changes.
+func sides(lines []Line) (a, b []string, edits int) {
+	a, b = []string{}, []string{}
+	for _, l := range lines {
+		if l.Op != Insert {
+			a = append(a, l.Text)
+		}
+		if l.Op != Delete {
+			b = append(b, l.Text)
+		}
+		if l.Op != Equal {
+			edits++
+		}
+	}
+	return a, b, edits
+}
+
+func checkDiff(t *testing.T, a, b []string) {
+	t.Helper()
+	gotA, gotB, edits := sides(Lines(a, b))
+	if !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) {
+		t.Fatalf("diff of %q and %q does not give them back: %q, %q", a, b, gotA, gotB)
+	}
+	if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+		t.Fatalf("diff of %q and %q changes %d lines, want %d", a, b, edits, want)
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want []Line
+	}{
+		{nil, nil, nil},
+		{[]string{"a"}, []string{"a"}, []Line{{Equal, "a"}}},
+		{nil, []string{"a"}, []Line{{Insert, "a"}}},
+		{[]string{"a", "b", "c"}, []string{"a", "x", "c"}, []Line{{Equal, "a"}, {Delete, "b"}, {Insert, "x"}, {Equal, "c"}}},
+		{[]string{"a", "b"}, []string{"b", "c"}, []Line{{Delete, "a"}, {Equal, "b"}, {Insert, "c"}}},
+	}
+	for _, tt := range tests {
+		if got := Lines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lines(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLinesRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	text := func() []string {
+		lines := make([]string, rng.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 5000; i++ {
+		checkDiff(t, text(), text())
+	}
+}
+
+// Long pages with a few edits must not need memory for every pair of lines.
+func TestLinesLong(t *testing.T) {
+	const n = 50000
+	a := make([]string, n)
+	for i := range a {
+		a[i] = fmt.Sprintf("line %d", i)
+	}
+	b := append([]string{}, a...)
+	b[10] = "changed"
+	b = append(b[:n/2], b[n/2+5:]...)
+	b = append(b, "added")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	lines := Lines(a, b)
+	runtime.ReadMemStats(&after)
+	if _, _, edits := sides(lines); edits != 8 {
+		t.Errorf("changed %d lines, want 8", edits)
+	}
+	if used := after.TotalAlloc - before.TotalAlloc; used > 64<<20 {
+		t.Errorf("allocated %d MB", used>>20)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// activityLimit caps how many events the timeline shows at once.
+const activityLimit = 200
+
+type ActivityHandler struct {
+	repo repository.Store
+	tmpl *template.Template
+}
+
+func NewActivityHandler(repo repository.Store, tmpl *template.Template) *ActivityHandler {
+	return &ActivityHandler{repo: repo, tmpl: tmpl}
+}
+
+// List shows the activity log, newest first. It can be narrowed to an
+// entity type, action or actor, and with an id to a single entity's history.
+func (h *ActivityHandler) List(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := repository.EventFilter{
+		Entity: q.Get("entity"),
+		Action: q.Get("action"),
+		Actor:  q.Get("actor"),
+		Limit:  activityLimit,
+	}
+	if filter.Entity != "" && !slices.Contains(models.Entities, filter.Entity) {
+		http.Error(w, "Unknown entity", http.StatusBadRequest)
+		return
+	}
+	if str := q.Get("id"); str != "" {
+		id, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		filter.EntityID = id
+	}
+
+	events, err := h.repo.GetEvents(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	actors, err := h.repo.GetEventActors()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Events":   events,
+		"Filter":   filter,
+		"Entities": models.Entities,
+		"Actions":  models.Actions,
+		"Actors":   actors,
+		"Limited":  len(events) == activityLimit,
+	}
+
+	if isHTMX(r) && r.Header.Get("HX-Target") == "event-list" {
+		h.tmpl.ExecuteTemplate(w, "event-list", data)
+		return
+	}
+	h.tmpl.ExecuteTemplate(w, "activity.html", data)
+}
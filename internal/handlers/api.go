@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lehmann314159/bookmarks/internal/api"
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// apiActor is who changes made through the API are attributed to in the
+// activity log.
+const apiActor = "api"
+
+// maxImportSize caps the export or bookmarks file an import reads.
+const maxImportSize = 32 << 20
+
+// APIHandler serves the JSON API used by the command-line client. Errors
+// are plain text, like everywhere else.
+type APIHandler struct {
+	repo     repository.Store
+	pages    *PageHandler     // for archiving and extracting added pages
+	provider archive.Provider // for archived copies of broken links
+}
+
+func NewAPIHandler(repo repository.Store, pages *PageHandler, provider archive.Provider) *APIHandler {
+	return &APIHandler{repo: repo.As(apiActor), pages: pages, provider: provider}
+}
+
+// ListPages lists pages matching q, carrying every tag named by a tag
+// parameter, with site, status, sort, limit and offset as on /pages.
+func (h *APIHandler) ListPages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := api.ListOptions{
+		Query:  strings.TrimSpace(query.Get("q")),
+		Tags:   query["tag"],
+		Status: query.Get("status"),
+		Sort:   query.Get("sort"),
+	}
+	opts.SiteID, _ = strconv.ParseInt(query.Get("site"), 10, 64)
+	opts.Limit, _ = strconv.Atoi(query.Get("limit"))
+	opts.Offset, _ = strconv.Atoi(query.Get("offset"))
+
+	list, err := api.ListPages(h.repo, opts)
+	if errors.Is(err, api.ErrUnknownTag) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// AddPage bookmarks the URL in an api.AddRequest body.
+func (h *APIHandler) AddPage(w http.ResponseWriter, r *http.Request) {
+	var req api.AddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	site, page, err := api.Add(h.repo, req)
+	if err != nil {
+		http.Error(w, err.Error(), addErrorStatus(err))
+		return
+	}
+
+	added := api.Added{Site: api.NewSite(*site)}
+	if page != nil {
+		h.pages.archiveInBackground(page.ID)
+		h.pages.extractInBackground(page.ID)
+		p := api.NewPage(*page)
+		added.Page = &p
+	}
+	writeJSON(w, http.StatusCreated, added)
+}
+
+// EditPage applies an api.Edit body to a page.
+func (h *APIHandler) EditPage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	var edit api.Edit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := api.EditPage(h.repo, id, edit)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, api.NewPage(*page))
+}
+
+func (h *APIHandler) ListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := api.ListCategories(h.repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, categories)
+}
+
+func (h *APIHandler) ListSites(w http.ResponseWriter, r *http.Request) {
+	sites, err := api.ListSites(h.repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, sites)
+}
+
+// EditSite applies an api.Edit body to a site.
+func (h *APIHandler) EditSite(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	var edit api.Edit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	site, err := api.EditSite(h.repo, id, edit)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, api.NewSite(*site))
+}
+
+func (h *APIHandler) Search(w http.ResponseWriter, r *http.Request) {
+	results, err := api.Search(h.repo, strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// Import adds the contents of an export or a browser's bookmarks file
+// sent as the request body.
+func (h *APIHandler) Import(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	doc, err := api.ParseImport(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.Import(h.repo, doc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// CheckLinks fetches every page and flags the ones gone for good, linking
+// them to archived copies. The concurrency parameter sets how many pages
+// are fetched at a time. The request blocks until every page has been
+// fetched, which can take minutes on a large collection, so clients must
+// allow for that in their timeout.
+func (h *APIHandler) CheckLinks(w http.ResponseWriter, r *http.Request) {
+	concurrency, err := strconv.Atoi(r.URL.Query().Get("concurrency"))
+	if err != nil {
+		concurrency = 8
+	}
+
+	results, err := api.CheckLinks(h.repo, h.provider, concurrency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// MergeTags merges the tags named in an api.TagMerge body.
+func (h *APIHandler) MergeTags(w http.ResponseWriter, r *http.Request) {
+	var merge api.TagMerge
+	if err := json.NewDecoder(r.Body).Decode(&merge); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := api.MergeTags(h.repo, merge)
+	if errors.Is(err, api.ErrUnknownTag) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), tagErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// ArchiveProviderHandler finds and requests copies of pages in an external
+// archive such as the Wayback Machine
+type ArchiveProviderHandler struct {
+	repo     repository.Store
+	provider archive.Provider
+	tmpl     *template.Template
+}
+
+func NewArchiveProviderHandler(repo repository.Store, provider archive.Provider, tmpl *template.Template) *ArchiveProviderHandler {
+	return &ArchiveProviderHandler{repo: repo, provider: provider, tmpl: tmpl}
+}
+
+// Lookup records the provider's closest existing copy of the page
+func (h *ArchiveProviderHandler) Lookup(w http.ResponseWriter, r *http.Request) {
+	h.handle(w, r, h.provider.Lookup)
+}
+
+// Save asks the provider to capture the page now and records the new copy
+func (h *ArchiveProviderHandler) Save(w http.ResponseWriter, r *http.Request) {
+	h.handle(w, r, h.provider.Save)
+}
+
+func (h *ArchiveProviderHandler) handle(w http.ResponseWriter, r *http.Request, fetch func(string) (*archive.Snapshot, error)) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	snapshot, err := fetch(page.URL())
+	if errors.Is(err, archive.ErrNotArchived) {
+		http.Error(w, h.provider.Name()+" has no copy of this page", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, h.provider.Name()+": "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if err := h.repo.SetPageExternalArchive(id, snapshot.URL, snapshot.Timestamp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		page, _ = h.repo.GetPage(id)
+		h.tmpl.ExecuteTemplate(w, "page-row", page)
+	} else {
+		http.Redirect(w, r, snapshot.URL, http.StatusSeeOther)
+	}
+}
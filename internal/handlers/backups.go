@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/lehmann314159/bookmarks/internal/backup"
+)
+
+// BackupHandler lists, takes and serves backups of the SQLite database
+type BackupHandler struct {
+	backups *backup.Manager
+	tmpl    *template.Template
+}
+
+func NewBackupHandler(backups *backup.Manager, tmpl *template.Template) *BackupHandler {
+	return &BackupHandler{backups: backups, tmpl: tmpl}
+}
+
+// RequireAdmin guards admin endpoints with HTTP basic auth, checking the
+// password against token and ignoring the user name. An empty token lets
+// no one in.
+func RequireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, password, ok := r.BasicAuth()
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(password), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bookmarks admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// List shows the backups kept, newest first
+func (h *BackupHandler) List(w http.ResponseWriter, r *http.Request) {
+	backups, err := h.backups.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Backups": backups,
+	}
+
+	if isHTMX(r) {
+		h.tmpl.ExecuteTemplate(w, "backup-list", data)
+	} else {
+		h.tmpl.ExecuteTemplate(w, "backups.html", data)
+	}
+}
+
+// Create takes a backup now. With download set it responds with the backup
+// itself, so that a script can take and fetch one in a single request.
+func (h *BackupHandler) Create(w http.ResponseWriter, r *http.Request) {
+	b, err := h.backups.Create()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("download") != "" {
+		h.serve(w, r, b.Name)
+		return
+	}
+	if isHTMX(r) {
+		h.List(w, r)
+	} else {
+		http.Redirect(w, r, "/admin/backups", http.StatusSeeOther)
+	}
+}
+
+// Download serves a backup as an attachment
+func (h *BackupHandler) Download(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, r.PathValue("name"))
+}
+
+func (h *BackupHandler) serve(w http.ResponseWriter, r *http.Request, name string) {
+	path, err := h.backups.Path(name)
+	if errors.Is(err, backup.ErrNotFound) {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeFile(w, r, path)
+}
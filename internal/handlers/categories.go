@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"html/template"
 	"net/http"
 	"strconv"
@@ -9,11 +10,11 @@ import (
 )
 
 type CategoryHandler struct {
-	repo *repository.Repository
+	repo repository.Store
 	tmpl *template.Template
 }
 
-func NewCategoryHandler(repo *repository.Repository, tmpl *template.Template) *CategoryHandler {
+func NewCategoryHandler(repo repository.Store, tmpl *template.Template) *CategoryHandler {
 	return &CategoryHandler{repo: repo, tmpl: tmpl}
 }
 
@@ -43,21 +44,20 @@ func (h *CategoryHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	name := r.FormValue("name")
 	description := r.FormValue("description")
+	parentID := parseParentID(r)
 
 	if name == "" {
 		http.Error(w, "Name is required", http.StatusBadRequest)
 		return
 	}
 
-	id, err := h.repo.CreateCategory(name, description)
-	if err != nil {
+	if _, err := h.repo.CreateCategory(name, description, parentID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if isHTMX(r) {
-		category, _ := h.repo.GetCategory(id)
-		h.tmpl.ExecuteTemplate(w, "category-row", category)
+		h.renderTree(w)
 	} else {
 		http.Redirect(w, r, "/categories", http.StatusSeeOther)
 	}
@@ -76,7 +76,18 @@ func (h *CategoryHandler) Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.tmpl.ExecuteTemplate(w, "category-edit-form", category)
+	categories, err := h.repo.GetCategories()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Category":   category,
+		"Categories": categories,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "category-edit-form", data)
 }
 
 func (h *CategoryHandler) Update(w http.ResponseWriter, r *http.Request) {
@@ -93,20 +104,25 @@ func (h *CategoryHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	name := r.FormValue("name")
 	description := r.FormValue("description")
+	parentID := parseParentID(r)
 
 	if name == "" {
 		http.Error(w, "Name is required", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.repo.UpdateCategory(id, name, description); err != nil {
+	if err := h.repo.UpdateCategory(id, name, description, parentID); err != nil {
+		if errors.Is(err, repository.ErrCategoryCycle) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if isHTMX(r) {
-		category, _ := h.repo.GetCategory(id)
-		h.tmpl.ExecuteTemplate(w, "category-row", category)
+		// Moving a category can reorder the whole tree
+		h.renderTree(w)
 	} else {
 		http.Redirect(w, r, "/categories", http.StatusSeeOther)
 	}
@@ -131,6 +147,34 @@ func (h *CategoryHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// renderTree re-renders the full category table in place of whatever the
+// request targeted, since a new or moved category can land anywhere in it.
+func (h *CategoryHandler) renderTree(w http.ResponseWriter) {
+	categories, err := h.repo.GetCategories()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Categories": categories,
+	}
+
+	w.Header().Set("HX-Retarget", "#category-table tbody")
+	w.Header().Set("HX-Reswap", "innerHTML")
+	h.tmpl.ExecuteTemplate(w, "category-list", data)
+}
+
+func parseParentID(r *http.Request) *int64 {
+	if str := r.FormValue("parent_id"); str != "" {
+		id, err := strconv.ParseInt(str, 10, 64)
+		if err == nil {
+			return &id
+		}
+	}
+	return nil
+}
+
 func isHTMX(r *http.Request) bool {
 	return r.Header.Get("HX-Request") == "true"
 }
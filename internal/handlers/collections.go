@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+type CollectionHandler struct {
+	repo repository.Store
+	tmpl *template.Template
+}
+
+func NewCollectionHandler(repo repository.Store, tmpl *template.Template) *CollectionHandler {
+	return &CollectionHandler{repo: repo, tmpl: tmpl}
+}
+
+func (h *CollectionHandler) List(w http.ResponseWriter, r *http.Request) {
+	collections, err := h.repo.GetCollections()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Collections": collections,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "collections.html", data)
+}
+
+func (h *CollectionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	description := r.FormValue("description")
+
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	id, err := h.repo.CreateCollection(name, description)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		collection, _ := h.repo.GetCollection(id)
+		h.tmpl.ExecuteTemplate(w, "collection-card", collection)
+	} else {
+		http.Redirect(w, r, "/collections/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
+	}
+}
+
+// Show renders a collection for editing, along with everything that could
+// be added to it
+func (h *CollectionHandler) Show(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	collection, err := h.repo.GetCollection(id)
+	if err != nil {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	sites, err := h.repo.GetSites(repository.SiteFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pages, err := h.repo.GetPages(repository.PageFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Collection": collection,
+		"Sites":      sites,
+		"Pages":      pages,
+		"ShareURL":   shareURL(r, collection.ShareToken),
+	}
+
+	h.tmpl.ExecuteTemplate(w, "collection.html", data)
+}
+
+func (h *CollectionHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	description := r.FormValue("description")
+
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.UpdateCollection(id, name, description); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		collection, _ := h.repo.GetCollection(id)
+		h.tmpl.ExecuteTemplate(w, "collection-header", collection)
+	} else {
+		http.Redirect(w, r, "/collections/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
+	}
+}
+
+func (h *CollectionHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.DeleteCollection(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Redirect(w, r, "/collections", http.StatusSeeOther)
+	}
+}
+
+// AddItem appends a site or page, submitted as "site:ID" or "page:ID"
+func (h *CollectionHandler) AddItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kind, str, _ := strings.Cut(r.FormValue("item"), ":")
+	itemID, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		http.Error(w, "Choose a site or page", http.StatusBadRequest)
+		return
+	}
+
+	var siteID, pageID *int64
+	switch kind {
+	case "site":
+		siteID = &itemID
+	case "page":
+		pageID = &itemID
+	default:
+		http.Error(w, "Choose a site or page", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.repo.AddCollectionItem(id, siteID, pageID, strings.TrimSpace(r.FormValue("note"))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.respond(w, r, id)
+}
+
+// UpdateItem changes the note attached to an item
+func (h *CollectionHandler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	id, itemID, ok := parseItemPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.UpdateCollectionItemNote(id, itemID, strings.TrimSpace(r.FormValue("note"))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.respond(w, r, id)
+}
+
+func (h *CollectionHandler) RemoveItem(w http.ResponseWriter, r *http.Request) {
+	id, itemID, ok := parseItemPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.RemoveCollectionItem(id, itemID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Redirect(w, r, "/collections/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
+	}
+}
+
+// Reorder saves the item order left behind by dragging, submitted as one
+// "item" value per item from top to bottom
+func (h *CollectionHandler) Reorder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.ReorderCollection(id, parseIDs(r.PostForm["item"])); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.respond(w, r, id)
+}
+
+// Share publishes a read-only view of the collection under a new random link
+func (h *CollectionHandler) Share(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.repo.SetCollectionShareToken(id, hex.EncodeToString(token)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.respondShare(w, r, id)
+}
+
+// Unshare revokes the collection's public link
+func (h *CollectionHandler) Unshare(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.SetCollectionShareToken(id, ""); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.respondShare(w, r, id)
+}
+
+// Shared is the public, read-only view of a shared collection
+func (h *CollectionHandler) Shared(w http.ResponseWriter, r *http.Request) {
+	collection, err := h.repo.GetSharedCollection(r.PathValue("token"))
+	if err != nil {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	h.tmpl.ExecuteTemplate(w, "shared-collection.html", map[string]interface{}{"Collection": collection})
+}
+
+// respond re-renders the item list after a change, or sends non-HTMX
+// clients back to the collection
+func (h *CollectionHandler) respond(w http.ResponseWriter, r *http.Request, id int64) {
+	if !isHTMX(r) {
+		http.Redirect(w, r, "/collections/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
+		return
+	}
+
+	collection, err := h.repo.GetCollection(id)
+	if err != nil {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	h.tmpl.ExecuteTemplate(w, "collection-items", collection)
+}
+
+func (h *CollectionHandler) respondShare(w http.ResponseWriter, r *http.Request, id int64) {
+	if !isHTMX(r) {
+		http.Redirect(w, r, "/collections/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
+		return
+	}
+
+	collection, err := h.repo.GetCollection(id)
+	if err != nil {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	data := map[string]interface{}{
+		"Collection": collection,
+		"ShareURL":   shareURL(r, collection.ShareToken),
+	}
+	h.tmpl.ExecuteTemplate(w, "collection-share", data)
+}
+
+func parseItemPath(w http.ResponseWriter, r *http.Request) (id, itemID int64, ok bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	itemID, err = strconv.ParseInt(r.PathValue("item"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return id, itemID, true
+}
+
+// shareURL is the absolute public link for a share token, if there is one
+func shareURL(r *http.Request, token string) string {
+	if token == "" {
+		return ""
+	}
+	return baseURL(r) + "/shared/" + token
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lehmann314159/bookmarks/internal/api"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+type ExportHandler struct {
+	repo repository.Store
+}
+
+func NewExportHandler(repo repository.Store) *ExportHandler {
+	return &ExportHandler{repo: repo}
+}
+
+// Export downloads every category, site and page, with their tags and
+// notes, as a single JSON document
+func (h *ExportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	out, err := api.NewExport(h.repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="bookmarks-`+out.ExportedAt.Format("2006-01-02")+`.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// FavoriteHandler stars, pins and rates pages and sites
+type FavoriteHandler struct {
+	repo repository.Store
+	tmpl *template.Template
+}
+
+func NewFavoriteHandler(repo repository.Store, tmpl *template.Template) *FavoriteHandler {
+	return &FavoriteHandler{repo: repo, tmpl: tmpl}
+}
+
+func (h *FavoriteHandler) PageFavorite(w http.ResponseWriter, r *http.Request) {
+	h.updatePage(w, r, func(id int64) error {
+		return h.repo.SetPageFavorite(id, r.FormValue("favorite") == "1")
+	})
+}
+
+// PagePin pins a page to the dashboard, or unpins it. Unpinning from the
+// dashboard itself (view=dashboard) removes the item there.
+func (h *FavoriteHandler) PagePin(w http.ResponseWriter, r *http.Request) {
+	h.updatePage(w, r, func(id int64) error {
+		return h.repo.SetPagePinned(id, r.FormValue("pinned") == "1")
+	})
+}
+
+// PageRating sets a page's stars; a rating of 0 clears it
+func (h *FavoriteHandler) PageRating(w http.ResponseWriter, r *http.Request) {
+	h.updatePage(w, r, func(id int64) error {
+		return h.repo.SetPageRating(id, formRating(r))
+	})
+}
+
+func (h *FavoriteHandler) SiteFavorite(w http.ResponseWriter, r *http.Request) {
+	h.updateSite(w, r, func(id int64) error {
+		return h.repo.SetSiteFavorite(id, r.FormValue("favorite") == "1")
+	})
+}
+
+func (h *FavoriteHandler) SitePin(w http.ResponseWriter, r *http.Request) {
+	h.updateSite(w, r, func(id int64) error {
+		return h.repo.SetSitePinned(id, r.FormValue("pinned") == "1")
+	})
+}
+
+func (h *FavoriteHandler) SiteRating(w http.ResponseWriter, r *http.Request) {
+	h.updateSite(w, r, func(id int64) error {
+		return h.repo.SetSiteRating(id, formRating(r))
+	})
+}
+
+// updatePage applies a change to the page in the path and re-renders its row
+func (h *FavoriteHandler) updatePage(w http.ResponseWriter, r *http.Request, update func(id int64) error) {
+	id, ok := h.apply(w, r, update)
+	if !ok {
+		return
+	}
+
+	if !isHTMX(r) {
+		http.Redirect(w, r, "/pages", http.StatusSeeOther)
+		return
+	}
+	if r.FormValue("view") == "dashboard" {
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	h.tmpl.ExecuteTemplate(w, "page-row", page)
+}
+
+// updateSite applies a change to the site in the path and re-renders its
+// controls
+func (h *FavoriteHandler) updateSite(w http.ResponseWriter, r *http.Request, update func(id int64) error) {
+	id, ok := h.apply(w, r, update)
+	if !ok {
+		return
+	}
+
+	if !isHTMX(r) {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther)
+		return
+	}
+	if r.FormValue("view") == "dashboard" {
+		return
+	}
+
+	site, err := h.repo.GetSite(id)
+	if err != nil {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+	h.tmpl.ExecuteTemplate(w, "site-marks", site)
+}
+
+func (h *FavoriteHandler) apply(w http.ResponseWriter, r *http.Request, update func(id int64) error) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+
+	if err := update(id); err != nil {
+		if errors.Is(err, repository.ErrInvalidRating) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return 0, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	return id, true
+}
+
+// formRating reads the submitted rating, mapping anything unparseable to an
+// out-of-range value so that it is rejected rather than clearing the rating
+func formRating(r *http.Request) int {
+	rating, err := strconv.Atoi(r.FormValue("rating"))
+	if err != nil {
+		return -1
+	}
+	return rating
+}
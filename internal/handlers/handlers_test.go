@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lehmann314159/bookmarks/internal/undo"
+)
+
+// testTemplates parses the app's templates once for every test.
+var testTemplates *template.Template
+
+func templatesForTest(t *testing.T) *template.Template {
+	t.Helper()
+	if testTemplates == nil {
+		tmpl, err := ParseTemplates("../../templates/*.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testTemplates = tmpl
+	}
+	return testTemplates
+}
+
+func newUndos() *undo.Store {
+	return undo.New(undo.DefaultWindow)
+}
+
+// serve sends a request through mux as htmx does, with form as its body
+// when given, and returns the response.
+func serve(t *testing.T, mux http.Handler, method, target string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	r.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+// wantResponse fails the test unless w has the status code and its body
+// contains each of the strings in body.
+func wantResponse(t *testing.T, w *httptest.ResponseRecorder, code int, body ...string) {
+	t.Helper()
+	if w.Code != code {
+		t.Fatalf("status %d, want %d; body:\n%s", w.Code, code, w.Body)
+	}
+	for _, s := range body {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("body does not contain %q:\n%s", s, w.Body)
+		}
+	}
+}
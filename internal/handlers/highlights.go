@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+type HighlightHandler struct {
+	repo repository.Store
+	tmpl *template.Template
+}
+
+func NewHighlightHandler(repo repository.Store, tmpl *template.Template) *HighlightHandler {
+	return &HighlightHandler{repo: repo, tmpl: tmpl}
+}
+
+// List shows highlights from every page, narrowed by an optional search
+func (h *HighlightHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	highlights, err := h.repo.GetHighlights(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Highlights": highlights,
+		"Query":      query,
+	}
+
+	if isHTMX(r) && r.Header.Get("HX-Target") == "highlight-list" {
+		h.tmpl.ExecuteTemplate(w, "highlight-list", data)
+		return
+	}
+	h.tmpl.ExecuteTemplate(w, "highlights.html", data)
+}
+
+// Export downloads the highlights matching the search as Markdown
+func (h *HighlightHandler) Export(w http.ResponseWriter, r *http.Request) {
+	highlights, err := h.repo.GetHighlights(strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Group by page, keeping pages in the order their newest highlight appears
+	var order []int64
+	byPage := map[int64][]models.Highlight{}
+	for _, hl := range highlights {
+		if _, ok := byPage[hl.PageID]; !ok {
+			order = append(order, hl.PageID)
+		}
+		byPage[hl.PageID] = append(byPage[hl.PageID], hl)
+	}
+
+	setMarkdownHeaders(w, "highlights.md")
+	fmt.Fprint(w, "# Highlights\n")
+	for _, pageID := range order {
+		fmt.Fprint(w, "\n")
+		writeHighlightsMarkdown(w, "##", byPage[pageID])
+	}
+}
+
+// PageHighlights lists one page's highlights
+func (h *HighlightHandler) PageHighlights(w http.ResponseWriter, r *http.Request) {
+	page, highlights, ok := h.lookupPage(w, r)
+	if !ok {
+		return
+	}
+
+	data := map[string]interface{}{
+		"Page":       page,
+		"Highlights": highlights,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "highlights.html", data)
+}
+
+// PageExport downloads one page's highlights as Markdown
+func (h *HighlightHandler) PageExport(w http.ResponseWriter, r *http.Request) {
+	page, highlights, ok := h.lookupPage(w, r)
+	if !ok {
+		return
+	}
+
+	setMarkdownHeaders(w, "highlights-"+strconv.FormatInt(page.ID, 10)+".md")
+	if len(highlights) == 0 {
+		fmt.Fprintf(w, "# [%s](%s)\n\nNo highlights yet.\n", pageTitle(page), page.URL())
+		return
+	}
+	writeHighlightsMarkdown(w, "#", highlights)
+}
+
+// Create saves a passage selected in the reader view, or pasted from the
+// archived copy
+func (h *HighlightHandler) Create(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	text := strings.TrimSpace(r.FormValue("text"))
+	if text == "" {
+		http.Error(w, "Select some text to highlight", http.StatusBadRequest)
+		return
+	}
+	source := r.FormValue("source")
+	if source != models.HighlightArchive {
+		source = models.HighlightReader
+	}
+
+	if _, err := h.repo.GetPage(id); err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	highlightID, err := h.repo.CreateHighlight(id, text, strings.TrimSpace(r.FormValue("comment")), source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		highlight, _ := h.repo.GetHighlight(highlightID)
+		h.tmpl.ExecuteTemplate(w, "highlight-row", highlight)
+	} else {
+		http.Redirect(w, r, "/pages/"+strconv.FormatInt(id, 10)+"/highlights", http.StatusSeeOther)
+	}
+}
+
+func (h *HighlightHandler) Edit(w http.ResponseWriter, r *http.Request) {
+	highlight, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	h.tmpl.ExecuteTemplate(w, "highlight-edit-form", highlight)
+}
+
+// Update changes a highlight's comment; the passage itself is fixed
+func (h *HighlightHandler) Update(w http.ResponseWriter, r *http.Request) {
+	highlight, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	if err := h.repo.UpdateHighlightComment(highlight.ID, comment); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	highlight.Comment = comment
+
+	h.tmpl.ExecuteTemplate(w, "highlight-row", highlight)
+}
+
+func (h *HighlightHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	highlight, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.DeleteHighlight(highlight.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Redirect(w, r, "/pages/"+strconv.FormatInt(highlight.PageID, 10)+"/highlights", http.StatusSeeOther)
+	}
+}
+
+func (h *HighlightHandler) lookup(w http.ResponseWriter, r *http.Request) (*models.Highlight, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	highlight, err := h.repo.GetHighlight(id)
+	if err != nil {
+		http.Error(w, "Highlight not found", http.StatusNotFound)
+		return nil, false
+	}
+	return highlight, true
+}
+
+func (h *HighlightHandler) lookupPage(w http.ResponseWriter, r *http.Request) (*models.Page, []models.Highlight, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	highlights, err := h.repo.GetPageHighlights(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return page, highlights, true
+}
+
+func setMarkdownHeaders(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+}
+
+// writeHighlightsMarkdown writes a heading linking to the page the
+// highlights come from, then each passage as a blockquote followed by its
+// comment.
+func writeHighlightsMarkdown(w io.Writer, heading string, highlights []models.Highlight) {
+	first := highlights[0]
+	title := first.PageTitle
+	if title == "" {
+		title = first.SiteDomain + first.PagePath
+	}
+	fmt.Fprintf(w, "%s [%s](%s)\n", heading, title, first.PageURL())
+
+	for _, hl := range highlights {
+		fmt.Fprint(w, "\n")
+		for _, line := range strings.Split(hl.Text, "\n") {
+			fmt.Fprintf(w, "> %s\n", strings.TrimRight(line, " \t\r"))
+		}
+		if hl.Comment != "" {
+			fmt.Fprintf(w, "\n%s\n", hl.Comment)
+		}
+	}
+}
@@ -8,11 +8,11 @@ import (
 )
 
 type HomeHandler struct {
-	repo *repository.Repository
+	repo repository.Store
 	tmpl *template.Template
 }
 
-func NewHomeHandler(repo *repository.Repository, tmpl *template.Template) *HomeHandler {
+func NewHomeHandler(repo repository.Store, tmpl *template.Template) *HomeHandler {
 	return &HomeHandler{repo: repo, tmpl: tmpl}
 }
 
@@ -23,8 +23,15 @@ func (h *HomeHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	searches, err := savedSearchesWithCounts(h.repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	data := map[string]interface{}{
-		"Stats": stats,
+		"Stats":         stats,
+		"SavedSearches": searches,
 	}
 
 	h.tmpl.ExecuteTemplate(w, "index.html", data)
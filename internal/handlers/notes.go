@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+type NoteHandler struct {
+	repo repository.Store
+	tmpl *template.Template
+}
+
+func NewNoteHandler(repo repository.Store, tmpl *template.Template) *NoteHandler {
+	return &NoteHandler{repo: repo, tmpl: tmpl}
+}
+
+// noteSubject is what a note is attached to, as shown in the notes view
+type noteSubject struct {
+	Title  string
+	URL    string
+	Action string
+	Page   *models.Page
+	Site   *models.Site
+}
+
+// PageNote shows a page's rendered note, its edit form and its history
+func (h *NoteHandler) PageNote(w http.ResponseWriter, r *http.Request) {
+	subject, ok := h.pageSubject(w, r)
+	if !ok {
+		return
+	}
+	note, err := h.repo.GetPageNote(subject.Page.ID)
+	h.render(w, r, "notes.html", subject, note, err)
+}
+
+func (h *NoteHandler) SavePageNote(w http.ResponseWriter, r *http.Request) {
+	subject, ok := h.pageSubject(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.SavePageNote(subject.Page.ID, noteBody(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	note, err := h.repo.GetPageNote(subject.Page.ID)
+	h.respond(w, r, subject, note, err)
+}
+
+// SiteNote shows a site's rendered note, its edit form and its history
+func (h *NoteHandler) SiteNote(w http.ResponseWriter, r *http.Request) {
+	subject, ok := h.siteSubject(w, r)
+	if !ok {
+		return
+	}
+	note, err := h.repo.GetSiteNote(subject.Site.ID)
+	h.render(w, r, "notes.html", subject, note, err)
+}
+
+func (h *NoteHandler) SaveSiteNote(w http.ResponseWriter, r *http.Request) {
+	subject, ok := h.siteSubject(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.SaveSiteNote(subject.Site.ID, noteBody(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	note, err := h.repo.GetSiteNote(subject.Site.ID)
+	h.respond(w, r, subject, note, err)
+}
+
+// Restore makes an earlier revision the note's current text. The restored
+// text is saved as a new revision, so nothing in the history is lost.
+func (h *NoteHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	note, err := h.repo.RestoreNoteRevision(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var subject *noteSubject
+	var ok bool
+	if note.PageID != nil {
+		subject, ok = h.lookupPage(w, *note.PageID)
+	} else {
+		subject, ok = h.lookupSite(w, *note.SiteID)
+	}
+	if !ok {
+		return
+	}
+	h.respond(w, r, subject, note, nil)
+}
+
+func (h *NoteHandler) respond(w http.ResponseWriter, r *http.Request, subject *noteSubject, note *models.Note, err error) {
+	if !isHTMX(r) {
+		http.Redirect(w, r, subject.Action, http.StatusSeeOther)
+		return
+	}
+	h.render(w, r, "note-panel", subject, note, err)
+}
+
+// render executes name with the note and its revisions. A missing note is
+// not an error: the view starts out with an empty editor.
+func (h *NoteHandler) render(w http.ResponseWriter, r *http.Request, name string, subject *noteSubject, note *models.Note, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var revisions []models.NoteRevision
+	if note != nil {
+		revisions, err = h.repo.GetNoteRevisions(note.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := map[string]interface{}{
+		"Subject":   subject,
+		"Note":      note,
+		"Revisions": revisions,
+		"Editing":   r.URL.Query().Get("edit") != "" || note == nil,
+	}
+
+	h.tmpl.ExecuteTemplate(w, name, data)
+}
+
+func (h *NoteHandler) pageSubject(w http.ResponseWriter, r *http.Request) (*noteSubject, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+	return h.lookupPage(w, id)
+}
+
+func (h *NoteHandler) lookupPage(w http.ResponseWriter, id int64) (*noteSubject, bool) {
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &noteSubject{
+		Title:  pageTitle(page),
+		URL:    page.URL(),
+		Action: "/pages/" + strconv.FormatInt(page.ID, 10) + "/notes",
+		Page:   page,
+	}, true
+}
+
+func (h *NoteHandler) siteSubject(w http.ResponseWriter, r *http.Request) (*noteSubject, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+	return h.lookupSite(w, id)
+}
+
+func (h *NoteHandler) lookupSite(w http.ResponseWriter, id int64) (*noteSubject, bool) {
+	site, err := h.repo.GetSite(id)
+	if err != nil {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	title := site.Name
+	if title == "" {
+		title = site.Domain
+	}
+	return &noteSubject{
+		Title:  title,
+		URL:    "https://" + site.Domain,
+		Action: "/sites/" + strconv.FormatInt(site.ID, 10) + "/notes",
+		Site:   site,
+	}, true
+}
+
+// noteBody is the submitted note text with line endings normalized, so
+// resubmitting an unchanged textarea does not create a new revision
+func noteBody(r *http.Request) string {
+	body := strings.ReplaceAll(r.FormValue("body"), "\r\n", "\n")
+	return strings.TrimSpace(body)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Archive takes a fresh snapshot of the page and stores it in the data directory
+func (h *PageHandler) Archive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	size, err := h.archiver.Archive(page.ID, page.URL())
+	if err != nil {
+		http.Error(w, "Archive failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err := h.repo.SetPageArchive(page.ID, size); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		page, _ = h.repo.GetPage(id)
+		h.tmpl.ExecuteTemplate(w, "page-row", page)
+	} else {
+		http.Redirect(w, r, "/pages/"+strconv.FormatInt(id, 10)+"/archive", http.StatusSeeOther)
+	}
+}
+
+// ShowArchive renders the stored snapshot framed by the page's original URL
+func (h *PageHandler) ShowArchive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if page.ArchivedAt == nil {
+		http.Error(w, "Page has not been archived", http.StatusNotFound)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Page": page,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "archive.html", data)
+}
+
+// RawArchive serves the snapshot file itself. It is sandboxed so that
+// whatever the archived markup contains cannot act on this origin.
+func (h *PageHandler) RawArchive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(h.archiver.Path(id))
+	if err != nil {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// archiveInBackground snapshots a newly saved page without holding up the
+// response. Failures are only logged; the page can be archived again by hand.
+func (h *PageHandler) archiveInBackground(pageID int64) {
+	if !h.autoArchive {
+		return
+	}
+	page, err := h.repo.GetPage(pageID)
+	if err != nil {
+		return
+	}
+	go func() {
+		size, err := h.archiver.Archive(page.ID, page.URL())
+		if err != nil {
+			log.Printf("Auto-archive of page %d failed: %v", page.ID, err)
+			return
+		}
+		if err := h.repo.SetPageArchive(page.ID, size); err != nil {
+			log.Printf("Failed to record archive of page %d: %v", page.ID, err)
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/lehmann314159/bookmarks/internal/reader"
+)
+
+// Read shows the page's extracted article text, extracting it first if that
+// has not happened yet
+func (h *PageHandler) Read(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	content, err := h.repo.GetPageContent(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := h.extract(id, page.URL()); err != nil {
+			http.Error(w, "Extraction failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		content, err = h.repo.GetPageContent(id)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	highlights, err := h.repo.GetPageHighlights(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Page":       page,
+		"Content":    content,
+		"Paragraphs": reader.Paragraphs(content.Text),
+		"Highlights": highlights,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "reader.html", data)
+}
+
+// Extract refetches the page and replaces its stored article text
+func (h *PageHandler) Extract(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.extract(id, page.URL()); err != nil {
+		http.Error(w, "Extraction failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, "/pages/"+strconv.FormatInt(id, 10)+"/read", http.StatusSeeOther)
+}
+
+func (h *PageHandler) extract(pageID int64, rawURL string) error {
+	article, err := reader.Fetch(rawURL)
+	if err != nil {
+		return err
+	}
+	return h.repo.SetPageContent(pageID, article.Text)
+}
+
+// extractInBackground indexes the text of a newly saved page so that it is
+// searchable without waiting for someone to open the reader view.
+func (h *PageHandler) extractInBackground(pageID int64) {
+	page, err := h.repo.GetPage(pageID)
+	if err != nil {
+		return
+	}
+	go func() {
+		if err := h.extract(page.ID, page.URL()); err != nil {
+			log.Printf("Text extraction for page %d failed: %v", page.ID, err)
+		}
+	}()
+}
@@ -1,56 +1,52 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"html/template"
-	"io"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/lehmann314159/bookmarks/internal/api"
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/models"
 	"github.com/lehmann314159/bookmarks/internal/repository"
+	"github.com/lehmann314159/bookmarks/internal/undo"
 )
 
 type PageHandler struct {
-	repo *repository.Repository
-	tmpl *template.Template
+	repo        repository.Store
+	tmpl        *template.Template
+	archiver    *archive.Archiver
+	autoArchive bool
+	undos       *undo.Store
 }
 
-func NewPageHandler(repo *repository.Repository, tmpl *template.Template) *PageHandler {
-	return &PageHandler{repo: repo, tmpl: tmpl}
+func NewPageHandler(repo repository.Store, tmpl *template.Template, archiver *archive.Archiver, autoArchive bool, undos *undo.Store) *PageHandler {
+	return &PageHandler{repo: repo, tmpl: tmpl, archiver: archiver, autoArchive: autoArchive, undos: undos}
 }
 
 func (h *PageHandler) List(w http.ResponseWriter, r *http.Request) {
-	var siteID, categoryID, tagID *int64
+	filter := parsePageFilter(r.URL.Query())
 
-	if str := r.URL.Query().Get("site"); str != "" {
-		id, err := strconv.ParseInt(str, 10, 64)
-		if err == nil {
-			siteID = &id
-		}
-	}
-	if str := r.URL.Query().Get("category"); str != "" {
-		id, err := strconv.ParseInt(str, 10, 64)
-		if err == nil {
-			categoryID = &id
-		}
-	}
-	if str := r.URL.Query().Get("tag"); str != "" {
-		id, err := strconv.ParseInt(str, 10, 64)
-		if err == nil {
-			tagID = &id
-		}
+	total, err := h.repo.CountPages(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	pager := newPagination("/pages", r.URL.Query(), total, "pages", "#page-table tbody")
+	filter.Limit = pager.Limit()
+	filter.Offset = pager.Offset()
 
-	pages, err := h.repo.GetPages(siteID, categoryID, tagID)
+	pages, err := h.repo.GetPages(filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sites, err := h.repo.GetSites(nil)
+	sites, err := h.repo.GetSites(repository.SiteFilter{})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -73,9 +69,10 @@ func (h *PageHandler) List(w http.ResponseWriter, r *http.Request) {
 		"Sites":      sites,
 		"Categories": categories,
 		"Tags":       tags,
-		"SiteID":     siteID,
-		"CategoryID": categoryID,
-		"TagID":      tagID,
+		"Filter":     filter,
+		"Statuses":   models.ReadStatuses,
+		"SortOrders": repository.PageSortOrders,
+		"Pager":      pager,
 	}
 
 	if isHTMX(r) {
@@ -91,77 +88,20 @@ func (h *PageHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rawURL := strings.TrimSpace(r.FormValue("url"))
-	title := r.FormValue("title")
-	description := r.FormValue("description")
-
-	if rawURL == "" {
-		http.Error(w, "URL is required", http.StatusBadRequest)
-		return
-	}
-
-	// Parse the URL
-	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
-		rawURL = "https://" + rawURL
-	}
-
-	parsedURL, err := url.Parse(rawURL)
+	_, page, err := api.Add(h.repo, api.AddRequest{
+		URL:         r.FormValue("url"),
+		Title:       r.FormValue("title"),
+		Description: r.FormValue("description"),
+		Tags:        api.SplitTags(r.FormValue("tags")),
+		Later:       r.FormValue("later") != "",
+	})
 	if err != nil {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		http.Error(w, err.Error(), addErrorStatus(err))
 		return
 	}
 
-	domain := parsedURL.Host
-	path := parsedURL.Path
-	if parsedURL.RawQuery != "" {
-		path += "?" + parsedURL.RawQuery
-	}
-	if path == "" {
-		path = "/"
-	}
-
-	// Check if this is a root domain (no specific page)
-	isRootDomain := path == "/" || path == ""
-
-	// Fetch title from page if not provided
-	if title == "" {
-		title = fetchPageTitle(rawURL)
-	}
-
-	// Find or create site
-	site, err := h.repo.GetSiteByDomain(domain)
-	if err != nil {
-		// Create new site - use title as site name if this is root domain
-		siteName := ""
-		if isRootDomain {
-			siteName = title
-		}
-		siteID, err := h.repo.CreateSite(nil, domain, siteName, "")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		site, _ = h.repo.GetSite(siteID)
-	}
-
-	// If root domain, just create/update site, don't create a page
-	if isRootDomain {
-		// Handle tags - apply to site instead of page
-		if tagStr := r.FormValue("tags"); tagStr != "" {
-			tagNames := strings.Split(tagStr, ",")
-			for _, tagName := range tagNames {
-				tagName = strings.TrimSpace(tagName)
-				if tagName == "" {
-					continue
-				}
-				tagID, err := h.repo.GetOrCreateTag(tagName)
-				if err != nil {
-					continue
-				}
-				h.repo.AddSiteTag(site.ID, tagID)
-			}
-		}
-
+	// A root domain only adds the site, with the tags on it
+	if page == nil {
 		if isHTMX(r) {
 			// Return empty - the page will refresh the sites list
 			w.WriteHeader(http.StatusOK)
@@ -170,32 +110,10 @@ func (h *PageHandler) Create(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-
-	// Create page
-	id, err := h.repo.CreatePage(site.ID, path, title, description)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Handle tags
-	if tagStr := r.FormValue("tags"); tagStr != "" {
-		tagNames := strings.Split(tagStr, ",")
-		for _, tagName := range tagNames {
-			tagName = strings.TrimSpace(tagName)
-			if tagName == "" {
-				continue
-			}
-			tagID, err := h.repo.GetOrCreateTag(tagName)
-			if err != nil {
-				continue
-			}
-			h.repo.AddPageTag(id, tagID)
-		}
-	}
+	h.archiveInBackground(page.ID)
+	h.extractInBackground(page.ID)
 
 	if isHTMX(r) {
-		page, _ := h.repo.GetPage(id)
 		h.tmpl.ExecuteTemplate(w, "page-row", page)
 	} else {
 		http.Redirect(w, r, "/pages", http.StatusSeeOther)
@@ -215,7 +133,7 @@ func (h *PageHandler) Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sites, err := h.repo.GetSites(nil)
+	sites, err := h.repo.GetSites(repository.SiteFilter{})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -295,78 +213,64 @@ func (h *PageHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.repo.DeletePage(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Page not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if isHTMX(r) {
-		w.WriteHeader(http.StatusOK)
+		renderUndo(w, h.tmpl, h.undos, "Page moved to the trash.", func() error {
+			return h.repo.RestorePage(id)
+		})
 	} else {
 		http.Redirect(w, r, "/pages", http.StatusSeeOther)
 	}
 }
 
-// QuickAdd handles adding pages from the dashboard
-func (h *PageHandler) QuickAdd(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	rawURL := strings.TrimSpace(r.FormValue("url"))
-	title := r.FormValue("title")
-
-	if rawURL == "" {
-		http.Error(w, "URL is required", http.StatusBadRequest)
+// Visit records that the page was opened, for sorting by last visit, and
+// sends the browser on to it.
+func (h *PageHandler) Visit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
 		return
 	}
 
-	// Parse the URL
-	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
-		rawURL = "https://" + rawURL
-	}
-
-	parsedURL, err := url.Parse(rawURL)
+	page, err := h.repo.GetPage(id)
 	if err != nil {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		http.Error(w, "Page not found", http.StatusNotFound)
 		return
 	}
-
-	domain := parsedURL.Host
-	path := parsedURL.Path
-	if parsedURL.RawQuery != "" {
-		path += "?" + parsedURL.RawQuery
-	}
-	if path == "" {
-		path = "/"
+	if err := h.repo.MarkPageVisited(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// Check if this is a root domain (no specific page)
-	isRootDomain := path == "/" || path == ""
+	http.Redirect(w, r, "https://"+page.SiteDomain+page.Path, http.StatusFound)
+}
 
-	// Fetch title from page if not provided
-	if title == "" {
-		title = fetchPageTitle(rawURL)
+// QuickAdd handles adding pages from the dashboard
+func (h *PageHandler) QuickAdd(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Find or create site
-	site, err := h.repo.GetSiteByDomain(domain)
+	site, page, err := api.Add(h.repo, api.AddRequest{
+		URL:   r.FormValue("url"),
+		Title: r.FormValue("title"),
+		Later: r.FormValue("later") != "",
+	})
 	if err != nil {
-		// Create new site - use title as site name if this is root domain
-		siteName := ""
-		if isRootDomain {
-			siteName = title
-		}
-		siteID, err := h.repo.CreateSite(nil, domain, siteName, "")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		site, _ = h.repo.GetSite(siteID)
+		http.Error(w, err.Error(), addErrorStatus(err))
+		return
 	}
 
 	// If root domain, just create site, don't create a page
-	if isRootDomain {
+	if page == nil {
 		if isHTMX(r) {
 			// Return a row showing the site was added
 			h.tmpl.ExecuteTemplate(w, "recent-site-row", site)
@@ -376,58 +280,95 @@ func (h *PageHandler) QuickAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create page
-	id, err := h.repo.CreatePage(site.ID, path, title, "")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	h.archiveInBackground(page.ID)
+	h.extractInBackground(page.ID)
 
 	if isHTMX(r) {
-		page, _ := h.repo.GetPage(id)
 		h.tmpl.ExecuteTemplate(w, "recent-page-row", page)
 	} else {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
 
-// fetchPageTitle fetches a URL and extracts the <title> tag content
-func fetchPageTitle(rawURL string) string {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+// addErrorStatus is the response status for an error from api.Add.
+func addErrorStatus(err error) int {
+	if errors.Is(err, api.ErrInvalidURL) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// pageFilterParams are the query parameters understood by parsePageFilter.
+var pageFilterParams = []string{"q", "site", "category", "subcategories", "tag", "any", "not", "subtags", "own", "status",
+	"favorites", "pinned", "rating", "sort"}
+
+// parsePageFilter reads a page filter from query parameters. Tags are
+// combined as tag=...&tag=... (all of), any=... (one of) and not=... (none
+// of), so a filtered list can be shared as a link.
+func parsePageFilter(values url.Values) repository.PageFilter {
+	filter := repository.PageFilter{
+		Query:                strings.TrimSpace(values.Get("q")),
+		ReadStatus:           values.Get("status"),
+		IncludeSubcategories: values.Get("subcategories") != "",
+		Favorites:            values.Get("favorites") != "",
+		Pinned:               values.Get("pinned") != "",
+		MinRating:            parseRating(values.Get("rating")),
+		Sort:                 values.Get("sort"),
+		Tags: repository.TagFilter{
+			All:             parseIDs(values["tag"]),
+			Any:             parseIDs(values["any"]),
+			None:            parseIDs(values["not"]),
+			IncludeSubtags:  values.Get("subtags") != "",
+			IncludeSiteTags: values.Get("own") == "",
+		},
+	}
+	if id, err := strconv.ParseInt(values.Get("site"), 10, 64); err == nil {
+		filter.SiteID = &id
+	}
+	if id, err := strconv.ParseInt(values.Get("category"), 10, 64); err == nil {
+		filter.CategoryID = &id
+	}
+	return filter
+}
+
+// filterValues keeps only the non-empty page filter parameters of values.
+func filterValues(values url.Values) url.Values {
+	query := url.Values{}
+	for _, key := range pageFilterParams {
+		for _, value := range values[key] {
+			if value = strings.TrimSpace(value); value != "" {
+				query.Add(key, value)
+			}
+		}
 	}
+	return query
+}
 
-	resp, err := client.Get(rawURL)
-	if err != nil {
-		return ""
+// parseRating reads a minimum star rating, treating anything invalid as no
+// minimum.
+func parseRating(str string) int {
+	rating, err := strconv.Atoi(str)
+	if err != nil || rating < 0 || rating > models.MaxRating {
+		return 0
 	}
-	defer resp.Body.Close()
+	return rating
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return ""
+// parseIDs converts repeated ID query values, skipping any that are invalid.
+func parseIDs(values []string) []int64 {
+	var ids []int64
+	for _, str := range values {
+		if id, err := strconv.ParseInt(str, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
 	}
+	return ids
+}
 
-	// Read first 64KB to find title
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
-	if err != nil {
-		return ""
-	}
-
-	// Extract title using regex
-	titleRegex := regexp.MustCompile(`(?i)<title[^>]*>([^<]+)</title>`)
-	matches := titleRegex.FindSubmatch(body)
-	if len(matches) >= 2 {
-		title := strings.TrimSpace(string(matches[1]))
-		// Decode HTML entities
-		title = strings.ReplaceAll(title, "&amp;", "&")
-		title = strings.ReplaceAll(title, "&lt;", "<")
-		title = strings.ReplaceAll(title, "&gt;", ">")
-		title = strings.ReplaceAll(title, "&quot;", "\"")
-		title = strings.ReplaceAll(title, "&#39;", "'")
-		title = strings.ReplaceAll(title, "&ndash;", "-")
-		title = strings.ReplaceAll(title, "&mdash;", "-")
-		return title
-	}
-
-	return ""
+// pageTitle is the page's title, or its address when it has none
+func pageTitle(p *models.Page) string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return p.SiteDomain + p.Path
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+func newPageMux(t *testing.T, repo repository.Store) *http.ServeMux {
+	h := NewPageHandler(repo, templatesForTest(t), nil, false, newUndos())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pages", h.List)
+	mux.HandleFunc("POST /pages", h.Create)
+	mux.HandleFunc("DELETE /pages/{id}", h.Delete)
+	return mux
+}
+
+// addTestPage bookmarks path on domain, creating the site.
+func addTestPage(t *testing.T, repo repository.Store, domain, path, title string) int64 {
+	t.Helper()
+	siteID, err := repo.CreateSite(nil, domain, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.CreatePage(siteID, path, title, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestPageList(t *testing.T) {
+	repo := repository.NewMemory()
+	addTestPage(t, repo, "go.invalid", "/doc", "Documentation")
+	mux := newPageMux(t, repo)
+
+	wantResponse(t, serve(t, mux, "GET", "/pages", nil), http.StatusOK, "Documentation")
+}
+
+func TestPageCreate(t *testing.T) {
+	repo := repository.NewMemory()
+	mux := newPageMux(t, repo)
+
+	// The .invalid domain makes the text extraction that follows fail fast
+	form := url.Values{"url": {"https://go.invalid/doc"}, "title": {"Documentation"}, "tags": {"go"}}
+	wantResponse(t, serve(t, mux, "POST", "/pages", form), http.StatusOK, "Documentation")
+	pages, err := repo.GetPages(repository.PageFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Path != "/doc" || len(pages[0].Tags) != 1 {
+		t.Errorf("pages = %+v, want /doc tagged go", pages)
+	}
+
+	wantResponse(t, serve(t, mux, "POST", "/pages", url.Values{"url": {""}}), http.StatusBadRequest)
+}
+
+func TestPageDelete(t *testing.T) {
+	repo := repository.NewMemory()
+	id := addTestPage(t, repo, "go.invalid", "/doc", "Documentation")
+	mux := newPageMux(t, repo)
+
+	w := serve(t, mux, "DELETE", "/pages/"+strconv.FormatInt(id, 10), nil)
+	wantResponse(t, w, http.StatusOK, "Page moved to the trash.")
+	if trashed, err := repo.GetTrashedPages(); err != nil || len(trashed) != 1 || trashed[0].ID != id {
+		t.Errorf("trashed pages = %v, %v", trashed, err)
+	}
+
+	// Neither a page in the trash nor a missing one offers an undo
+	w = serve(t, mux, "DELETE", "/pages/"+strconv.FormatInt(id, 10), nil)
+	wantResponse(t, w, http.StatusNotFound)
+	wantResponse(t, serve(t, mux, "DELETE", "/pages/999", nil), http.StatusNotFound)
+}
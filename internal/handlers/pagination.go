@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// listPageSize is how many rows the site, page and tag lists show at once.
+const listPageSize = 50
+
+// pagination describes the part of a list being shown, for the "pager"
+// template.
+type pagination struct {
+	Page  int // 1-based
+	Pages int
+	Total int
+	Noun  string // what the list holds, such as "pages"
+	// Path and Query give the list's URL with its filters; Target is the
+	// element a pager link swaps the next part of the list into.
+	Path   string
+	Query  url.Values
+	Target string
+}
+
+// newPagination reads the requested page number from query and clamps it
+// to the pages a list of total rows has.
+func newPagination(path string, query url.Values, total int, noun, target string) pagination {
+	p := pagination{
+		Pages:  (total + listPageSize - 1) / listPageSize,
+		Total:  total,
+		Noun:   noun,
+		Path:   path,
+		Target: target,
+	}
+	p.Page, _ = strconv.Atoi(query.Get("page"))
+	p.Page = max(1, min(p.Page, p.Pages))
+
+	p.Query = url.Values{}
+	for key, values := range query {
+		if key != "page" {
+			p.Query[key] = values
+		}
+	}
+	return p
+}
+
+// Offset is how many rows come before the current page.
+func (p pagination) Offset() int {
+	return (p.Page - 1) * listPageSize
+}
+
+func (p pagination) Limit() int {
+	return listPageSize
+}
+
+func (p pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+func (p pagination) HasNext() bool {
+	return p.Page < p.Pages
+}
+
+// URL links to page n of the list with the same filters.
+func (p pagination) URL(n int) string {
+	query := url.Values{}
+	for key, values := range p.Query {
+		query[key] = values
+	}
+	if n > 1 {
+		query.Set("page", strconv.Itoa(n))
+	}
+	if len(query) == 0 {
+		return p.Path
+	}
+	return p.Path + "?" + query.Encode()
+}
+
+func (p pagination) PrevURL() string {
+	return p.URL(p.Page - 1)
+}
+
+func (p pagination) NextURL() string {
+	return p.URL(p.Page + 1)
+}
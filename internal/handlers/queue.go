@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+type QueueHandler struct {
+	repo repository.Store
+	tmpl *template.Template
+}
+
+func NewQueueHandler(repo repository.Store, tmpl *template.Template) *QueueHandler {
+	return &QueueHandler{repo: repo, tmpl: tmpl}
+}
+
+// Queue lists pages saved for later, oldest first
+func (h *QueueHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	pages, err := h.repo.GetReadingQueue(0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	counts := map[string]int{}
+	for _, status := range models.ReadStatuses {
+		count, err := h.repo.CountPages(repository.PageFilter{ReadStatus: status})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		counts[status] = count
+	}
+
+	data := map[string]interface{}{
+		"Pages":    pages,
+		"Counts":   counts,
+		"Statuses": models.ReadStatuses,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "queue.html", data)
+}
+
+// SetStatus moves a page to the submitted read-later status. Rows in the
+// queue view (view=queue) disappear once the page leaves the queue.
+func (h *QueueHandler) SetStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.SetPageReadStatus(id, r.FormValue("status")); err != nil {
+		if errors.Is(err, repository.ErrInvalidReadStatus) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !isHTMX(r) {
+		http.Redirect(w, r, "/queue", http.StatusSeeOther)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.FormValue("view") {
+	case "queue":
+		if page.ReadStatus == models.StatusUnread || page.ReadStatus == models.StatusReading {
+			h.tmpl.ExecuteTemplate(w, "queue-row", page)
+		}
+	case "dashboard":
+		if page.ReadStatus == models.StatusUnread || page.ReadStatus == models.StatusReading {
+			h.tmpl.ExecuteTemplate(w, "queue-item", page)
+		}
+	default:
+		h.tmpl.ExecuteTemplate(w, "page-row", page)
+	}
+}
+
+// History shows when a page moved between read-later states
+func (h *QueueHandler) History(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.repo.GetPageReadEvents(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.tmpl.ExecuteTemplate(w, "read-history", map[string]interface{}{"Events": events})
+}
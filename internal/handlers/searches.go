@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// feedSize caps the number of entries in a saved search's Atom feed
+const feedSize = 50
+
+type SavedSearchHandler struct {
+	repo repository.Store
+	tmpl *template.Template
+}
+
+func NewSavedSearchHandler(repo repository.Store, tmpl *template.Template) *SavedSearchHandler {
+	return &SavedSearchHandler{repo: repo, tmpl: tmpl}
+}
+
+func (h *SavedSearchHandler) List(w http.ResponseWriter, r *http.Request) {
+	searches, err := savedSearchesWithCounts(h.repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Searches": searches,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "searches.html", data)
+}
+
+// Nav renders the saved search menu shown in the navbar
+func (h *SavedSearchHandler) Nav(w http.ResponseWriter, r *http.Request) {
+	searches, err := h.repo.GetSavedSearches()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.tmpl.ExecuteTemplate(w, "saved-search-nav", map[string]interface{}{"Searches": searches})
+}
+
+// Create saves the pages list filter submitted alongside a name
+func (h *SavedSearchHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	query, err := savedQuery(h.repo, r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := h.repo.CreateSavedSearch(name, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		search, _ := h.repo.GetSavedSearch(id)
+		w.Header().Set("HX-Trigger", "savedSearchesChanged")
+		h.tmpl.ExecuteTemplate(w, "saved-search-created", search)
+	} else {
+		http.Redirect(w, r, "/searches", http.StatusSeeOther)
+	}
+}
+
+// Open shows the pages currently matching a saved search
+func (h *SavedSearchHandler) Open(w http.ResponseWriter, r *http.Request) {
+	search, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	values, err := resolveSavedQuery(h.repo, search.Query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/pages?"+values.Encode(), http.StatusSeeOther)
+}
+
+func (h *SavedSearchHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.DeleteSavedSearch(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		w.Header().Set("HX-Trigger", "savedSearchesChanged")
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Redirect(w, r, "/searches", http.StatusSeeOther)
+	}
+}
+
+// Feed serves the newest pages matching a saved search as an Atom feed
+func (h *SavedSearchHandler) Feed(w http.ResponseWriter, r *http.Request) {
+	search, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	values, err := resolveSavedQuery(h.repo, search.Query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filter := parsePageFilter(values)
+	// Whatever order the search was saved with, a feed wants the newest
+	filter.Sort = repository.SortNewest
+	filter.Limit = feedSize
+	pages, err := h.repo.GetPages(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Pages come newest first
+	updated := search.CreatedAt
+	if len(pages) > 0 && pages[0].CreatedAt.After(updated) {
+		updated = pages[0].CreatedAt
+	}
+
+	base := baseURL(r)
+	feed := atomFeed{
+		Title:   search.Name,
+		ID:      base + "/searches/" + strconv.FormatInt(search.ID, 10),
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: "Bookmarks"},
+		Links: []atomLink{
+			{Href: base + r.URL.Path, Rel: "self"},
+			{Href: base + "/pages?" + values.Encode(), Rel: "alternate"},
+		},
+	}
+	for _, p := range pages {
+		title := p.Title
+		if title == "" {
+			title = p.SiteDomain + p.Path
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   title,
+			ID:      p.URL(),
+			Updated: p.CreatedAt.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: p.URL()},
+			Summary: p.Description,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
+
+func (h *SavedSearchHandler) lookup(w http.ResponseWriter, r *http.Request) (*models.SavedSearch, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	search, err := h.repo.GetSavedSearch(id)
+	if err != nil {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return nil, false
+	}
+	return search, true
+}
+
+// savedSearchesWithCounts loads every saved search along with the number of
+// pages it currently matches.
+func savedSearchesWithCounts(repo repository.Store) ([]models.SavedSearch, error) {
+	searches, err := repo.GetSavedSearches()
+	if err != nil {
+		return nil, err
+	}
+	for i := range searches {
+		values, err := resolveSavedQuery(repo, searches[i].Query)
+		if err != nil {
+			return nil, err
+		}
+		count, err := repo.CountPages(parsePageFilter(values))
+		if err != nil {
+			return nil, err
+		}
+		searches[i].PageCount = count
+	}
+	return searches, nil
+}
+
+// tagParams are the page filter parameters holding tag IDs. Saved searches
+// keep tag names in them instead: a tag merged into another, or deleted and
+// restored, comes back under a different ID, which SQLite may also hand to
+// an unrelated tag, but its name still finds it.
+var tagParams = []string{"tag", "any", "not"}
+
+// noTag stands in for a tag that no longer exists. IDs start at 1, so no
+// page carries it: tag= matches nothing, as the tag would have, and not=
+// excludes nothing.
+const noTag = "0"
+
+// savedQuery encodes the page filter in values for saving, with tag names
+// in place of tag IDs. Tags that do not exist are left out.
+func savedQuery(repo repository.Store, values url.Values) (string, error) {
+	query := filterValues(values)
+	for _, key := range tagParams {
+		var names []string
+		for _, id := range parseIDs(query[key]) {
+			tag, err := repo.GetTag(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			names = append(names, tag.Name)
+		}
+		query[key] = names
+	}
+	return filterValues(query).Encode(), nil
+}
+
+// resolveSavedQuery decodes a saved search's query, turning tag names back
+// into the IDs the tags have now. Searches saved before names were stored
+// hold IDs, which are kept as they are.
+func resolveSavedQuery(repo repository.Store, query string) (url.Values, error) {
+	values, _ := url.ParseQuery(query)
+	for _, key := range tagParams {
+		for i, name := range values[key] {
+			id, err := repo.LookupTag(name)
+			switch {
+			case err == nil:
+				values[key][i] = strconv.FormatInt(id, 10)
+			case !errors.Is(err, sql.ErrNoRows):
+				return nil, err
+			default:
+				if _, err := strconv.ParseInt(name, 10, 64); err != nil {
+					values[key][i] = noTag
+				}
+			}
+		}
+	}
+	return values, nil
+}
+
+// baseURL is the scheme and host the request was made to, for building
+// absolute links.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary,omitempty"`
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+func newSavedSearchMux(t *testing.T, repo repository.Store) *http.ServeMux {
+	h := NewSavedSearchHandler(repo, templatesForTest(t))
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /searches", h.Create)
+	mux.HandleFunc("GET /searches/{id}", h.Open)
+	mux.HandleFunc("GET /searches/{id}/feed", h.Feed)
+	return mux
+}
+
+// TestSavedSearchTagMerged saves searches on tags that then move to other
+// IDs, and expects them to follow the tags.
+func TestSavedSearchTagMerged(t *testing.T) {
+	repo := repository.NewMemory()
+	doc := addTestPage(t, repo, "go.invalid", "/doc", "Documentation")
+	blog := addTestPage(t, repo, "blog.invalid", "/", "Blog")
+	golang, err := repo.CreateTag("golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang, err := repo.CreateTag("lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	draft, err := repo.CreateTag("draft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{repo.AddPageTag(doc, golang), repo.AddPageTag(blog, golang), repo.AddPageTag(blog, draft)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := newSavedSearchMux(t, repo)
+
+	form := url.Values{"name": {"Go"}, "tag": {strconv.FormatInt(golang, 10)}, "not": {strconv.FormatInt(draft, 10)}}
+	wantResponse(t, serve(t, mux, "POST", "/searches", form), http.StatusOK)
+	searches, err := repo.GetSavedSearches()
+	if err != nil || len(searches) != 1 {
+		t.Fatalf("saved searches = %v, %v", searches, err)
+	}
+	search := searches[0]
+	if search.Query != "not=draft&tag=golang" {
+		t.Errorf("saved query = %q, want tag names", search.Query)
+	}
+
+	// golang goes away into lang, and draft comes back from the trash
+	// under a new ID
+	if err := repo.MergeTags(golang, lang); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := repo.DeleteTag(draft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateTag("art"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RestoreTag(deleted); err != nil {
+		t.Fatal(err)
+	}
+	newDraft, err := repo.LookupTag("draft")
+	if err != nil || newDraft == draft {
+		t.Fatalf("restored draft tag = %d, %v; want a new ID", newDraft, err)
+	}
+
+	target := "/searches/" + strconv.FormatInt(search.ID, 10)
+	w := serve(t, mux, "GET", target, nil)
+	want := "/pages?" + url.Values{"tag": {strconv.FormatInt(lang, 10)}, "not": {strconv.FormatInt(newDraft, 10)}}.Encode()
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != want {
+		t.Errorf("opening the search: %d to %q, want %q", w.Code, w.Header().Get("Location"), want)
+	}
+
+	w = serve(t, mux, "GET", target+"/feed", nil)
+	wantResponse(t, w, http.StatusOK, "Documentation")
+	if body := w.Body.String(); strings.Contains(body, "<title>Blog</title>") {
+		t.Errorf("feed lists the page tagged draft:\n%s", body)
+	}
+}
@@ -1,33 +1,53 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"html/template"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/lehmann314159/bookmarks/internal/repository"
+	"github.com/lehmann314159/bookmarks/internal/undo"
 )
 
 type SiteHandler struct {
-	repo *repository.Repository
-	tmpl *template.Template
+	repo  repository.Store
+	tmpl  *template.Template
+	undos *undo.Store
 }
 
-func NewSiteHandler(repo *repository.Repository, tmpl *template.Template) *SiteHandler {
-	return &SiteHandler{repo: repo, tmpl: tmpl}
+func NewSiteHandler(repo repository.Store, tmpl *template.Template, undos *undo.Store) *SiteHandler {
+	return &SiteHandler{repo: repo, tmpl: tmpl, undos: undos}
 }
 
 func (h *SiteHandler) List(w http.ResponseWriter, r *http.Request) {
-	var categoryID *int64
-	if catStr := r.URL.Query().Get("category"); catStr != "" {
+	query := r.URL.Query()
+	filter := repository.SiteFilter{
+		IncludeSubcategories: query.Get("subcategories") != "",
+		Favorites:            query.Get("favorites") != "",
+		Pinned:               query.Get("pinned") != "",
+		MinRating:            parseRating(query.Get("rating")),
+		Sort:                 query.Get("sort"),
+	}
+	if catStr := query.Get("category"); catStr != "" {
 		id, err := strconv.ParseInt(catStr, 10, 64)
 		if err == nil {
-			categoryID = &id
+			filter.CategoryID = &id
 		}
 	}
 
-	sites, err := h.repo.GetSites(categoryID)
+	total, err := h.repo.CountSites(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pager := newPagination("/sites", query, total, "sites", "#sites-container")
+	filter.Limit = pager.Limit()
+	filter.Offset = pager.Offset()
+
+	sites, err := h.repo.GetSites(filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -46,10 +66,14 @@ func (h *SiteHandler) List(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
-		"Sites":      sites,
-		"Categories": categories,
-		"Tags":       tags,
-		"CategoryID": categoryID,
+		"Sites":                sites,
+		"Categories":           categories,
+		"Tags":                 tags,
+		"CategoryID":           filter.CategoryID,
+		"IncludeSubcategories": filter.IncludeSubcategories,
+		"Filter":               filter,
+		"SortOrders":           repository.SiteSortOrders,
+		"Pager":                pager,
 	}
 
 	if isHTMX(r) {
@@ -106,7 +130,7 @@ func (h *SiteHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	if isHTMX(r) {
 		site, _ := h.repo.GetSite(id)
-		h.tmpl.ExecuteTemplate(w, "site-row", site)
+		h.tmpl.ExecuteTemplate(w, "site-card", site)
 	} else {
 		http.Redirect(w, r, "/sites", http.StatusSeeOther)
 	}
@@ -194,7 +218,7 @@ func (h *SiteHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	if isHTMX(r) {
 		site, _ := h.repo.GetSite(id)
-		h.tmpl.ExecuteTemplate(w, "site-row", site)
+		h.tmpl.ExecuteTemplate(w, "site-card", site)
 	} else {
 		http.Redirect(w, r, "/sites", http.StatusSeeOther)
 	}
@@ -208,12 +232,18 @@ func (h *SiteHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.repo.DeleteSite(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Site not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if isHTMX(r) {
-		w.WriteHeader(http.StatusOK)
+		renderUndo(w, h.tmpl, h.undos, "Site moved to the trash.", func() error {
+			return h.repo.RestoreSite(id)
+		})
 	} else {
 		http.Redirect(w, r, "/sites", http.StatusSeeOther)
 	}
@@ -226,7 +256,7 @@ func (h *SiteHandler) Pages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pages, err := h.repo.GetPages(&id, nil, nil)
+	pages, err := h.repo.GetPages(repository.PageFilter{SiteID: &id})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+func newSiteMux(t *testing.T, repo repository.Store) *http.ServeMux {
+	h := NewSiteHandler(repo, templatesForTest(t), newUndos())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sites", h.List)
+	mux.HandleFunc("POST /sites", h.Create)
+	mux.HandleFunc("DELETE /sites/{id}", h.Delete)
+	return mux
+}
+
+func TestSiteList(t *testing.T) {
+	repo := repository.NewMemory()
+	if _, err := repo.CreateSite(nil, "go.dev", "Go", ""); err != nil {
+		t.Fatal(err)
+	}
+	mux := newSiteMux(t, repo)
+
+	wantResponse(t, serve(t, mux, "GET", "/sites", nil), http.StatusOK, "go.dev")
+}
+
+func TestSiteCreate(t *testing.T) {
+	repo := repository.NewMemory()
+	mux := newSiteMux(t, repo)
+
+	w := serve(t, mux, "POST", "/sites", url.Values{"domain": {" go.dev "}, "name": {"Go"}, "tags": {"lang, docs"}})
+	wantResponse(t, w, http.StatusOK, "go.dev")
+	sites, err := repo.GetSites(repository.SiteFilter{})
+	if err != nil || len(sites) != 1 {
+		t.Fatalf("sites = %v, %v", sites, err)
+	}
+	if site := sites[0]; site.Name != "Go" || len(site.Tags) != 2 {
+		t.Errorf("created site %+v, want named Go with two tags", site)
+	}
+
+	w = serve(t, mux, "POST", "/sites", url.Values{"domain": {" "}})
+	wantResponse(t, w, http.StatusBadRequest)
+}
+
+func TestSiteDelete(t *testing.T) {
+	repo := repository.NewMemory()
+	id, err := repo.CreateSite(nil, "go.dev", "Go", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := newSiteMux(t, repo)
+
+	w := serve(t, mux, "DELETE", "/sites/"+strconv.FormatInt(id, 10), nil)
+	wantResponse(t, w, http.StatusOK, "Site moved to the trash.")
+	if _, err := repo.GetSiteByDomain("go.dev"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted site: err = %v, want sql.ErrNoRows", err)
+	}
+	if trashed, err := repo.GetTrashedSites(); err != nil || len(trashed) != 1 {
+		t.Errorf("trashed sites = %v, %v", trashed, err)
+	}
+
+	wantResponse(t, serve(t, mux, "DELETE", "/sites/"+strconv.FormatInt(id, 10), nil), http.StatusNotFound)
+	wantResponse(t, serve(t, mux, "DELETE", "/sites/x", nil), http.StatusBadRequest)
+}
@@ -1,34 +1,35 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/lehmann314159/bookmarks/internal/repository"
+	"github.com/lehmann314159/bookmarks/internal/undo"
 )
 
 type TagHandler struct {
-	repo *repository.Repository
-	tmpl *template.Template
+	repo  repository.Store
+	tmpl  *template.Template
+	undos *undo.Store
 }
 
-func NewTagHandler(repo *repository.Repository, tmpl *template.Template) *TagHandler {
-	return &TagHandler{repo: repo, tmpl: tmpl}
+func NewTagHandler(repo repository.Store, tmpl *template.Template, undos *undo.Store) *TagHandler {
+	return &TagHandler{repo: repo, tmpl: tmpl, undos: undos}
 }
 
 func (h *TagHandler) List(w http.ResponseWriter, r *http.Request) {
-	tags, err := h.repo.GetTags()
+	data, err := h.listData(r.URL.Query())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	data := map[string]interface{}{
-		"Tags": tags,
-	}
-
 	if isHTMX(r) {
 		h.tmpl.ExecuteTemplate(w, "tag-list", data)
 	} else {
@@ -49,20 +50,217 @@ func (h *TagHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := h.repo.CreateTag(name)
+	if _, err := h.repo.CreateTag(name); err != nil {
+		http.Error(w, err.Error(), tagErrorStatus(err))
+		return
+	}
+
+	if isHTMX(r) {
+		// The new tag, and any parents created for it, belong mid-tree
+		h.renderTree(w, r)
+	} else {
+		http.Redirect(w, r, "/tags", http.StatusSeeOther)
+	}
+}
+
+func (h *TagHandler) Edit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	h.renderEditForm(w, id)
+}
+
+// Rename changes a tag's name, keeping the old one as an alias
+func (h *TagHandler) Rename(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.RenameTag(id, name); err != nil {
+		http.Error(w, err.Error(), tagErrorStatus(err))
+		return
+	}
+
+	if isHTMX(r) {
+		// Renaming can move the tag, and its children, within the tree
+		h.renderTree(w, r)
+	} else {
+		http.Redirect(w, r, "/tags", http.StatusSeeOther)
+	}
+}
+
+// Merge folds a tag into another one, retagging all of its sites and pages
+func (h *TagHandler) Merge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	targetID, err := strconv.ParseInt(r.FormValue("into"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid target tag", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.MergeTags(id, targetID); err != nil {
+		http.Error(w, err.Error(), tagErrorStatus(err))
+		return
+	}
+
+	if isHTMX(r) {
+		// Both the merged and the surviving tag changed
+		h.renderTree(w, r)
+	} else {
+		http.Redirect(w, r, "/tags", http.StatusSeeOther)
+	}
+}
+
+func (h *TagHandler) AddAlias(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	alias := strings.TrimSpace(r.FormValue("alias"))
+	if alias == "" {
+		http.Error(w, "Alias is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.AddTagAlias(id, alias); err != nil {
+		http.Error(w, err.Error(), tagErrorStatus(err))
+		return
+	}
+
+	if isHTMX(r) {
+		h.renderEditForm(w, id)
+	} else {
+		http.Redirect(w, r, "/tags", http.StatusSeeOther)
+	}
+}
+
+func (h *TagHandler) DeleteAlias(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.DeleteTagAlias(id, r.PathValue("alias")); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if isHTMX(r) {
-		tag, _ := h.repo.GetTag(id)
-		h.tmpl.ExecuteTemplate(w, "tag-pill", tag)
+		h.renderEditForm(w, id)
 	} else {
 		http.Redirect(w, r, "/tags", http.StatusSeeOther)
 	}
 }
 
+func (h *TagHandler) renderEditForm(w http.ResponseWriter, id int64) {
+	tag, err := h.repo.GetTag(id)
+	if err != nil {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+
+	tags, err := h.repo.GetTags()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Tag":  tag,
+		"Tags": tags,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "tag-edit-form", data)
+}
+
+// listData loads the part of the tag list that query asks for.
+func (h *TagHandler) listData(query url.Values) (map[string]interface{}, error) {
+	total, err := h.repo.CountTags()
+	if err != nil {
+		return nil, err
+	}
+	pager := newPagination("/tags", query, total, "tags", "#tag-cloud")
+	sort := query.Get("sort")
+	tags, err := h.repo.ListTags(repository.TagListFilter{
+		Sort:   sort,
+		Limit:  pager.Limit(),
+		Offset: pager.Offset(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"Tags":       tags,
+		"Sort":       sort,
+		"SortOrders": repository.TagSortOrders,
+		"Pager":      pager,
+	}, nil
+}
+
+// renderTree re-renders the part of the tag list the browser is showing.
+func (h *TagHandler) renderTree(w http.ResponseWriter, r *http.Request) {
+	var query url.Values
+	if current, err := url.Parse(r.Header.Get("HX-Current-URL")); err == nil {
+		query = current.Query()
+	}
+	data, err := h.listData(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Retarget", "#tag-cloud")
+	w.Header().Set("HX-Reswap", "innerHTML")
+	h.tmpl.ExecuteTemplate(w, "tag-list", data)
+}
+
+func tagErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrTagExists):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrTagCycle), errors.Is(err, repository.ErrTagNameEmpty):
+		return http.StatusBadRequest
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (h *TagHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
@@ -70,13 +268,16 @@ func (h *TagHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.repo.DeleteTag(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	deleted, err := h.repo.DeleteTag(id)
+	if err != nil {
+		http.Error(w, err.Error(), tagErrorStatus(err))
 		return
 	}
 
 	if isHTMX(r) {
-		w.WriteHeader(http.StatusOK)
+		renderUndo(w, h.tmpl, h.undos, "Tag \""+deleted.Name+"\" deleted.", func() error {
+			return h.repo.RestoreTag(deleted)
+		})
 	} else {
 		http.Redirect(w, r, "/tags", http.StatusSeeOther)
 	}
@@ -95,33 +296,38 @@ func (h *TagHandler) Items(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sites, err := h.repo.GetSites(nil)
+	includeSubtags := r.URL.Query().Get("subtags") != ""
+
+	sites, err := h.repo.GetSites(repository.SiteFilter{TagID: &id, IncludeSubtags: includeSubtags})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Filter sites with this tag
-	var taggedSites []interface{}
-	for _, site := range sites {
-		for _, t := range site.Tags {
-			if t.ID == id {
-				taggedSites = append(taggedSites, site)
-				break
-			}
-		}
+	pages, err := h.repo.GetPages(repository.PageFilter{
+		Tags: repository.TagFilter{
+			All:             []int64{id},
+			IncludeSubtags:  includeSubtags,
+			IncludeSiteTags: true,
+		},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	pages, err := h.repo.GetPages(nil, nil, &id)
+	hasSubtags, err := h.repo.HasSubtags(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	data := map[string]interface{}{
-		"Tag":   tag,
-		"Sites": taggedSites,
-		"Pages": pages,
+		"Tag":            tag,
+		"Sites":          sites,
+		"Pages":          pages,
+		"HasSubtags":     hasSubtags,
+		"IncludeSubtags": includeSubtags,
 	}
 
 	h.tmpl.ExecuteTemplate(w, "tag-items", data)
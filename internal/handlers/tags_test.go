@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+func newTagMux(t *testing.T, repo repository.Store) *http.ServeMux {
+	h := NewTagHandler(repo, templatesForTest(t), newUndos())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tags", h.List)
+	mux.HandleFunc("POST /tags", h.Create)
+	mux.HandleFunc("DELETE /tags/{id}", h.Delete)
+	return mux
+}
+
+func TestTagList(t *testing.T) {
+	repo := repository.NewMemory()
+	if _, err := repo.CreateTag("golang"); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTagMux(t, repo)
+
+	wantResponse(t, serve(t, mux, "GET", "/tags", nil), http.StatusOK, "golang")
+}
+
+func TestTagCreate(t *testing.T) {
+	repo := repository.NewMemory()
+	mux := newTagMux(t, repo)
+
+	// Creating a nested tag creates its parent, and the tree comes back whole
+	w := serve(t, mux, "POST", "/tags", url.Values{"name": {"lang/golang"}})
+	wantResponse(t, w, http.StatusOK, "lang", "golang")
+	if got := w.Header().Get("HX-Retarget"); got != "#tag-cloud" {
+		t.Errorf("HX-Retarget = %q, want #tag-cloud", got)
+	}
+	if tags, err := repo.GetTags(); err != nil || len(tags) != 2 {
+		t.Errorf("tags = %v, %v; want lang and lang/golang", tags, err)
+	}
+
+	wantResponse(t, serve(t, mux, "POST", "/tags", url.Values{"name": {"lang/golang"}}), http.StatusConflict)
+	wantResponse(t, serve(t, mux, "POST", "/tags", url.Values{"name": {""}}), http.StatusBadRequest)
+	wantResponse(t, serve(t, mux, "POST", "/tags", url.Values{"name": {" / "}}), http.StatusBadRequest)
+}
+
+func TestTagDelete(t *testing.T) {
+	repo := repository.NewMemory()
+	id, err := repo.CreateTag("golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := newTagMux(t, repo)
+
+	w := serve(t, mux, "DELETE", "/tags/"+strconv.FormatInt(id, 10), nil)
+	wantResponse(t, w, http.StatusOK, `Tag &#34;golang&#34; deleted.`)
+	if tags, err := repo.GetTags(); err != nil || len(tags) != 0 {
+		t.Errorf("tags after deleting = %v, %v", tags, err)
+	}
+
+	wantResponse(t, serve(t, mux, "DELETE", "/tags/"+strconv.FormatInt(id, 10), nil), http.StatusNotFound)
+}
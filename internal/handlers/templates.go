@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/lehmann314159/bookmarks/internal/markdown"
+	"github.com/lehmann314159/bookmarks/internal/models"
+)
+
+// ParseTemplates parses the page templates matching pattern, such as
+// "templates/*.html", along with the functions they use.
+func ParseTemplates(pattern string) (*template.Template, error) {
+	funcMap := template.FuncMap{
+		"repeat":     strings.Repeat,
+		"pathEscape": url.PathEscape,
+		"markdown":   markdown.Render,
+		"hasID": func(ids []int64, id int64) bool {
+			return slices.Contains(ids, id)
+		},
+		"ratingScale": func() []int {
+			// The star values 1 to MaxRating, for rating controls
+			scale := make([]int, models.MaxRating)
+			for i := range scale {
+				scale[i] = i + 1
+			}
+			return scale
+		},
+		"ptrEq": func(id int64, p *int64) bool {
+			// For matching optional IDs, which eq cannot compare
+			return p != nil && *p == id
+		},
+		"humanBytes": func(n int64) string {
+			const unit = 1024
+			if n < unit {
+				return fmt.Sprintf("%d B", n)
+			}
+			div, exp := int64(unit), 0
+			for m := n / unit; m >= unit; m /= unit {
+				div *= unit
+				exp++
+			}
+			return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+		},
+		"join": func(tags interface{}, sep string) string {
+			switch t := tags.(type) {
+			case []string:
+				return strings.Join(t, sep)
+			default:
+				return ""
+			}
+		},
+		"tagNames": func(tags interface{}) string {
+			// Helper to extract tag names as comma-separated string
+			switch t := tags.(type) {
+			case []interface{}:
+				var names []string
+				for _, tag := range t {
+					if m, ok := tag.(map[string]interface{}); ok {
+						if name, ok := m["Name"].(string); ok {
+							names = append(names, name)
+						}
+					}
+				}
+				return strings.Join(names, ", ")
+			default:
+				return ""
+			}
+		},
+	}
+
+	return template.New("").Funcs(funcMap).ParseGlob(pattern)
+}
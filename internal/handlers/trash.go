@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/lehmann314159/bookmarks/internal/repository"
+	"github.com/lehmann314159/bookmarks/internal/trash"
+)
+
+// TrashHandler lists, restores and purges deleted sites and pages
+type TrashHandler struct {
+	repo   repository.Store
+	purger *trash.Purger
+	tmpl   *template.Template
+}
+
+func NewTrashHandler(repo repository.Store, purger *trash.Purger, tmpl *template.Template) *TrashHandler {
+	return &TrashHandler{repo: repo, purger: purger, tmpl: tmpl}
+}
+
+// List shows deleted sites and pages, each with the date it will be purged
+func (h *TrashHandler) List(w http.ResponseWriter, r *http.Request) {
+	sites, err := h.repo.GetTrashedSites()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pages, err := h.repo.GetTrashedPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Sites":     sites,
+		"Pages":     pages,
+		"Retention": h.purger.Retention(),
+		"Days":      int(h.purger.Retention().Hours() / 24),
+	}
+
+	h.tmpl.ExecuteTemplate(w, "trash.html", data)
+}
+
+// RestoreSite brings back a site along with the pages deleted with it
+func (h *TrashHandler) RestoreSite(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.repo.RestoreSite)
+}
+
+// RestorePage brings back a page, and its site if that was deleted too
+func (h *TrashHandler) RestorePage(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.repo.RestorePage)
+}
+
+// PurgeSite permanently deletes a site and its pages
+func (h *TrashHandler) PurgeSite(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.purger.PurgeSite)
+}
+
+// PurgePage permanently deletes a page
+func (h *TrashHandler) PurgePage(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.purger.PurgePage)
+}
+
+// Empty permanently deletes everything in the trash
+func (h *TrashHandler) Empty(w http.ResponseWriter, r *http.Request) {
+	if err := h.purger.Empty(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// apply runs action on the ID in the path. HTMX requests get an empty
+// response that removes the entry from the trash list.
+func (h *TrashHandler) apply(w http.ResponseWriter, r *http.Request, action func(id int64) error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := action(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Not in the trash", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Redirect(w, r, "/trash", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/lehmann314159/bookmarks/internal/repository"
+	"github.com/lehmann314159/bookmarks/internal/undo"
+)
+
+type UndoHandler struct {
+	undos *undo.Store
+}
+
+func NewUndoHandler(undos *undo.Store) *UndoHandler {
+	return &UndoHandler{undos: undos}
+}
+
+// Undo reverts the action behind the token in the path. HTMX requests get
+// the page refreshed so that the restored item shows up where it was.
+func (h *UndoHandler) Undo(w http.ResponseWriter, r *http.Request) {
+	if err := h.undos.Undo(r.PathValue("token")); err != nil {
+		switch {
+		case errors.Is(err, undo.ErrExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, repository.ErrTagExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if isHTMX(r) {
+		w.Header().Set("HX-Refresh", "true")
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// renderUndo keeps revert available for the undo window and responds with
+// a toast offering it. The toast is swapped in out of band, so the element
+// targeted by the delete is still removed.
+func renderUndo(w http.ResponseWriter, tmpl *template.Template, undos *undo.Store, message string, revert func() error) {
+	token, err := undos.Add(revert)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Message": message,
+		"Token":   token,
+		"Window":  undos.Window().Milliseconds(),
+	}
+	tmpl.ExecuteTemplate(w, "undo-toast", data)
+}
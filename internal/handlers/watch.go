@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/lehmann314159/bookmarks/internal/diff"
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+	"github.com/lehmann314159/bookmarks/internal/watcher"
+)
+
+type WatchHandler struct {
+	repo    repository.Store
+	watcher *watcher.Watcher
+	tmpl    *template.Template
+}
+
+func NewWatchHandler(repo repository.Store, watcher *watcher.Watcher, tmpl *template.Template) *WatchHandler {
+	return &WatchHandler{repo: repo, watcher: watcher, tmpl: tmpl}
+}
+
+// versionView is a page version with its diff split into displayable hunks
+type versionView struct {
+	models.PageVersion
+	Hunks [][]diff.Line
+}
+
+// Watch starts change detection for a page and captures its baseline
+func (h *WatchHandler) Watch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.repo.SetPageWatched(id, true); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		if err := h.watcher.Check(page); err != nil {
+			log.Printf("Change check for page %d failed: %v", page.ID, err)
+		}
+	}()
+
+	h.respond(w, r, id)
+}
+
+func (h *WatchHandler) Unwatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.SetPageWatched(id, false); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.respond(w, r, id)
+}
+
+// Changes lists the recorded versions of a page with their diffs
+func (h *WatchHandler) Changes(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	versions, err := h.repo.GetPageVersions(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var views []versionView
+	for _, v := range versions {
+		views = append(views, versionView{
+			PageVersion: v,
+			Hunks:       diff.Hunks(diff.Parse(v.Diff), 2),
+		})
+	}
+
+	data := map[string]interface{}{
+		"Page":     page,
+		"Versions": views,
+	}
+
+	h.tmpl.ExecuteTemplate(w, "changes.html", data)
+}
+
+// Check refetches a watched page right away
+func (h *WatchHandler) Check(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.GetPage(id)
+	if err != nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.watcher.Check(page); err != nil {
+		http.Error(w, "Check failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, "/pages/"+strconv.FormatInt(id, 10)+"/changes", http.StatusSeeOther)
+}
+
+// Acknowledge clears the changed badge once the diff has been looked at
+func (h *WatchHandler) Acknowledge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.ClearPageChanged(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isHTMX(r) {
+		page, _ := h.repo.GetPage(id)
+		h.tmpl.ExecuteTemplate(w, "page-row", page)
+	} else {
+		http.Redirect(w, r, "/pages/"+strconv.FormatInt(id, 10)+"/changes", http.StatusSeeOther)
+	}
+}
+
+func (h *WatchHandler) respond(w http.ResponseWriter, r *http.Request, id int64) {
+	if isHTMX(r) {
+		page, _ := h.repo.GetPage(id)
+		h.tmpl.ExecuteTemplate(w, "page-row", page)
+	} else {
+		http.Redirect(w, r, "/pages", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,48 @@
+// Package linkcheck decides when a bookmarked page has gone for good, and
+// flags it with a pointer to an archived copy where one exists. Both the
+// watcher and the check-links command go through it.
+package linkcheck
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// Gone reports whether fetching a page showed it is gone for good: the
+// server answered 404 Not Found or 410 Gone, or its domain no longer
+// resolves. status is the HTTP status received, or 0 when err kept the
+// request from getting one. Timeouts, refused connections, bot protection
+// answering 403 or 429 and server errors tend to pass, so they do not count.
+func Gone(status int, err error) bool {
+	if status == http.StatusNotFound || status == http.StatusGone {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// MarkBroken flags page as broken and, given a provider, looks for an
+// archived copy to fall back on unless it has one already. A failed lookup
+// is only logged, as it leaves the page no worse off.
+func MarkBroken(repo repository.Store, provider archive.Provider, page *models.Page) error {
+	if err := repo.SetPageBroken(page.ID, true); err != nil {
+		return err
+	}
+	if provider == nil || page.ExternalArchiveURL != "" {
+		return nil
+	}
+	snapshot, err := provider.Lookup(page.URL())
+	if err != nil {
+		if !errors.Is(err, archive.ErrNotArchived) {
+			log.Printf("%s lookup for page %d failed: %v", provider.Name(), page.ID, err)
+		}
+		return nil
+	}
+	return repo.SetPageExternalArchive(page.ID, snapshot.URL, snapshot.Timestamp)
+}
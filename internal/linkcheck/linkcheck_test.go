@@ -0,0 +1,100 @@
+package linkcheck
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+func TestGone(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		want   bool
+	}{
+		{404, nil, true},
+		{410, nil, true},
+		{0, fmt.Errorf("fetch: %w", &net.DNSError{Err: "no such host", Name: "gone.example", IsNotFound: true}), true},
+		{0, &net.DNSError{Err: "server misbehaving", Name: "flaky.example", IsTemporary: true}, false},
+		{0, errors.New("connection refused"), false},
+		{403, nil, false},
+		{429, nil, false},
+		{500, nil, false},
+		{503, nil, false},
+		{200, nil, false},
+	}
+	for _, tt := range tests {
+		if got := Gone(tt.status, tt.err); got != tt.want {
+			t.Errorf("Gone(%d, %v) = %v, want %v", tt.status, tt.err, got, tt.want)
+		}
+	}
+}
+
+// stubProvider answers lookups with snapshot, or ErrNotArchived when nil.
+type stubProvider struct {
+	snapshot *archive.Snapshot
+	lookups  int
+}
+
+func (p *stubProvider) Name() string { return "stub" }
+
+func (p *stubProvider) Lookup(rawURL string) (*archive.Snapshot, error) {
+	p.lookups++
+	if p.snapshot == nil {
+		return nil, archive.ErrNotArchived
+	}
+	return p.snapshot, nil
+}
+
+func (p *stubProvider) Save(rawURL string) (*archive.Snapshot, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestMarkBroken(t *testing.T) {
+	repo := repository.NewMemory()
+	siteID, err := repo.CreateSite(nil, "example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID, err := repo.CreatePage(siteID, "/gone", "Gone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := repo.GetPage(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing archived: flagged all the same
+	provider := &stubProvider{}
+	if err := MarkBroken(repo, provider, page); err != nil {
+		t.Fatal(err)
+	}
+	page, _ = repo.GetPage(pageID)
+	if page.BrokenAt == nil || page.ExternalArchiveURL != "" {
+		t.Fatalf("broken at %v, archived at %q", page.BrokenAt, page.ExternalArchiveURL)
+	}
+
+	snapshotURL := "https://web.archive.org/web/20240102030405/https://example.com/gone"
+	provider.snapshot = &archive.Snapshot{URL: snapshotURL, Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := MarkBroken(repo, provider, page); err != nil {
+		t.Fatal(err)
+	}
+	page, _ = repo.GetPage(pageID)
+	if page.ExternalArchiveURL != snapshotURL {
+		t.Fatalf("archived at %q, want %q", page.ExternalArchiveURL, snapshotURL)
+	}
+
+	// A page with an archived copy is not looked up again
+	if err := MarkBroken(repo, provider, page); err != nil {
+		t.Fatal(err)
+	}
+	if provider.lookups != 2 {
+		t.Errorf("%d lookups, want 2", provider.lookups)
+	}
+}
@@ -0,0 +1,27 @@
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var (
+	converter = goldmark.New(goldmark.WithExtensions(extension.GFM))
+	// Notes are written by users but may be pasted from anywhere, so the
+	// rendered HTML goes through a whitelist before reaching a template.
+	policy = bluemonday.UGCPolicy()
+)
+
+// Render converts Markdown to sanitized HTML that is safe to embed in a
+// page.
+func Render(src string) template.HTML {
+	var buf bytes.Buffer
+	if err := converter.Convert([]byte(src), &buf); err != nil {
+		return template.HTML(template.HTMLEscapeString(src))
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes()))
+}
@@ -1,25 +1,39 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Category struct {
 	ID          int64
+	ParentID    *int64
 	Name        string
 	Description string
 	CreatedAt   time.Time
 	SiteCount   int // computed field
+	Depth       int // computed field - 0 for top-level categories
 }
 
 type Site struct {
 	ID           int64
 	CategoryID   *int64
-	CategoryName string // computed field
+	CategoryName string     // computed field
+	CategoryPath []Category // computed field - ancestors of the category, outermost first, ending with it
 	Domain       string
 	Name         string
 	Description  string
 	CreatedAt    time.Time
-	PageCount    int    // computed field
-	Tags         []Tag  // computed field
+	PageCount    int   // computed field
+	Tags         []Tag // computed field
+	Favorite     bool
+	PinnedAt     *time.Time // set while pinned to the dashboard
+	Rating       int        // 1 to MaxRating stars, 0 when unrated
+	DeletedAt    *time.Time // set while in the trash
 }
 
 type Page struct {
@@ -30,20 +44,292 @@ type Page struct {
 	Title       string
 	Description string
 	CreatedAt   time.Time
-	Tags        []Tag  // computed field - page's own tags
-	SiteTags    []Tag  // computed field - inherited from site
+	ArchivedAt  *time.Time
+	ArchiveSize int64
+	Watched     bool
+	CheckedAt   *time.Time
+	ChangedAt   *time.Time // set when a watched page's text changes
+	BrokenAt    *time.Time // set while the page cannot be fetched
+	// Copy held by an external archive such as the Wayback Machine
+	ExternalArchiveURL string
+	ExternalArchivedAt *time.Time
+	// Read-later state, empty when the page was never queued
+	ReadStatus   string
+	ReadStatusAt *time.Time
+	QueuedAt     *time.Time // when the page last entered the queue
+	VisitedAt    *time.Time // when the page was last opened from the app
+	Favorite     bool
+	PinnedAt     *time.Time // set while pinned to the dashboard
+	Rating       int        // 1 to MaxRating stars, 0 when unrated
+	DeletedAt    *time.Time // set while in the trash
+	Tags         []Tag      // computed field - page's own tags
+	SiteTags     []Tag      // computed field - inherited from site
 }
 
+// MaxRating is the highest star rating a page or site can be given.
+const MaxRating = 5
+
+// URL returns the absolute address of the page.
+func (p Page) URL() string {
+	return "https://" + p.SiteDomain + p.Path
+}
+
+// Read-later states. Unread and reading pages make up the reading queue.
+const (
+	StatusUnread   = "unread"
+	StatusReading  = "reading"
+	StatusRead     = "read"
+	StatusArchived = "archived"
+)
+
+// ReadStatuses lists the read-later states in the order a page moves
+// through them.
+var ReadStatuses = []string{StatusUnread, StatusReading, StatusRead, StatusArchived}
+
+// ReadEvent records a page's read-later status changing; an empty Status
+// means it was taken out of the queue altogether.
+type ReadEvent struct {
+	PageID    int64
+	Status    string
+	ChangedAt time.Time
+}
+
+// PageContent is the readable text extracted from a page's HTML.
+type PageContent struct {
+	PageID      int64
+	Text        string
+	ExtractedAt time.Time
+}
+
+// PageVersion is one distinct revision of a watched page's text. Diff is
+// empty for the first version and otherwise holds the diff.Format output
+// against the previous version.
+type PageVersion struct {
+	ID        int64
+	PageID    int64
+	Text      string
+	Hash      string
+	Diff      string
+	FetchedAt time.Time
+}
+
+// TagSeparator splits a tag name into its path, so "lang/go" is a child of
+// "lang".
+const TagSeparator = "/"
+
 type Tag struct {
 	ID        int64
 	Name      string
-	SiteCount int // computed field
+	Aliases   []string // other names that resolve to this tag
+	SiteCount int      // computed field
+	PageCount int      // computed field
+	Depth     int      // computed field: number of existing ancestor tags
+}
+
+// DeletedTag is what is left of a deleted tag, enough to restore it with
+// its aliases and the sites and pages it was on.
+type DeletedTag struct {
+	ID      int64
+	Name    string
+	Aliases []string
+	SiteIDs []int64
+	PageIDs []int64
+}
+
+// Leaf returns the last segment of the tag's name.
+func (t Tag) Leaf() string {
+	return t.Name[strings.LastIndex(t.Name, TagSeparator)+1:]
+}
+
+// Includes reports whether name is this tag or one of its descendants.
+func (t Tag) Includes(name string) bool {
+	return name == t.Name || strings.HasPrefix(name, t.Name+TagSeparator)
+}
+
+// SavedSearch is a named page filter, kept as the query string of the
+// pages list and evaluated whenever it is opened.
+type SavedSearch struct {
+	ID        int64
+	Name      string
+	Query     string
+	CreatedAt time.Time
 	PageCount int // computed field
 }
 
+// Note is long-form Markdown attached to either a site or a page.
+type Note struct {
+	ID        int64
+	SiteID    *int64
+	PageID    *int64
+	Body      string
+	UpdatedAt time.Time
+}
+
+// NoteRevision is one saved version of a note, the newest matching the
+// note's current body.
+type NoteRevision struct {
+	ID        int64
+	NoteID    int64
+	Body      string
+	CreatedAt time.Time
+}
+
+// Where a highlight was taken from
+const (
+	HighlightReader  = "reader"
+	HighlightArchive = "archive"
+)
+
+// Highlight is a passage saved from a page, with an optional comment.
+type Highlight struct {
+	ID         int64
+	PageID     int64
+	PageTitle  string // computed field
+	SiteDomain string // computed field
+	PagePath   string // computed field
+	Text       string
+	Comment    string
+	Source     string
+	CreatedAt  time.Time
+}
+
+// PageURL returns the absolute address of the highlighted page.
+func (h Highlight) PageURL() string {
+	return "https://" + h.SiteDomain + h.PagePath
+}
+
+// Collection is a hand-curated, ordered list of sites and pages. A
+// non-empty ShareToken makes it readable without editing at /shared/{token}.
+type Collection struct {
+	ID          int64
+	Name        string
+	Description string
+	ShareToken  string
+	CreatedAt   time.Time
+	ItemCount   int // computed field
+	Items       []CollectionItem
+}
+
+// CollectionItem is an entry in a collection; exactly one of Site and Page
+// is set.
+type CollectionItem struct {
+	ID           int64
+	CollectionID int64
+	Position     int
+	Note         string
+	Site         *Site
+	Page         *Page
+	CreatedAt    time.Time
+}
+
 type DashboardStats struct {
 	CategoryCount int
 	SiteCount     int
 	PageCount     int
 	RecentPages   []Page
+	QueueCount    int
+	ReadingQueue  []Page // oldest queued first
+	PinnedSites   []Site // most recently pinned first
+	PinnedPages   []Page
+}
+
+// Entities recorded in the activity log
+const (
+	EntityCategory    = "category"
+	EntitySite        = "site"
+	EntityPage        = "page"
+	EntityTag         = "tag"
+	EntitySavedSearch = "saved_search"
+	EntityHighlight   = "highlight"
+	EntityCollection  = "collection"
+)
+
+// Entities lists the kinds of entity that can be filtered on in the
+// activity log.
+var Entities = []string{EntityCategory, EntitySite, EntityPage, EntityTag,
+	EntitySavedSearch, EntityHighlight, EntityCollection}
+
+// Actions recorded in the activity log
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+	ActionMerge   = "merge"
+)
+
+var Actions = []string{ActionCreate, ActionUpdate, ActionDelete, ActionRestore, ActionPurge, ActionMerge}
+
+// Event is an entry in the activity log: one change to one entity, with
+// the entity's stored fields before and after it.
+type Event struct {
+	ID        int64
+	Entity    string
+	EntityID  int64
+	Action    string
+	Before    map[string]interface{} // nil when the entity was created
+	After     map[string]interface{} // nil when the entity was removed for good
+	Actor     string
+	CreatedAt time.Time
+}
+
+// FieldChange is a field whose value differs before and after an event.
+type FieldChange struct {
+	Field  string
+	Before interface{}
+	After  interface{}
+}
+
+// Changes lists the fields the event changed, sorted by name.
+func (e Event) Changes() []FieldChange {
+	fields := map[string]bool{}
+	for f := range e.Before {
+		fields[f] = true
+	}
+	for f := range e.After {
+		fields[f] = true
+	}
+
+	var changes []FieldChange
+	for f := range fields {
+		if !reflect.DeepEqual(e.Before[f], e.After[f]) {
+			changes = append(changes, FieldChange{Field: f, Before: e.Before[f], After: e.After[f]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// Label names the entity as it was at the time of the event.
+func (e Event) Label() string {
+	for _, state := range []map[string]interface{}{e.After, e.Before} {
+		for _, field := range []string{"name", "domain", "title", "path", "text"} {
+			if s, ok := state[field].(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return "#" + strconv.FormatInt(e.EntityID, 10)
+}
+
+// BeforeText and AfterText format the values for display.
+func (c FieldChange) BeforeText() string { return fieldText(c.Before) }
+func (c FieldChange) AfterText() string  { return fieldText(c.After) }
+
+func fieldText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = fieldText(item)
+		}
+		return strings.Join(parts, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
 }
@@ -0,0 +1,273 @@
+package reader
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const maxDocumentSize = 5 << 20
+
+var (
+	// unlikelyRegex matches class and id values of page furniture.
+	unlikelyRegex = regexp.MustCompile(`(?i)\b(ad|ads|advert\w*|banner|breadcrumbs?|comments?|cookie\w*|disqus|footer|header|masthead|menu|modal|nav\w*|newsletter|pagination|popup|promo\w*|related|share|sharing|sidebar|social|sponsor\w*|subscribe|widget)\b`)
+	// likelyRegex rescues containers whose names also look like content.
+	likelyRegex = regexp.MustCompile(`(?i)\b(article|body|content|entry|main|post|story|text)\b`)
+	spaceRegex  = regexp.MustCompile(`\s+`)
+)
+
+// Article is the readable part of a web page.
+type Article struct {
+	Title string
+	// Text holds the article body as plain-text paragraphs separated by
+	// blank lines.
+	Text string
+}
+
+// Paragraphs splits text stored from an Article back into paragraphs.
+func Paragraphs(text string) []string {
+	var paragraphs []string
+	for _, p := range strings.Split(text, "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+var client = &http.Client{
+	Timeout: 15 * time.Second,
+}
+
+// StatusError is returned by Fetch when the server answers with anything
+// but 200 OK.
+type StatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fetching %s: %s", e.URL, e.Status)
+}
+
+// Fetch downloads rawURL and extracts its article.
+func Fetch(rawURL string) (*Article, error) {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{URL: rawURL, Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+
+	return Parse(io.LimitReader(resp.Body, maxDocumentSize))
+}
+
+// Parse extracts the article from an HTML document, dropping navigation,
+// advertising and other boilerplate around it.
+func Parse(r io.Reader) (*Article, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	article := &Article{}
+	if title := find(doc, atom.Title); title != nil {
+		article.Title = collapse(textOf(title))
+	}
+
+	body := find(doc, atom.Body)
+	if body == nil {
+		return article, nil
+	}
+	prune(body)
+
+	root := find(body, atom.Article)
+	if root == nil {
+		root = find(body, atom.Main)
+	}
+	if root == nil {
+		root = bestCandidate(body)
+	}
+
+	var paragraphs []string
+	collectBlocks(root, &paragraphs)
+	article.Text = strings.Join(paragraphs, "\n\n")
+	return article, nil
+}
+
+// prune removes elements that never hold article content.
+func prune(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.CommentNode || (c.Type == html.ElementNode && unlikely(c)) {
+			n.RemoveChild(c)
+		} else {
+			prune(c)
+		}
+		c = next
+	}
+}
+
+func unlikely(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Nav, atom.Header, atom.Footer,
+		atom.Aside, atom.Form, atom.Iframe, atom.Svg, atom.Button, atom.Select, atom.Template:
+		return true
+	case atom.Body, atom.Article, atom.Main:
+		return false
+	}
+	if attr(n, "aria-hidden") == "true" || hasAttr(n, "hidden") {
+		return true
+	}
+	switch attr(n, "role") {
+	case "navigation", "banner", "complementary", "contentinfo", "dialog", "menu":
+		return true
+	}
+	names := attr(n, "class") + " " + attr(n, "id")
+	return unlikelyRegex.MatchString(names) && !likelyRegex.MatchString(names)
+}
+
+// bestCandidate scores each paragraph's parent (and, at half weight, its
+// grandparent) by the amount of prose it holds and returns the highest
+// scoring container.
+func bestCandidate(body *html.Node) *html.Node {
+	scores := map[*html.Node]float64{}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.DataAtom == atom.P || n.DataAtom == atom.Pre || n.DataAtom == atom.Blockquote) {
+			text := collapse(textOf(n))
+			if len(text) >= 25 {
+				score := 1 + float64(strings.Count(text, ",")) + float64(min(len(text)/100, 3))
+				score *= 1 - linkDensity(n)
+				if p := n.Parent; p != nil {
+					scores[p] += score
+					if gp := p.Parent; gp != nil {
+						scores[gp] += score / 2
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+
+	best, bestScore := body, 0.0
+	for n, score := range scores {
+		if score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	return best
+}
+
+// collectBlocks flattens n into paragraphs, one per block-level element.
+func collectBlocks(n *html.Node, out *[]string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.DataAtom {
+		case atom.P, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+			atom.Li, atom.Blockquote, atom.Dd, atom.Dt, atom.Figcaption, atom.Td:
+			if linkDensity(c) > 0.5 {
+				continue
+			}
+			if text := collapse(textOf(c)); text != "" {
+				*out = append(*out, text)
+			}
+		case atom.Pre:
+			if text := strings.TrimSpace(textOf(c)); text != "" {
+				*out = append(*out, text)
+			}
+		default:
+			collectBlocks(c, out)
+		}
+	}
+}
+
+// linkDensity is the share of n's text that sits inside links.
+func linkDensity(n *html.Node) float64 {
+	total := len(collapse(textOf(n)))
+	if total == 0 {
+		return 0
+	}
+	linked := 0
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			linked += len(collapse(textOf(n)))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return float64(linked) / float64(total)
+}
+
+func find(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := find(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textOf(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+		case html.ElementNode:
+			if n.DataAtom == atom.Br {
+				b.WriteString("\n")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func collapse(s string) string {
+	return strings.TrimSpace(spaceRegex.ReplaceAllString(s, " "))
+}
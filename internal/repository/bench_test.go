@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+// The list queries run against a generated SQLite database, each next to
+// the row-by-row tag loading it replaced:
+//
+//	go test ./internal/repository -run '^$' -bench . -benchmem
+//
+// The row-by-row variants run today's query and then load tags one row at a
+// time as the old code did, so they slightly overstate what the old code
+// cost.
+const (
+	benchSites       = 200
+	benchPages       = 5000
+	benchTags        = 50
+	benchTagsPerItem = 3
+)
+
+// benchRepository opens a SQLite repository holding the generated data.
+func benchRepository(b *testing.B) *Repository {
+	db := openSQLite(b)
+	if err := seed(db, benchSites, benchPages, benchTags, benchTagsPerItem); err != nil {
+		b.Fatalf("Failed to generate data: %v", err)
+	}
+	return New(db)
+}
+
+// benchmark runs op b.N times, failing on its first error.
+func benchmark(b *testing.B, op func() error) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := op(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetPages(b *testing.B) {
+	repo := benchRepository(b)
+	b.Run("batched", func(b *testing.B) {
+		benchmark(b, func() error {
+			_, err := repo.GetPages(PageFilter{})
+			return err
+		})
+	})
+	b.Run("row-by-row", func(b *testing.B) {
+		benchmark(b, func() error { return pagesRowByRow(repo) })
+	})
+}
+
+func BenchmarkGetSites(b *testing.B) {
+	repo := benchRepository(b)
+	b.Run("batched", func(b *testing.B) {
+		benchmark(b, func() error {
+			_, err := repo.GetSites(SiteFilter{})
+			return err
+		})
+	})
+	b.Run("row-by-row", func(b *testing.B) {
+		benchmark(b, func() error { return sitesRowByRow(repo) })
+	})
+}
+
+// BenchmarkTagItems lists a tag's sites and pages, as the tag page does.
+func BenchmarkTagItems(b *testing.B) {
+	repo := benchRepository(b)
+	tagID := int64(1)
+	b.Run("in-sql", func(b *testing.B) {
+		benchmark(b, func() error {
+			if _, err := repo.GetSites(SiteFilter{TagID: &tagID}); err != nil {
+				return err
+			}
+			_, err := repo.GetPages(PageFilter{Tags: TagFilter{All: []int64{tagID}, IncludeSiteTags: true}})
+			return err
+		})
+	})
+	b.Run("filter-in-go", func(b *testing.B) {
+		benchmark(b, func() error { return tagItemsInGo(repo, tagID) })
+	})
+}
+
+// pagesRowByRow lists pages the way GetPages used to: one query for the
+// pages, then two more for the tags of each of them.
+func pagesRowByRow(repo *Repository) error {
+	pages, err := repo.GetPages(PageFilter{})
+	if err != nil {
+		return err
+	}
+	for i := range pages {
+		if pages[i].Tags, err = repo.GetPageTags(pages[i].ID); err != nil {
+			return err
+		}
+		if pages[i].SiteTags, err = repo.GetSiteTags(pages[i].SiteID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sitesRowByRow(repo *Repository) error {
+	sites, err := repo.GetSites(SiteFilter{})
+	if err != nil {
+		return err
+	}
+	for i := range sites {
+		if sites[i].Tags, err = repo.GetSiteTags(sites[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagItemsInGo finds a tag's sites the way the tag page used to, by loading
+// every site and checking its tags.
+func tagItemsInGo(repo *Repository, tagID int64) error {
+	if err := sitesRowByRow(repo); err != nil {
+		return err
+	}
+	_, err := repo.GetPages(PageFilter{Tags: TagFilter{All: []int64{tagID}, IncludeSiteTags: true}})
+	return err
+}
+
+// seed fills the database in a single transaction, bypassing the
+// repository and its activity log.
+func seed(db *sql.DB, sites, pages, tags, tagsPerItem int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := 1; i <= tags; i++ {
+		if _, err := tx.Exec(`INSERT INTO tags (id, name) VALUES (?, ?)`, i, fmt.Sprintf("tag%d", i)); err != nil {
+			return err
+		}
+	}
+	for i := 1; i <= sites; i++ {
+		if _, err := tx.Exec(`INSERT INTO sites (id, domain, name) VALUES (?, ?, ?)`,
+			i, fmt.Sprintf("site%d.example.com", i), fmt.Sprintf("Site %d", i)); err != nil {
+			return err
+		}
+		for j := 0; j < tagsPerItem && j < tags; j++ {
+			if _, err := tx.Exec(`INSERT INTO site_tags (site_id, tag_id) VALUES (?, ?)`,
+				i, (i+j)%tags+1); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 1; i <= pages && sites > 0; i++ {
+		if _, err := tx.Exec(`INSERT INTO pages (id, site_id, path, title) VALUES (?, ?, ?, ?)`,
+			i, i%sites+1, fmt.Sprintf("/page/%d", i), fmt.Sprintf("Page %d", i)); err != nil {
+			return err
+		}
+		for j := 0; j < tagsPerItem && j < tags; j++ {
+			if _, err := tx.Exec(`INSERT INTO page_tags (page_id, tag_id) VALUES (?, ?)`,
+				i, (i*7+j)%tags+1); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialect covers what differs between the SQL databases a Repository can
+// keep its data in. Queries are written for SQLite with ? placeholders and
+// stick to syntax both databases accept; the rest goes through here.
+type dialect struct {
+	postgres bool
+	rebound  *sync.Map // query text to its rewritten form
+}
+
+var (
+	sqliteDialect   = dialect{}
+	postgresDialect = dialect{postgres: true, rebound: &sync.Map{}}
+)
+
+// rebind rewrites ? placeholders into PostgreSQL's numbered $1, $2, ...
+// and remembers the result. Queries never contain a literal question mark
+// inside quotes.
+func (d dialect) rebind(query string) string {
+	if !d.postgres {
+		return query
+	}
+	if q, ok := d.rebound.Load(query); ok {
+		return q.(string)
+	}
+	q := d.rebindOnce(query)
+	d.rebound.Store(query, q)
+	return q
+}
+
+// rebindOnce is rebind for queries whose text varies from call to call,
+// which are not worth remembering.
+func (d dialect) rebindOnce(query string) string {
+	if !d.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			b.WriteByte(query[i])
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// unitSeparator is an expression for the ASCII unit separator, which sorts
+// before every printable character.
+func (d dialect) unitSeparator() string {
+	if d.postgres {
+		return "chr(31)"
+	}
+	return "char(31)"
+}
+
+// bytewise is the collation clause comparing text byte by byte, which is
+// SQLite's default.
+func (d dialect) bytewise() string {
+	if d.postgres {
+		return ` COLLATE "C"`
+	}
+	return ""
+}
+
+// timestamp converts t into a value comparable with stored timestamps,
+// which SQLite keeps as UTC text.
+func (d dialect) timestamp(t time.Time) interface{} {
+	if d.postgres {
+		return t
+	}
+	return t.UTC().Format(time.DateTime)
+}
+
+// forUpdate is the clause locking the rows a SELECT reads until the
+// transaction ends. SQLite transactions take the write lock as they begin
+// and need none.
+func (d dialect) forUpdate() string {
+	if d.postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// condition is one term of a WHERE clause with its single argument.
+type condition struct {
+	sql string
+	arg interface{}
+}
+
+// contains matches column against a substring of query, ignoring case.
+func (d dialect) contains(column, query string) condition {
+	if d.postgres {
+		return condition{column + " ILIKE ?", "%" + query + "%"}
+	}
+	return condition{column + " LIKE ?", "%" + query + "%"}
+}
+
+// matches matches prose in expr against query. SQLite looks for the query
+// as a substring; PostgreSQL runs a full-text search, served by the GIN
+// index created on each expression searched this way.
+func (d dialect) matches(expr, query string) condition {
+	if d.postgres {
+		return condition{"to_tsvector('english', " + expr + ") @@ websearch_to_tsquery('english', ?)", query}
+	}
+	return d.contains(expr, query)
+}
+
+// alternatives collects conditions of which any one may hold.
+type alternatives struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (a *alternatives) add(c condition) {
+	a.conditions = append(a.conditions, c.sql)
+	a.args = append(a.args, c.arg)
+}
+
+// addExists adds a condition holding when a row of from, a table and WHERE
+// clause such as "notes n WHERE n.page_id = p.id", also meets c.
+func (a *alternatives) addExists(from string, c condition) {
+	a.add(condition{"EXISTS (SELECT 1 FROM " + from + " AND " + c.sql + ")", c.arg})
+}
+
+func (a *alternatives) where() (string, []interface{}) {
+	return "(" + strings.Join(a.conditions, " OR ") + ")", a.args
+}
+
+// conn and txn run queries through the dialect and the statement cache
+// before handing them to the database. They satisfy querier. A conn reads
+// from DB and writes through writer, which may be the same pool; each pool
+// has its own statements.
+type conn struct {
+	*sql.DB
+	writer  *sql.DB
+	dialect dialect
+	stmts   *statementCache
+	writes  *statementCache
+}
+
+type txn struct {
+	*sql.Tx
+	dialect dialect
+	stmts   *statementCache
+	missed  []string // queries run unprepared, to prepare once committed
+}
+
+func newConn(db, writer *sql.DB, d dialect) *conn {
+	c := &conn{DB: db, writer: writer, dialect: d, stmts: newStatementCache(db)}
+	c.writes = c.stmts
+	if writer != db {
+		c.writes = newStatementCache(writer)
+	}
+	return c
+}
+
+func (c *conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	query = c.dialect.rebind(query)
+	if stmt := c.stmts.get(query); stmt != nil {
+		return stmt.Query(args...)
+	}
+	return c.DB.Query(query, args...)
+}
+
+// QueryOnce runs a query built with a varying number of placeholders, such
+// as an IN list of IDs, unprepared: each length is a different statement,
+// and caching them would fill the cache with ones rarely used again.
+func (c *conn) QueryOnce(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebindOnce(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	query = c.dialect.rebind(query)
+	if stmt := c.stmts.get(query); stmt != nil {
+		return stmt.QueryRow(args...)
+	}
+	return c.DB.QueryRow(query, args...)
+}
+
+// QueryRowOnce is QueryRow for queries whose text varies, as QueryOnce.
+func (c *conn) QueryRowOnce(query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebindOnce(query), args...)
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	query = c.dialect.rebind(query)
+	if stmt := c.writes.get(query); stmt != nil {
+		return stmt.Exec(args...)
+	}
+	return c.writer.Exec(query, args...)
+}
+
+// Begin starts a transaction on the writer pool.
+func (c *conn) Begin() (*txn, error) {
+	tx, err := c.writer.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, dialect: c.dialect, stmts: c.writes}, nil
+}
+
+// Statements used in a transaction are bound to it with Tx.Stmt, which
+// reuses the statement already prepared on the transaction's connection.
+// Queries not yet in the cache run unprepared, and are prepared once the
+// transaction has committed and given its connection back.
+
+func (t *txn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	query = t.dialect.rebind(query)
+	if stmt := t.stmts.cached(query); stmt != nil {
+		return t.Tx.Stmt(stmt).Query(args...)
+	}
+	t.missed = append(t.missed, query)
+	return t.Tx.Query(query, args...)
+}
+
+func (t *txn) QueryRow(query string, args ...interface{}) *sql.Row {
+	query = t.dialect.rebind(query)
+	if stmt := t.stmts.cached(query); stmt != nil {
+		return t.Tx.Stmt(stmt).QueryRow(args...)
+	}
+	t.missed = append(t.missed, query)
+	return t.Tx.QueryRow(query, args...)
+}
+
+func (t *txn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	query = t.dialect.rebind(query)
+	if stmt := t.stmts.cached(query); stmt != nil {
+		return t.Tx.Stmt(stmt).Exec(args...)
+	}
+	t.missed = append(t.missed, query)
+	return t.Tx.Exec(query, args...)
+}
+
+func (t *txn) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	for _, query := range t.missed {
+		t.stmts.get(query)
+	}
+	return nil
+}
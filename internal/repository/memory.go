@@ -0,0 +1,2843 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+)
+
+// Memory is the Store kept in process memory. It behaves like Repository,
+// trash and activity log included, which makes it suitable for tests and
+// for trying the application out, but everything is lost when the process
+// exits.
+type Memory struct {
+	*memoryData
+	actor string // who changes are attributed to in the activity log
+}
+
+// memoryData holds the tables shared by a Memory and the stores its As
+// method returns. Every method takes mu for its whole duration, so the
+// helpers below assume it is held.
+type memoryData struct {
+	mu     sync.Mutex
+	lastID map[string]int64 // last ID handed out, by table
+
+	categories    map[int64]*models.Category
+	sites         map[int64]*models.Site
+	pages         map[int64]*memoryPage
+	tags          map[int64]string         // tag names by ID
+	aliases       map[string]int64         // tag IDs by alias
+	siteTags      map[int64]map[int64]bool // tag IDs by site ID
+	pageTags      map[int64]map[int64]bool // tag IDs by page ID
+	contents      map[int64]models.PageContent
+	versions      []models.PageVersion
+	readEvents    []memoryReadEvent
+	savedSearches map[int64]*models.SavedSearch
+	notes         map[int64]*models.Note
+	revisions     []models.NoteRevision
+	highlights    map[int64]*models.Highlight
+	collections   map[int64]*models.Collection
+	items         map[int64]*memoryItem
+	events        []memoryEvent
+}
+
+type memoryPage struct {
+	models.Page
+	deletedWithSite bool
+}
+
+type memoryReadEvent struct {
+	id int64
+	models.ReadEvent
+}
+
+// memoryItem is a collection item; the Site and Page it refers to are
+// looked up when it is read.
+type memoryItem struct {
+	models.CollectionItem
+	siteID, pageID *int64
+}
+
+// memoryEvent keeps an event's states as JSON, as the events table does.
+type memoryEvent struct {
+	models.Event
+	before, after []byte
+}
+
+// The constraint violations SQLite reports for the same operations
+var errForeignKey = errors.New("FOREIGN KEY constraint failed")
+
+func errUnique(columns string) error {
+	return errors.New("UNIQUE constraint failed: " + columns)
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		memoryData: &memoryData{
+			lastID:        map[string]int64{},
+			categories:    map[int64]*models.Category{},
+			sites:         map[int64]*models.Site{},
+			pages:         map[int64]*memoryPage{},
+			tags:          map[int64]string{},
+			aliases:       map[string]int64{},
+			siteTags:      map[int64]map[int64]bool{},
+			pageTags:      map[int64]map[int64]bool{},
+			contents:      map[int64]models.PageContent{},
+			savedSearches: map[int64]*models.SavedSearch{},
+			notes:         map[int64]*models.Note{},
+			highlights:    map[int64]*models.Highlight{},
+			collections:   map[int64]*models.Collection{},
+			items:         map[int64]*memoryItem{},
+		},
+		actor: DefaultActor,
+	}
+}
+
+// As returns a store sharing the same data that attributes the changes it
+// makes to actor.
+func (m *Memory) As(actor string) Store {
+	return &Memory{memoryData: m.memoryData, actor: actor}
+}
+
+func (m *Memory) nextID(table string) int64 {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// memoryNow returns the current time at the precision of CURRENT_TIMESTAMP.
+func memoryNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// byID returns the rows of a table in ID order, the order SQLite scans
+// them in.
+func byID[T any](table map[int64]*T) []*T {
+	rows := make([]*T, 0, len(table))
+	for _, id := range slices.Sorted(maps.Keys(table)) {
+		rows = append(rows, table[id])
+	}
+	return rows
+}
+
+func copyID(id *int64) *int64 {
+	if id == nil {
+		return nil
+	}
+	v := *id
+	return &v
+}
+
+// containsFold reports whether any of the fields contains query, ignoring
+// case like LIKE does.
+func containsFold(query string, fields ...string) bool {
+	query = strings.ToLower(query)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// newerFirst and olderFirst order optional times the way SQLite does,
+// with NULLs last when descending and first when ascending.
+func newerFirst(a, b *time.Time) bool {
+	if a == nil {
+		return false
+	}
+	return b == nil || a.After(*b)
+}
+
+func olderFirst(a, b *time.Time) bool {
+	if b == nil {
+		return false
+	}
+	return a == nil || a.Before(*b)
+}
+
+func link(links map[int64]map[int64]bool, ownerID, tagID int64) {
+	if links[ownerID] == nil {
+		links[ownerID] = map[int64]bool{}
+	}
+	links[ownerID][tagID] = true
+}
+
+// Categories
+
+func (m *Memory) GetCategories() ([]models.Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	children := map[int64][]*models.Category{}
+	var roots []*models.Category
+	for _, c := range m.categories {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+
+	var categories []models.Category
+	var walk func(level []*models.Category)
+	walk = func(level []*models.Category) {
+		sort.Slice(level, func(i, j int) bool { return level[i].Name < level[j].Name })
+		for _, c := range level {
+			categories = append(categories, m.categoryRow(c))
+			walk(children[c.ID])
+		}
+	}
+	walk(roots)
+	return categories, nil
+}
+
+func (m *Memory) GetCategory(id int64) (*models.Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.categories[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	row := m.categoryRow(c)
+	return &row, nil
+}
+
+func (m *Memory) CreateCategory(name, description string, parentID *int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recordCreate(models.EntityCategory, func() (int64, error) {
+		if err := m.checkCategory(0, name, parentID); err != nil {
+			return 0, err
+		}
+		id := m.nextID("categories")
+		m.categories[id] = &models.Category{ID: id, ParentID: copyID(parentID), Name: name,
+			Description: description, CreatedAt: memoryNow()}
+		return id, nil
+	})
+}
+
+func (m *Memory) UpdateCategory(id int64, name, description string, parentID *int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityCategory, id, models.ActionUpdate, func() error {
+		if parentID != nil && m.categorySubtree(id)[*parentID] {
+			return ErrCategoryCycle
+		}
+		c, ok := m.categories[id]
+		if !ok {
+			return nil
+		}
+		if err := m.checkCategory(id, name, parentID); err != nil {
+			return err
+		}
+		c.Name, c.Description, c.ParentID = name, description, copyID(parentID)
+		return nil
+	})
+}
+
+func (m *Memory) DeleteCategory(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityCategory, id, models.ActionDelete, func() error {
+		delete(m.categories, id)
+		for _, c := range m.categories {
+			if c.ParentID != nil && *c.ParentID == id {
+				c.ParentID = nil
+			}
+		}
+		for _, s := range m.sites {
+			if s.CategoryID != nil && *s.CategoryID == id {
+				s.CategoryID = nil
+			}
+		}
+		return nil
+	})
+}
+
+// checkCategory enforces the unique category name and the parent reference.
+func (m *Memory) checkCategory(id int64, name string, parentID *int64) error {
+	for _, c := range m.categories {
+		if c.Name == name && c.ID != id {
+			return errUnique("categories.name")
+		}
+	}
+	if parentID != nil && m.categories[*parentID] == nil {
+		return errForeignKey
+	}
+	return nil
+}
+
+// categoryRow fills in a category's site count and depth.
+func (m *Memory) categoryRow(c *models.Category) models.Category {
+	row := *c
+	for _, s := range m.sites {
+		if s.DeletedAt == nil && s.CategoryID != nil && *s.CategoryID == c.ID {
+			row.SiteCount++
+		}
+	}
+	for id := c.ParentID; id != nil; {
+		parent, ok := m.categories[*id]
+		if !ok {
+			break
+		}
+		row.Depth++
+		id = parent.ParentID
+	}
+	return row
+}
+
+// categorySubtree returns the IDs of a category and all of its descendants.
+func (m *Memory) categorySubtree(id int64) map[int64]bool {
+	ids := map[int64]bool{id: true}
+	for added := true; added; {
+		added = false
+		for _, c := range m.categories {
+			if c.ParentID != nil && ids[*c.ParentID] && !ids[c.ID] {
+				ids[c.ID] = true
+				added = true
+			}
+		}
+	}
+	return ids
+}
+
+func (m *Memory) categoryFilter(categoryID int64, includeDescendants bool) map[int64]bool {
+	if !includeDescendants {
+		return map[int64]bool{categoryID: true}
+	}
+	return m.categorySubtree(categoryID)
+}
+
+// categoryPath returns the breadcrumb trail of a category, outermost first.
+func (m *Memory) categoryPath(id *int64) []models.Category {
+	var path []models.Category
+	for id != nil {
+		c, ok := m.categories[*id]
+		if !ok {
+			break
+		}
+		path = append([]models.Category{m.categoryRow(c)}, path...)
+		id = c.ParentID
+	}
+	return path
+}
+
+// Sites
+
+func (m *Memory) GetSites(filter SiteFilter) ([]models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getSites(filter), nil
+}
+
+func (m *Memory) getSites(filter SiteFilter) []models.Site {
+	var categories map[int64]bool
+	if filter.CategoryID != nil {
+		categories = m.categoryFilter(*filter.CategoryID, filter.IncludeSubcategories)
+	}
+	var tags map[int64]bool
+	if filter.TagID != nil {
+		tags = m.tagSet(*filter.TagID, filter.IncludeSubtags)
+	}
+	tagged := func(siteID int64) bool {
+		for id := range tags {
+			if m.siteTags[siteID][id] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var sites []models.Site
+	for _, s := range byID(m.sites) {
+		switch {
+		case s.DeletedAt != nil,
+			categories != nil && (s.CategoryID == nil || !categories[*s.CategoryID]),
+			filter.Favorites && !s.Favorite,
+			filter.Pinned && s.PinnedAt == nil,
+			s.Rating < filter.MinRating,
+			tags != nil && !tagged(s.ID):
+			continue
+		}
+		sites = append(sites, m.siteDetails(s))
+	}
+	m.sortSites(sites, filter.Sort)
+	return paginate(sites, filter.Limit, filter.Offset)
+}
+
+func (m *Memory) CountSites(filter SiteFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	filter.Limit = 0
+	return len(m.getSites(filter)), nil
+}
+
+// paginate returns the part of items a filter's Limit and Offset select.
+func paginate[T any](items []T, limit, offset int) []T {
+	if limit <= 0 {
+		return items
+	}
+	if offset >= len(items) {
+		return nil
+	}
+	return items[offset:min(offset+limit, len(items))]
+}
+
+// sortSites puts sites in the order SiteFilter.orderBy gives.
+func (m *Memory) sortSites(sites []models.Site, order string) {
+	var less func(a, b *models.Site) bool
+	switch order {
+	case SortNewest:
+		less = func(a, b *models.Site) bool {
+			return a.CreatedAt.After(b.CreatedAt) || a.CreatedAt.Equal(b.CreatedAt) && a.ID > b.ID
+		}
+	case SortOldest:
+		less = func(a, b *models.Site) bool {
+			return a.CreatedAt.Before(b.CreatedAt) || a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID
+		}
+	case SortName:
+		name := func(s *models.Site) string {
+			if s.Name != "" {
+				return strings.ToLower(s.Name)
+			}
+			return strings.ToLower(s.Domain)
+		}
+		less = func(a, b *models.Site) bool {
+			return name(a) < name(b) || name(a) == name(b) && a.Domain < b.Domain
+		}
+	case SortRating:
+		less = func(a, b *models.Site) bool {
+			return a.Rating > b.Rating || a.Rating == b.Rating && a.Domain < b.Domain
+		}
+	case SortPageCount:
+		less = func(a, b *models.Site) bool {
+			return a.PageCount > b.PageCount || a.PageCount == b.PageCount && a.Domain < b.Domain
+		}
+	case SortVisited:
+		visited := map[int64]*time.Time{}
+		for _, p := range m.pages {
+			if p.DeletedAt == nil && newerFirst(p.VisitedAt, visited[p.SiteID]) {
+				visited[p.SiteID] = p.VisitedAt
+			}
+		}
+		less = func(a, b *models.Site) bool {
+			va, vb := visited[a.ID], visited[b.ID]
+			return newerFirst(va, vb) || !newerFirst(vb, va) && a.Domain < b.Domain
+		}
+	case SortPinned:
+		less = func(a, b *models.Site) bool { return newerFirst(a.PinnedAt, b.PinnedAt) }
+	default:
+		less = func(a, b *models.Site) bool { return a.Domain < b.Domain }
+	}
+	sort.SliceStable(sites, func(i, j int) bool { return less(&sites[i], &sites[j]) })
+}
+
+func (m *Memory) GetSite(id int64) (*models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sites[id]
+	if !ok || s.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	site := m.siteDetails(s)
+	return &site, nil
+}
+
+func (m *Memory) GetSiteByDomain(domain string) (*models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sites {
+		if s.Domain == domain && s.DeletedAt == nil {
+			site := m.siteRow(s)
+			return &site, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// CreateSite adds a site. Adding the domain of a site in the trash brings
+// that site back instead; pages trashed along with it stay in the trash.
+func (m *Memory) CreateSite(categoryID *int64, domain, name, description string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sites {
+		if s.Domain == domain && s.DeletedAt != nil {
+			id := s.ID
+			err := m.record(models.EntitySite, id, models.ActionRestore, func() error {
+				return m.untrashSite(id, false)
+			})
+			if err != nil {
+				return 0, err
+			}
+			return id, m.updateSite(id, categoryID, domain, name, description)
+		}
+	}
+
+	return m.recordCreate(models.EntitySite, func() (int64, error) {
+		if err := m.checkSite(0, categoryID, domain); err != nil {
+			return 0, err
+		}
+		id := m.nextID("sites")
+		m.sites[id] = &models.Site{ID: id, CategoryID: copyID(categoryID), Domain: domain, Name: name,
+			Description: description, CreatedAt: memoryNow()}
+		return id, nil
+	})
+}
+
+func (m *Memory) UpdateSite(id int64, categoryID *int64, domain, name, description string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updateSite(id, categoryID, domain, name, description)
+}
+
+func (m *Memory) updateSite(id int64, categoryID *int64, domain, name, description string) error {
+	return m.record(models.EntitySite, id, models.ActionUpdate, func() error {
+		s, ok := m.sites[id]
+		if !ok {
+			return nil
+		}
+		if err := m.checkSite(id, categoryID, domain); err != nil {
+			return err
+		}
+		s.CategoryID, s.Domain, s.Name, s.Description = copyID(categoryID), domain, name, description
+		return nil
+	})
+}
+
+// DeleteSite moves a site and all of its pages into the trash.
+func (m *Memory) DeleteSite(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySite, id, models.ActionDelete, func() error {
+		s, ok := m.sites[id]
+		if !ok || s.DeletedAt != nil {
+			return sql.ErrNoRows
+		}
+		now := memoryNow()
+		s.DeletedAt = &now
+		for _, p := range m.pages {
+			if p.SiteID == id && p.DeletedAt == nil {
+				p.DeletedAt, p.deletedWithSite = &now, true
+			}
+		}
+		return nil
+	})
+}
+
+// checkSite enforces the unique domain and the category reference.
+func (m *Memory) checkSite(id int64, categoryID *int64, domain string) error {
+	for _, s := range m.sites {
+		if s.Domain == domain && s.ID != id {
+			return errUnique("sites.domain")
+		}
+	}
+	if categoryID != nil && m.categories[*categoryID] == nil {
+		return errForeignKey
+	}
+	return nil
+}
+
+// siteRow fills in a site's category name and page count. For a site in
+// the trash it counts the pages that went into the trash along with it.
+func (m *Memory) siteRow(s *models.Site) models.Site {
+	row := *s
+	if s.CategoryID != nil {
+		if c, ok := m.categories[*s.CategoryID]; ok {
+			row.CategoryName = c.Name
+		}
+	}
+	for _, p := range m.pages {
+		if p.SiteID != s.ID {
+			continue
+		}
+		if s.DeletedAt == nil && p.DeletedAt == nil || s.DeletedAt != nil && p.deletedWithSite {
+			row.PageCount++
+		}
+	}
+	return row
+}
+
+// siteDetails is siteRow with the site's tags and category path.
+func (m *Memory) siteDetails(s *models.Site) models.Site {
+	row := m.siteRow(s)
+	row.Tags = m.tagList(m.siteTags[s.ID])
+	row.CategoryPath = m.categoryPath(s.CategoryID)
+	return row
+}
+
+// Pages
+
+func (m *Memory) GetPages(filter PageFilter) ([]models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getPages(filter), nil
+}
+
+func (m *Memory) getPages(filter PageFilter) []models.Page {
+	matches := m.pageMatcher(filter)
+	var pages []models.Page
+	for _, p := range byID(m.pages) {
+		if matches(p) {
+			pages = append(pages, m.pageDetails(p))
+		}
+	}
+	sortPages(pages, filter.Sort)
+	return paginate(pages, filter.Limit, filter.Offset)
+}
+
+func (m *Memory) CountPages(filter PageFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matches := m.pageMatcher(filter)
+	count := 0
+	for _, p := range m.pages {
+		if matches(p) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// pageMatcher returns a test for the pages selected by filter.
+func (m *Memory) pageMatcher(filter PageFilter) func(p *memoryPage) bool {
+	var categories map[int64]bool
+	if filter.CategoryID != nil {
+		categories = m.categoryFilter(*filter.CategoryID, filter.IncludeSubcategories)
+	}
+	tags := filter.Tags
+	hasTag := func(p *memoryPage) func(tagID int64) bool {
+		return func(tagID int64) bool { return m.hasTag(p, tags, tagID) }
+	}
+
+	return func(p *memoryPage) bool {
+		s := m.sites[p.SiteID]
+		switch {
+		case p.DeletedAt != nil,
+			filter.SiteID != nil && p.SiteID != *filter.SiteID,
+			categories != nil && (s.CategoryID == nil || !categories[*s.CategoryID]),
+			filter.Query != "" && !containsFold(filter.Query, append(m.pageText(p), s.Domain)...),
+			filter.ReadStatus != "" && p.ReadStatus != filter.ReadStatus,
+			filter.Favorites && !p.Favorite,
+			filter.Pinned && p.PinnedAt == nil,
+			p.Rating < filter.MinRating:
+			return false
+		}
+
+		for _, tagID := range tags.All {
+			if !hasTag(p)(tagID) {
+				return false
+			}
+		}
+		if len(tags.Any) > 0 && !slices.ContainsFunc(tags.Any, hasTag(p)) {
+			return false
+		}
+		return !slices.ContainsFunc(tags.None, hasTag(p))
+	}
+}
+
+// hasTag reports whether the page carries the tag, as TagFilter.hasTag.
+func (m *Memory) hasTag(p *memoryPage, f TagFilter, tagID int64) bool {
+	for id := range m.tagSet(tagID, f.IncludeSubtags) {
+		if m.pageTags[p.ID][id] || f.IncludeSiteTags && m.siteTags[p.SiteID][id] {
+			return true
+		}
+	}
+	return false
+}
+
+// tagSet returns the tag and, optionally, every tag nested below it by name.
+func (m *Memory) tagSet(tagID int64, includeDescendants bool) map[int64]bool {
+	if !includeDescendants {
+		return map[int64]bool{tagID: true}
+	}
+	set := map[int64]bool{}
+	name, ok := m.tags[tagID]
+	if !ok {
+		return set
+	}
+	parent := models.Tag{Name: name}
+	for id, name := range m.tags {
+		if parent.Includes(name) {
+			set[id] = true
+		}
+	}
+	return set
+}
+
+// pageText returns the text a search query is matched against: the page's
+// details, extracted text, note and highlights.
+func (m *Memory) pageText(p *memoryPage) []string {
+	text := []string{p.Title, p.Path, p.Description}
+	if c, ok := m.contents[p.ID]; ok {
+		text = append(text, c.Text)
+	}
+	if n := m.pageNote(p.ID); n != nil {
+		text = append(text, n.Body)
+	}
+	for _, h := range m.highlights {
+		if h.PageID == p.ID {
+			text = append(text, h.Text, h.Comment)
+		}
+	}
+	return text
+}
+
+// sortPages puts pages in the order PageFilter.orderBy gives.
+func sortPages(pages []models.Page, order string) {
+	newest := func(a, b *models.Page) bool {
+		return a.CreatedAt.After(b.CreatedAt) || a.CreatedAt.Equal(b.CreatedAt) && a.ID > b.ID
+	}
+	var less func(a, b *models.Page) bool
+	switch order {
+	case SortOldest:
+		less = func(a, b *models.Page) bool {
+			return a.CreatedAt.Before(b.CreatedAt) || a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID
+		}
+	case SortName:
+		name := func(p *models.Page) string {
+			if p.Title != "" {
+				return strings.ToLower(p.Title)
+			}
+			return strings.ToLower(p.SiteDomain + p.Path)
+		}
+		less = func(a, b *models.Page) bool { return name(a) < name(b) || name(a) == name(b) && a.ID < b.ID }
+	case SortDomain:
+		less = func(a, b *models.Page) bool {
+			if a.SiteDomain != b.SiteDomain {
+				return a.SiteDomain < b.SiteDomain
+			}
+			return a.Path < b.Path || a.Path == b.Path && a.ID < b.ID
+		}
+	case SortVisited:
+		less = func(a, b *models.Page) bool {
+			return newerFirst(a.VisitedAt, b.VisitedAt) || !newerFirst(b.VisitedAt, a.VisitedAt) && newest(a, b)
+		}
+	case SortRating:
+		less = func(a, b *models.Page) bool {
+			return a.Rating > b.Rating || a.Rating == b.Rating && newest(a, b)
+		}
+	case SortPinned:
+		less = func(a, b *models.Page) bool { return newerFirst(a.PinnedAt, b.PinnedAt) }
+	default:
+		less = newest
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return less(&pages[i], &pages[j]) })
+}
+
+func (m *Memory) GetPage(id int64) (*models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pages[id]
+	if !ok || p.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	page := m.pageDetails(p)
+	return &page, nil
+}
+
+// CreatePage adds a page. Adding a page that is in the trash restores it
+// with the new title and description.
+func (m *Memory) CreatePage(siteID int64, path, title, description string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.pages {
+		if p.SiteID == siteID && p.Path == path && p.DeletedAt != nil {
+			return p.ID, m.record(models.EntityPage, p.ID, models.ActionRestore, func() error {
+				p.Title, p.Description = title, description
+				p.DeletedAt, p.deletedWithSite = nil, false
+				return nil
+			})
+		}
+	}
+
+	return m.recordCreate(models.EntityPage, func() (int64, error) {
+		if err := m.checkPage(0, siteID, path); err != nil {
+			return 0, err
+		}
+		id := m.nextID("pages")
+		m.pages[id] = &memoryPage{Page: models.Page{ID: id, SiteID: siteID, Path: path, Title: title,
+			Description: description, CreatedAt: memoryNow()}}
+		return id, nil
+	})
+}
+
+func (m *Memory) UpdatePage(id int64, siteID int64, path, title, description string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionUpdate, func() error {
+		p, ok := m.pages[id]
+		if !ok {
+			return nil
+		}
+		if err := m.checkPage(id, siteID, path); err != nil {
+			return err
+		}
+		p.SiteID, p.Path, p.Title, p.Description = siteID, path, title, description
+		return nil
+	})
+}
+
+// DeletePage moves a page into the trash. It returns sql.ErrNoRows for a
+// page that does not exist or is in the trash already.
+func (m *Memory) DeletePage(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionDelete, func() error {
+		p, ok := m.pages[id]
+		if !ok || p.DeletedAt != nil {
+			return sql.ErrNoRows
+		}
+		now := memoryNow()
+		p.DeletedAt = &now
+		return nil
+	})
+}
+
+// checkPage enforces the site reference and the unique path within a site.
+func (m *Memory) checkPage(id, siteID int64, path string) error {
+	if m.sites[siteID] == nil {
+		return errForeignKey
+	}
+	for _, p := range m.pages {
+		if p.SiteID == siteID && p.Path == path && p.ID != id {
+			return errUnique("pages.site_id, pages.path")
+		}
+	}
+	return nil
+}
+
+// pageRow fills in the domain of a page's site.
+func (m *Memory) pageRow(p *memoryPage) models.Page {
+	row := p.Page
+	if s, ok := m.sites[p.SiteID]; ok {
+		row.SiteDomain = s.Domain
+	}
+	return row
+}
+
+// pageDetails is pageRow with the page's own tags and its site's.
+func (m *Memory) pageDetails(p *memoryPage) models.Page {
+	row := m.pageRow(p)
+	row.Tags = m.tagList(m.pageTags[p.ID])
+	row.SiteTags = m.tagList(m.siteTags[p.SiteID])
+	return row
+}
+
+// SetPageArchive records that a snapshot of size bytes was just stored for the page.
+func (m *Memory) SetPageArchive(id int64, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionUpdate, func() error {
+		if p, ok := m.pages[id]; ok {
+			now := memoryNow()
+			p.ArchivedAt, p.ArchiveSize = &now, size
+		}
+		return nil
+	})
+}
+
+func (m *Memory) GetPageContent(pageID int64) (*models.PageContent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.contents[pageID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &c, nil
+}
+
+// SetPageContent stores the extracted text of a page, replacing any earlier
+// extraction. Extracting the same text again changes nothing.
+func (m *Memory) SetPageContent(pageID int64, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, pageID, models.ActionUpdate, func() error {
+		if m.pages[pageID] == nil {
+			return errForeignKey
+		}
+		if c, ok := m.contents[pageID]; ok && c.Text == text {
+			return nil
+		}
+		m.contents[pageID] = models.PageContent{PageID: pageID, Text: text, ExtractedAt: memoryNow()}
+		return nil
+	})
+}
+
+// SetPageBroken flags or clears a page whose URL no longer resolves. The
+// original time is kept while the page stays broken.
+func (m *Memory) SetPageBroken(id int64, broken bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionUpdate, func() error {
+		p, ok := m.pages[id]
+		switch {
+		case !ok:
+		case !broken:
+			p.BrokenAt = nil
+		case p.BrokenAt == nil:
+			now := memoryNow()
+			p.BrokenAt = &now
+		}
+		return nil
+	})
+}
+
+// SetPageExternalArchive records where an external archive keeps a copy of the page.
+func (m *Memory) SetPageExternalArchive(id int64, archiveURL string, archivedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionUpdate, func() error {
+		if p, ok := m.pages[id]; ok {
+			p.ExternalArchiveURL, p.ExternalArchivedAt = archiveURL, nil
+			if !archivedAt.IsZero() {
+				at := archivedAt.UTC()
+				p.ExternalArchivedAt = &at
+			}
+		}
+		return nil
+	})
+}
+
+// Watched pages
+
+func (m *Memory) SetPageWatched(id int64, watched bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionUpdate, func() error {
+		if p, ok := m.pages[id]; ok {
+			p.Watched = watched
+		}
+		return nil
+	})
+}
+
+func (m *Memory) GetWatchedPages() ([]models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pages []models.Page
+	for _, p := range byID(m.pages) {
+		if p.Watched && p.DeletedAt == nil {
+			pages = append(pages, m.pageRow(p))
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return olderFirst(pages[i].CheckedAt, pages[j].CheckedAt) })
+	return pages, nil
+}
+
+// GetPageVersions returns the recorded versions of a page, newest first.
+func (m *Memory) GetPageVersions(pageID int64) ([]models.PageVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var versions []models.PageVersion
+	for i := len(m.versions) - 1; i >= 0; i-- {
+		if m.versions[i].PageID == pageID {
+			versions = append(versions, m.versions[i])
+		}
+	}
+	return versions, nil
+}
+
+func (m *Memory) GetLatestPageVersion(pageID int64) (*models.PageVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.versions) - 1; i >= 0; i-- {
+		if m.versions[i].PageID == pageID {
+			v := m.versions[i]
+			return &v, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// AddPageVersion records a new version of a page's text. A version with a
+// diff marks the page as changed; the first version is only a baseline.
+func (m *Memory) AddPageVersion(pageID int64, text, hash, diff string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, pageID, models.ActionUpdate, func() error {
+		p, ok := m.pages[pageID]
+		if !ok {
+			return errForeignKey
+		}
+		now := memoryNow()
+		m.versions = append(m.versions, models.PageVersion{ID: m.nextID("page_versions"), PageID: pageID,
+			Text: text, Hash: hash, Diff: diff, FetchedAt: now})
+		p.CheckedAt = &now
+		if diff != "" {
+			p.ChangedAt = &now
+		}
+		return nil
+	})
+}
+
+// MarkPageChecked records that a watched page was fetched and found
+// unchanged. Checks happen on every watcher run, so they are not recorded
+// in the activity log, where they would bury the changes.
+func (m *Memory) MarkPageChecked(pageID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p, ok := m.pages[pageID]; ok {
+		now := memoryNow()
+		p.CheckedAt = &now
+	}
+	return nil
+}
+
+// MarkPageVisited records that the page was opened. Like checks, visits are
+// not recorded in the activity log.
+func (m *Memory) MarkPageVisited(pageID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p, ok := m.pages[pageID]; ok {
+		now := memoryNow()
+		p.VisitedAt = &now
+	}
+	return nil
+}
+
+// ClearPageChanged acknowledges the latest change so the page stops being flagged.
+func (m *Memory) ClearPageChanged(pageID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, pageID, models.ActionUpdate, func() error {
+		if p, ok := m.pages[pageID]; ok {
+			p.ChangedAt = nil
+		}
+		return nil
+	})
+}
+
+// Read later
+
+func inQueue(status string) bool {
+	return status == models.StatusUnread || status == models.StatusReading
+}
+
+// SetPageReadStatus moves a page to a read-later state and records the
+// transition. An empty status takes the page out of the queue. Pages
+// re-entering the queue go to its back.
+func (m *Memory) SetPageReadStatus(pageID int64, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, pageID, models.ActionUpdate, func() error {
+		if status != "" && !slices.Contains(models.ReadStatuses, status) {
+			return ErrInvalidReadStatus
+		}
+		p, ok := m.pages[pageID]
+		if !ok || p.ReadStatus == status {
+			// Unknown page, or already in that state
+			return nil
+		}
+
+		now := memoryNow()
+		switch {
+		case status == "":
+			p.QueuedAt = nil
+		case inQueue(status) && !inQueue(p.ReadStatus):
+			p.QueuedAt = &now
+		}
+		p.ReadStatus, p.ReadStatusAt = status, nil
+		if status != "" {
+			p.ReadStatusAt = &now
+		}
+
+		m.readEvents = append(m.readEvents, memoryReadEvent{id: m.nextID("page_read_events"),
+			ReadEvent: models.ReadEvent{PageID: pageID, Status: status, ChangedAt: now}})
+		return nil
+	})
+}
+
+// GetReadingQueue lists unread and in-progress pages, oldest queued first.
+// A limit of zero returns the whole queue.
+func (m *Memory) GetReadingQueue(limit int) ([]models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.readingQueue(limit), nil
+}
+
+func (m *Memory) readingQueue(limit int) []models.Page {
+	var pages []models.Page
+	for _, p := range byID(m.pages) {
+		if inQueue(p.ReadStatus) && p.DeletedAt == nil {
+			pages = append(pages, m.pageDetails(p))
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return olderFirst(pages[i].QueuedAt, pages[j].QueuedAt) })
+	if limit > 0 && len(pages) > limit {
+		pages = pages[:limit]
+	}
+	return pages
+}
+
+// GetPageReadEvents returns a page's read-later history, most recent first.
+func (m *Memory) GetPageReadEvents(pageID int64) ([]models.ReadEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []models.ReadEvent
+	for i := len(m.readEvents) - 1; i >= 0; i-- {
+		if m.readEvents[i].PageID == pageID {
+			events = append(events, m.readEvents[i].ReadEvent)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].ChangedAt.After(events[j].ChangedAt) })
+	return events, nil
+}
+
+// Trash
+
+// GetTrashedSites lists sites in the trash, most recently deleted first.
+// Their PageCount is the number of pages deleted along with them.
+func (m *Memory) GetTrashedSites() ([]models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sites []models.Site
+	for _, s := range byID(m.sites) {
+		if s.DeletedAt != nil {
+			sites = append(sites, m.siteDetails(s))
+		}
+	}
+	sort.SliceStable(sites, func(i, j int) bool {
+		a, b := sites[i].DeletedAt, sites[j].DeletedAt
+		return a.After(*b) || a.Equal(*b) && sites[i].ID > sites[j].ID
+	})
+	return sites, nil
+}
+
+// GetTrashedPages lists pages deleted on their own, most recently deleted
+// first. Pages deleted along with their site are listed under the site.
+func (m *Memory) GetTrashedPages() ([]models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pages []models.Page
+	for _, p := range byID(m.pages) {
+		if p.DeletedAt != nil && !p.deletedWithSite {
+			pages = append(pages, m.pageDetails(p))
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		a, b := pages[i].DeletedAt, pages[j].DeletedAt
+		return a.After(*b) || a.Equal(*b) && pages[i].ID > pages[j].ID
+	})
+	return pages, nil
+}
+
+// RestoreSite takes a site and the pages deleted along with it out of the
+// trash.
+func (m *Memory) RestoreSite(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySite, id, models.ActionRestore, func() error {
+		return m.untrashSite(id, true)
+	})
+}
+
+// untrashSite clears a site's deletion. Without withPages, the pages that
+// went into the trash with the site stay there as individually deleted pages.
+func (m *Memory) untrashSite(id int64, withPages bool) error {
+	s, ok := m.sites[id]
+	if !ok || s.DeletedAt == nil {
+		return sql.ErrNoRows
+	}
+	s.DeletedAt = nil
+	for _, p := range m.pages {
+		if p.SiteID == id && p.deletedWithSite {
+			p.deletedWithSite = false
+			if withPages {
+				p.DeletedAt = nil
+			}
+		}
+	}
+	return nil
+}
+
+// RestorePage takes a page out of the trash. If its site is in the trash
+// too, the site comes back without its other pages, logged as restored in
+// its own right.
+func (m *Memory) RestorePage(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionRestore, func() error {
+		p, ok := m.pages[id]
+		if !ok || p.DeletedAt == nil {
+			return sql.ErrNoRows
+		}
+		if m.sites[p.SiteID].DeletedAt != nil {
+			err := m.record(models.EntitySite, p.SiteID, models.ActionRestore, func() error {
+				return m.untrashSite(p.SiteID, false)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		p.DeletedAt, p.deletedWithSite = nil, false
+		return nil
+	})
+}
+
+// PurgeSite permanently deletes a site in the trash with all of its pages,
+// returning the IDs of the pages removed.
+func (m *Memory) PurgeSite(id int64) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged, pageIDs := m.purgeSites(func(s *models.Site) bool { return s.ID == id && s.DeletedAt != nil })
+	if len(purged) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return pageIDs, nil
+}
+
+// PurgePage permanently deletes a page in the trash.
+func (m *Memory) PurgePage(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if purged := m.purgePages(func(p *memoryPage) bool { return p.ID == id && p.DeletedAt != nil }); len(purged) == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeTrash permanently deletes everything that went into the trash before
+// cutoff, returning the IDs of the pages removed.
+func (m *Memory) PurgeTrash(cutoff time.Time) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.purgeTrash(cutoff), nil
+}
+
+// EmptyTrash permanently deletes everything in the trash, returning the IDs
+// of the pages removed.
+func (m *Memory) EmptyTrash() ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.purgeTrash(time.Now().Add(time.Second)), nil
+}
+
+func (m *Memory) purgeTrash(cutoff time.Time) []int64 {
+	// Compared at the precision deleted_at is stored with
+	before := cutoff.UTC().Truncate(time.Second)
+	expired := func(deletedAt *time.Time) bool { return deletedAt != nil && deletedAt.Before(before) }
+
+	_, ids := m.purgeSites(func(s *models.Site) bool { return expired(s.DeletedAt) })
+	pageIDs := m.purgePages(func(p *memoryPage) bool { return expired(p.DeletedAt) })
+	return append(ids, pageIDs...)
+}
+
+// purgeSites deletes the sites matching purge with their pages and logs
+// each of them, pages included. It returns their IDs along with those of the
+// pages removed.
+func (m *Memory) purgeSites(purge func(s *models.Site) bool) (purged, pageIDs []int64) {
+	for _, s := range byID(m.sites) {
+		if !purge(s) {
+			continue
+		}
+		before := m.snapshot(models.EntitySite, s.ID)
+		for _, p := range byID(m.pages) {
+			if p.SiteID == s.ID {
+				page := m.snapshot(models.EntityPage, p.ID)
+				m.removePage(p.ID)
+				m.logEvent(models.EntityPage, p.ID, models.ActionPurge, page, nil)
+				pageIDs = append(pageIDs, p.ID)
+			}
+		}
+		m.removeSite(s.ID)
+		m.logEvent(models.EntitySite, s.ID, models.ActionPurge, before, nil)
+		purged = append(purged, s.ID)
+	}
+	return purged, pageIDs
+}
+
+// purgePages deletes the pages matching purge and logs each of them,
+// returning their IDs.
+func (m *Memory) purgePages(purge func(p *memoryPage) bool) (purged []int64) {
+	for _, p := range byID(m.pages) {
+		if !purge(p) {
+			continue
+		}
+		before := m.snapshot(models.EntityPage, p.ID)
+		m.removePage(p.ID)
+		m.logEvent(models.EntityPage, p.ID, models.ActionPurge, before, nil)
+		purged = append(purged, p.ID)
+	}
+	return purged
+}
+
+// removeSite and removePage delete a row along with everything that
+// references it, as ON DELETE CASCADE does. removeSite expects the site's
+// pages to be gone already.
+func (m *Memory) removeSite(id int64) {
+	delete(m.sites, id)
+	delete(m.siteTags, id)
+	for _, n := range byID(m.notes) {
+		if n.SiteID != nil && *n.SiteID == id {
+			m.removeNote(n.ID)
+		}
+	}
+	for itemID, it := range m.items {
+		if it.siteID != nil && *it.siteID == id {
+			delete(m.items, itemID)
+		}
+	}
+}
+
+func (m *Memory) removePage(id int64) {
+	delete(m.pages, id)
+	delete(m.pageTags, id)
+	delete(m.contents, id)
+	m.versions = slices.DeleteFunc(m.versions, func(v models.PageVersion) bool { return v.PageID == id })
+	m.readEvents = slices.DeleteFunc(m.readEvents, func(e memoryReadEvent) bool { return e.PageID == id })
+	for _, n := range byID(m.notes) {
+		if n.PageID != nil && *n.PageID == id {
+			m.removeNote(n.ID)
+		}
+	}
+	for highlightID, h := range m.highlights {
+		if h.PageID == id {
+			delete(m.highlights, highlightID)
+		}
+	}
+	for itemID, it := range m.items {
+		if it.pageID != nil && *it.pageID == id {
+			delete(m.items, itemID)
+		}
+	}
+}
+
+func (m *Memory) removeNote(id int64) {
+	delete(m.notes, id)
+	m.revisions = slices.DeleteFunc(m.revisions, func(r models.NoteRevision) bool { return r.NoteID == id })
+}
+
+// Favorites, pins and ratings
+
+func (m *Memory) SetPageFavorite(id int64, favorite bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionUpdate, func() error {
+		if p, ok := m.pages[id]; ok {
+			p.Favorite = favorite
+		}
+		return nil
+	})
+}
+
+func (m *Memory) SetSiteFavorite(id int64, favorite bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySite, id, models.ActionUpdate, func() error {
+		if s, ok := m.sites[id]; ok {
+			s.Favorite = favorite
+		}
+		return nil
+	})
+}
+
+// SetPagePinned pins a page to the dashboard or unpins it. Pinning an
+// already pinned page keeps its place.
+func (m *Memory) SetPagePinned(id int64, pinned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionUpdate, func() error {
+		if p, ok := m.pages[id]; ok {
+			p.PinnedAt = pinTime(p.PinnedAt, pinned)
+		}
+		return nil
+	})
+}
+
+func (m *Memory) SetSitePinned(id int64, pinned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySite, id, models.ActionUpdate, func() error {
+		if s, ok := m.sites[id]; ok {
+			s.PinnedAt = pinTime(s.PinnedAt, pinned)
+		}
+		return nil
+	})
+}
+
+// pinTime keeps the time something was first pinned, or clears it.
+func pinTime(pinnedAt *time.Time, pinned bool) *time.Time {
+	if !pinned {
+		return nil
+	}
+	if pinnedAt == nil {
+		now := memoryNow()
+		pinnedAt = &now
+	}
+	return pinnedAt
+}
+
+// SetPageRating gives a page 1 to 5 stars; zero clears the rating.
+func (m *Memory) SetPageRating(id int64, rating int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, id, models.ActionUpdate, func() error {
+		if rating < 0 || rating > models.MaxRating {
+			return ErrInvalidRating
+		}
+		if p, ok := m.pages[id]; ok {
+			p.Rating = rating
+		}
+		return nil
+	})
+}
+
+func (m *Memory) SetSiteRating(id int64, rating int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySite, id, models.ActionUpdate, func() error {
+		if rating < 0 || rating > models.MaxRating {
+			return ErrInvalidRating
+		}
+		if s, ok := m.sites[id]; ok {
+			s.Rating = rating
+		}
+		return nil
+	})
+}
+
+// Tags
+
+func (m *Memory) GetTags() ([]models.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tags []models.Tag
+	for id := range m.tags {
+		tags = append(tags, m.tagRow(id))
+	}
+	sortTagTree(tags)
+	return tags, nil
+}
+
+func (m *Memory) ListTags(filter TagListFilter) ([]models.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tags []models.Tag
+	for id := range m.tags {
+		tags = append(tags, m.tagRow(id))
+	}
+	if filter.Sort == SortPageCount {
+		sort.Slice(tags, func(i, j int) bool {
+			a, b := tags[i], tags[j]
+			return a.PageCount > b.PageCount || a.PageCount == b.PageCount && a.Name < b.Name
+		})
+	} else {
+		sortTagTree(tags)
+	}
+	return paginate(tags, filter.Limit, filter.Offset), nil
+}
+
+func (m *Memory) CountTags() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.tags), nil
+}
+
+// HasSubtags reports whether any tag sits below the tag with id.
+func (m *Memory) HasSubtags(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, ok := m.tags[id]
+	if !ok {
+		return false, nil
+	}
+	for _, tagName := range m.tags {
+		if strings.HasPrefix(tagName, name+models.TagSeparator) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) GetTag(id int64) (*models.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tags[id]; !ok {
+		return nil, sql.ErrNoRows
+	}
+	t := m.tagRow(id)
+	return &t, nil
+}
+
+// tagRow fills in a tag's aliases and how many sites and pages outside the
+// trash carry it.
+func (m *Memory) tagRow(id int64) models.Tag {
+	t := models.Tag{ID: id, Name: m.tags[id], Aliases: m.tagAliases(id)}
+	for siteID, tags := range m.siteTags {
+		if tags[id] && m.sites[siteID].DeletedAt == nil {
+			t.SiteCount++
+		}
+	}
+	for pageID, tags := range m.pageTags {
+		if tags[id] && m.pages[pageID].DeletedAt == nil {
+			t.PageCount++
+		}
+	}
+	return t
+}
+
+// tagList returns the tags in a set of tag IDs, sorted by name.
+func (m *Memory) tagList(tagIDs map[int64]bool) []models.Tag {
+	var tags []models.Tag
+	for id := range tagIDs {
+		tags = append(tags, models.Tag{ID: id, Name: m.tags[id]})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags
+}
+
+// GetOrCreateTag resolves a typed tag name, or one of its aliases, to a tag
+// ID, creating the tag if the name is new.
+func (m *Memory) GetOrCreateTag(name string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = normalizeTag(name)
+	if name == "" {
+		return 0, ErrTagNameEmpty
+	}
+	if id, ok := m.resolveTag(name); ok {
+		return id, nil
+	}
+	name = m.unaliasParent(name)
+	if id, ok := m.resolveTag(name); ok {
+		return id, nil
+	}
+	return m.insertTag(name)
+}
+
+func (m *Memory) CreateTag(name string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = normalizeTag(name)
+	if name == "" {
+		return 0, ErrTagNameEmpty
+	}
+	name = m.unaliasParent(name)
+	if m.tagNameTaken(name, 0) {
+		return 0, ErrTagExists
+	}
+	return m.insertTag(name)
+}
+
+// LookupTag resolves a typed tag name, or one of its aliases, to the ID of
+// an existing tag. It returns sql.ErrNoRows when no tag goes by that name.
+func (m *Memory) LookupTag(name string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id, ok := m.resolveTag(normalizeTag(name)); ok {
+		return id, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+// resolveTag finds the tag with name as its name or one of its aliases.
+func (m *Memory) resolveTag(name string) (int64, bool) {
+	for id, tagName := range m.tags {
+		if tagName == name {
+			return id, true
+		}
+	}
+	id, ok := m.aliases[name]
+	return id, ok
+}
+
+// unaliasParent puts a new tag below the tag its closest ancestor is an
+// alias of, so that "lang/python" becomes "docs/python" once "lang" has
+// been merged into "docs".
+func (m *Memory) unaliasParent(name string) string {
+	for i := strings.LastIndex(name, models.TagSeparator); i > 0; i = strings.LastIndex(name[:i], models.TagSeparator) {
+		if m.tagNameTaken(name[:i], 0) {
+			if id, ok := m.aliases[name[:i]]; ok {
+				return m.tags[id] + name[i:]
+			}
+			return name
+		}
+	}
+	return name
+}
+
+// insertTag adds a tag along with any missing ancestors, so that "lang/go"
+// always has a "lang" to be filtered by.
+func (m *Memory) insertTag(name string) (int64, error) {
+	return m.recordCreate(models.EntityTag, func() (int64, error) {
+		m.createTagAncestors(name)
+		id := m.nextID("tags")
+		m.tags[id] = name
+		return id, nil
+	})
+}
+
+// createTagAncestors inserts each parent of name that is not already a tag
+// or an alias.
+func (m *Memory) createTagAncestors(name string) {
+	for i := strings.LastIndex(name, models.TagSeparator); i > 0; i = strings.LastIndex(name, models.TagSeparator) {
+		name = name[:i]
+		if _, ok := m.resolveTag(name); !ok {
+			m.tags[m.nextID("tags")] = name
+		}
+	}
+}
+
+// DeleteTag removes a tag from every site and page and deletes it,
+// returning what RestoreTag needs to bring it back.
+func (m *Memory) DeleteTag(id int64) (*models.DeletedTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := &models.DeletedTag{ID: id}
+	err := m.record(models.EntityTag, id, models.ActionDelete, func() error {
+		name, ok := m.tags[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		deleted.Name = name
+		deleted.Aliases = m.tagAliases(id)
+		deleted.SiteIDs = taggedWith(m.siteTags, id)
+		deleted.PageIDs = taggedWith(m.pageTags, id)
+		m.removeTag(id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// taggedWith returns the IDs of the sites or pages linked to a tag.
+func taggedWith(links map[int64]map[int64]bool, tagID int64) []int64 {
+	var ids []int64
+	for ownerID, tags := range links {
+		if tags[tagID] {
+			ids = append(ids, ownerID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// removeTag deletes a tag with its aliases and links.
+func (m *Memory) removeTag(id int64) {
+	delete(m.tags, id)
+	for alias, tagID := range m.aliases {
+		if tagID == id {
+			delete(m.aliases, alias)
+		}
+	}
+	for _, links := range []map[int64]map[int64]bool{m.siteTags, m.pageTags} {
+		for _, tags := range links {
+			delete(tags, id)
+		}
+	}
+}
+
+// RestoreTag recreates a deleted tag, with its aliases and its links to the
+// sites and pages that still exist. The tag gets a new ID, as it would in
+// SQLite. It fails with ErrTagExists if the name has been taken since.
+func (m *Memory) RestoreTag(deleted *models.DeletedTag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.recordInsert(models.EntityTag, models.ActionRestore, func() (int64, error) {
+		if m.tagNameTaken(deleted.Name, 0) {
+			return 0, ErrTagExists
+		}
+		id := m.nextID("tags")
+		m.tags[id] = deleted.Name
+		for _, alias := range deleted.Aliases {
+			if _, ok := m.aliases[alias]; !ok {
+				m.aliases[alias] = id
+			}
+		}
+		for _, siteID := range deleted.SiteIDs {
+			if _, ok := m.sites[siteID]; ok {
+				link(m.siteTags, siteID, id)
+			}
+		}
+		for _, pageID := range deleted.PageIDs {
+			if _, ok := m.pages[pageID]; ok {
+				link(m.pageTags, pageID, id)
+			}
+		}
+		return id, nil
+	})
+	return err
+}
+
+// RenameTag changes a tag's name. The old name is kept as an alias so that
+// typing it still finds the tag. Child tags move along with their parent.
+func (m *Memory) RenameTag(id int64, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityTag, id, models.ActionUpdate, func() error {
+		name = normalizeTag(name)
+		if name == "" {
+			return ErrTagNameEmpty
+		}
+		oldName, ok := m.tags[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		if name == oldName {
+			return nil
+		}
+		if strings.HasPrefix(name, oldName+models.TagSeparator) {
+			return ErrTagCycle
+		}
+
+		renames := m.childTagRenames(oldName, name)
+		renames[id] = [2]string{oldName, name}
+		if err := m.renameTags(renames); err != nil {
+			return err
+		}
+		m.createTagAncestors(name)
+		return nil
+	})
+}
+
+// childTagRenames returns the old and new names, by tag ID, of the tags
+// below oldName when it is renamed to name.
+func (m *Memory) childTagRenames(oldName, name string) map[int64][2]string {
+	renames := map[int64][2]string{}
+	for childID, childName := range m.tags {
+		if strings.HasPrefix(childName, oldName+models.TagSeparator) {
+			renames[childID] = [2]string{childName, name + strings.TrimPrefix(childName, oldName)}
+		}
+	}
+	return renames
+}
+
+// renameTags gives each tag its new name, keeping the old one as an alias.
+// It fails with ErrTagExists, renaming nothing, if a new name is taken.
+func (m *Memory) renameTags(renames map[int64][2]string) error {
+	for tagID, names := range renames {
+		if m.tagNameTaken(names[1], tagID) {
+			return ErrTagExists
+		}
+	}
+
+	for tagID, names := range renames {
+		// Renaming to one of the tag's own aliases promotes it
+		delete(m.aliases, names[1])
+		m.tags[tagID] = names[1]
+		m.aliases[names[0]] = tagID
+	}
+	return nil
+}
+
+// MergeTags moves every site and page tagged with sourceID over to targetID,
+// then deletes the source tag. Its name and aliases become aliases of the
+// target. Items that already carry both tags keep a single link. Tags below
+// the source move below the target, and one whose name is taken there is
+// merged in turn into the tag that has it.
+func (m *Memory) MergeTags(sourceID, targetID int64) error {
+	if sourceID == targetID {
+		return errors.New("cannot merge a tag into itself")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Logged as the source going away and the target gaining its aliases
+	return m.record(models.EntityTag, targetID, models.ActionUpdate, func() error {
+		return m.record(models.EntityTag, sourceID, models.ActionMerge, func() error {
+			return m.mergeTags(sourceID, targetID)
+		})
+	})
+}
+
+func (m *Memory) mergeTags(sourceID, targetID int64) error {
+	sourceName, ok := m.tags[sourceID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	targetName, ok := m.tags[targetID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if strings.HasPrefix(targetName, sourceName+models.TagSeparator) {
+		return ErrTagCycle
+	}
+	if err := m.moveChildTags(sourceName, targetName); err != nil {
+		return err
+	}
+
+	for _, links := range []map[int64]map[int64]bool{m.siteTags, m.pageTags} {
+		for _, tags := range links {
+			if tags[sourceID] {
+				tags[targetID] = true
+			}
+		}
+	}
+	for alias, tagID := range m.aliases {
+		if tagID == sourceID {
+			m.aliases[alias] = targetID
+		}
+	}
+	m.removeTag(sourceID)
+	m.aliases[sourceName] = targetID
+	return nil
+}
+
+// moveChildTags moves the tags below sourceName to below targetName. A
+// child whose new name is already taken is merged into the tag that has it,
+// which takes care of the child's own children.
+func (m *Memory) moveChildTags(sourceName, targetName string) error {
+	renames := m.childTagRenames(sourceName, targetName)
+	for _, childID := range byOldName(renames) {
+		names := renames[childID]
+		if name, ok := m.tags[childID]; !ok || name != names[0] {
+			// Already moved or merged along with its parent
+			continue
+		}
+		existingID, ok := m.resolveTag(names[1])
+		if !ok || existingID == childID {
+			if err := m.renameTags(map[int64][2]string{childID: names}); err != nil {
+				return err
+			}
+			continue
+		}
+		err := m.record(models.EntityTag, existingID, models.ActionUpdate, func() error {
+			return m.record(models.EntityTag, childID, models.ActionMerge, func() error {
+				return m.mergeTags(childID, existingID)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetTagAliases(tagID int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tagAliases(tagID), nil
+}
+
+func (m *Memory) tagAliases(tagID int64) []string {
+	var aliases []string
+	for alias, id := range m.aliases {
+		if id == tagID {
+			aliases = append(aliases, alias)
+		}
+	}
+	slices.Sort(aliases)
+	return aliases
+}
+
+func (m *Memory) AddTagAlias(tagID int64, alias string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityTag, tagID, models.ActionUpdate, func() error {
+		alias = normalizeTag(alias)
+		if alias == "" {
+			return ErrTagNameEmpty
+		}
+		if m.tagNameTaken(alias, 0) {
+			return ErrTagExists
+		}
+		if _, ok := m.tags[tagID]; !ok {
+			return errForeignKey
+		}
+		m.aliases[alias] = tagID
+		return nil
+	})
+}
+
+func (m *Memory) DeleteTagAlias(tagID int64, alias string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityTag, tagID, models.ActionUpdate, func() error {
+		if id, ok := m.aliases[alias]; ok && id == tagID {
+			delete(m.aliases, alias)
+		}
+		return nil
+	})
+}
+
+// tagNameTaken reports whether name is used by a tag other than exceptID or
+// by an alias of one.
+func (m *Memory) tagNameTaken(name string, exceptID int64) bool {
+	for id, tagName := range m.tags {
+		if tagName == name && id != exceptID {
+			return true
+		}
+	}
+	id, ok := m.aliases[name]
+	return ok && id != exceptID
+}
+
+func (m *Memory) GetSiteTags(siteID int64) ([]models.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tagList(m.siteTags[siteID]), nil
+}
+
+func (m *Memory) GetPageTags(pageID int64) ([]models.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tagList(m.pageTags[pageID]), nil
+}
+
+func (m *Memory) SetSiteTags(siteID int64, tagIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySite, siteID, models.ActionUpdate, func() error {
+		return m.setTags(m.siteTags, m.sites[siteID] != nil, siteID, tagIDs, "site_tags.site_id, site_tags.tag_id")
+	})
+}
+
+func (m *Memory) SetPageTags(pageID int64, tagIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, pageID, models.ActionUpdate, func() error {
+		return m.setTags(m.pageTags, m.pages[pageID] != nil, pageID, tagIDs, "page_tags.page_id, page_tags.tag_id")
+	})
+}
+
+// setTags replaces the tags linked to a site or page, checking the links
+// the way the site_tags and page_tags keys do.
+func (m *Memory) setTags(links map[int64]map[int64]bool, ownerExists bool, ownerID int64, tagIDs []int64, key string) error {
+	tags := map[int64]bool{}
+	for _, tagID := range tagIDs {
+		if _, ok := m.tags[tagID]; !ok || !ownerExists {
+			return errForeignKey
+		}
+		if tags[tagID] {
+			return errUnique(key)
+		}
+		tags[tagID] = true
+	}
+	delete(links, ownerID)
+	for tagID := range tags {
+		link(links, ownerID, tagID)
+	}
+	return nil
+}
+
+func (m *Memory) AddSiteTag(siteID, tagID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySite, siteID, models.ActionUpdate, func() error {
+		if _, ok := m.tags[tagID]; !ok || m.sites[siteID] == nil {
+			return errForeignKey
+		}
+		link(m.siteTags, siteID, tagID)
+		return nil
+	})
+}
+
+func (m *Memory) RemoveSiteTag(siteID, tagID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySite, siteID, models.ActionUpdate, func() error {
+		delete(m.siteTags[siteID], tagID)
+		return nil
+	})
+}
+
+func (m *Memory) AddPageTag(pageID, tagID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, pageID, models.ActionUpdate, func() error {
+		if _, ok := m.tags[tagID]; !ok || m.pages[pageID] == nil {
+			return errForeignKey
+		}
+		link(m.pageTags, pageID, tagID)
+		return nil
+	})
+}
+
+func (m *Memory) RemovePageTag(pageID, tagID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityPage, pageID, models.ActionUpdate, func() error {
+		delete(m.pageTags[pageID], tagID)
+		return nil
+	})
+}
+
+// Saved searches
+
+func (m *Memory) GetSavedSearches() ([]models.SavedSearch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var searches []models.SavedSearch
+	for _, ss := range byID(m.savedSearches) {
+		searches = append(searches, *ss)
+	}
+	sort.SliceStable(searches, func(i, j int) bool { return searches[i].Name < searches[j].Name })
+	return searches, nil
+}
+
+func (m *Memory) GetSavedSearch(id int64) (*models.SavedSearch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, ok := m.savedSearches[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	search := *ss
+	return &search, nil
+}
+
+func (m *Memory) CreateSavedSearch(name, query string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recordCreate(models.EntitySavedSearch, func() (int64, error) {
+		id := m.nextID("saved_searches")
+		m.savedSearches[id] = &models.SavedSearch{ID: id, Name: name, Query: query, CreatedAt: memoryNow()}
+		return id, nil
+	})
+}
+
+func (m *Memory) DeleteSavedSearch(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntitySavedSearch, id, models.ActionDelete, func() error {
+		delete(m.savedSearches, id)
+		return nil
+	})
+}
+
+// Notes
+
+// GetPageNote returns the note on a page, or sql.ErrNoRows if it has none.
+func (m *Memory) GetPageNote(pageID int64) (*models.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyNote(m.pageNote(pageID))
+}
+
+// GetSiteNote returns the note on a site, or sql.ErrNoRows if it has none.
+func (m *Memory) GetSiteNote(siteID int64) (*models.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyNote(m.siteNote(siteID))
+}
+
+func copyNote(n *models.Note) (*models.Note, error) {
+	if n == nil {
+		return nil, sql.ErrNoRows
+	}
+	note := *n
+	return &note, nil
+}
+
+func (m *Memory) pageNote(pageID int64) *models.Note {
+	for _, n := range m.notes {
+		if n.PageID != nil && *n.PageID == pageID {
+			return n
+		}
+	}
+	return nil
+}
+
+func (m *Memory) siteNote(siteID int64) *models.Note {
+	for _, n := range m.notes {
+		if n.SiteID != nil && *n.SiteID == siteID {
+			return n
+		}
+	}
+	return nil
+}
+
+// GetNotes returns every note, for exports.
+func (m *Memory) GetNotes() ([]models.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var notes []models.Note
+	for _, n := range byID(m.notes) {
+		notes = append(notes, *n)
+	}
+	return notes, nil
+}
+
+func (m *Memory) SavePageNote(pageID int64, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.savePageNote(pageID, body)
+}
+
+func (m *Memory) SaveSiteNote(siteID int64, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveSiteNote(siteID, body)
+}
+
+func (m *Memory) savePageNote(pageID int64, body string) error {
+	return m.record(models.EntityPage, pageID, models.ActionUpdate, func() error {
+		note := m.pageNote(pageID)
+		if note == nil && m.pages[pageID] == nil {
+			return errForeignKey
+		}
+		m.saveNote(note, models.Note{PageID: &pageID}, body)
+		return nil
+	})
+}
+
+func (m *Memory) saveSiteNote(siteID int64, body string) error {
+	return m.record(models.EntitySite, siteID, models.ActionUpdate, func() error {
+		note := m.siteNote(siteID)
+		if note == nil && m.sites[siteID] == nil {
+			return errForeignKey
+		}
+		m.saveNote(note, models.Note{SiteID: &siteID}, body)
+		return nil
+	})
+}
+
+// saveNote writes body to note, or to a new note for owner when note is
+// nil, and keeps the new body as a revision. Saving an unchanged body does
+// nothing.
+func (m *Memory) saveNote(note *models.Note, owner models.Note, body string) {
+	switch {
+	case note == nil:
+		owner.ID = m.nextID("notes")
+		note = &owner
+		m.notes[note.ID] = note
+	case note.Body == body:
+		return
+	}
+
+	now := memoryNow()
+	note.Body, note.UpdatedAt = body, now
+	m.revisions = append(m.revisions, models.NoteRevision{ID: m.nextID("note_revisions"), NoteID: note.ID,
+		Body: body, CreatedAt: now})
+}
+
+// GetNoteRevisions lists a note's saved versions, newest first.
+func (m *Memory) GetNoteRevisions(noteID int64) ([]models.NoteRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var revisions []models.NoteRevision
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		if m.revisions[i].NoteID == noteID {
+			revisions = append(revisions, m.revisions[i])
+		}
+	}
+	sort.SliceStable(revisions, func(i, j int) bool { return revisions[i].CreatedAt.After(revisions[j].CreatedAt) })
+	return revisions, nil
+}
+
+// RestoreNoteRevision saves an earlier version of a note as its current
+// body and returns the note.
+func (m *Memory) RestoreNoteRevision(revisionID int64) (*models.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.revisions, func(r models.NoteRevision) bool { return r.ID == revisionID })
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	body := m.revisions[i].Body
+	note, err := copyNote(m.notes[m.revisions[i].NoteID])
+	if err != nil {
+		return nil, err
+	}
+
+	if note.PageID != nil {
+		err = m.savePageNote(*note.PageID, body)
+	} else {
+		err = m.saveSiteNote(*note.SiteID, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	note.Body = body
+	return note, nil
+}
+
+// Highlights
+
+// GetHighlights lists highlights across all pages, newest first. A query
+// matches the passage, the comment or the page title.
+func (m *Memory) GetHighlights(query string) ([]models.Highlight, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var highlights []models.Highlight
+	for _, h := range byID(m.highlights) {
+		if m.pages[h.PageID].DeletedAt != nil {
+			continue
+		}
+		row := m.highlightRow(h)
+		if query != "" && !containsFold(query, row.Text, row.Comment, row.PageTitle) {
+			continue
+		}
+		highlights = append(highlights, row)
+	}
+	sort.SliceStable(highlights, func(i, j int) bool {
+		a, b := highlights[i], highlights[j]
+		return a.CreatedAt.After(b.CreatedAt) || a.CreatedAt.Equal(b.CreatedAt) && a.ID > b.ID
+	})
+	return highlights, nil
+}
+
+// GetPageHighlights lists a page's highlights in the order they were made.
+func (m *Memory) GetPageHighlights(pageID int64) ([]models.Highlight, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var highlights []models.Highlight
+	for _, h := range byID(m.highlights) {
+		if h.PageID == pageID {
+			highlights = append(highlights, m.highlightRow(h))
+		}
+	}
+	sort.SliceStable(highlights, func(i, j int) bool { return highlights[i].CreatedAt.Before(highlights[j].CreatedAt) })
+	return highlights, nil
+}
+
+func (m *Memory) GetHighlight(id int64) (*models.Highlight, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.highlights[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	row := m.highlightRow(h)
+	return &row, nil
+}
+
+func (m *Memory) CreateHighlight(pageID int64, text, comment, source string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recordCreate(models.EntityHighlight, func() (int64, error) {
+		if m.pages[pageID] == nil {
+			return 0, errForeignKey
+		}
+		id := m.nextID("highlights")
+		m.highlights[id] = &models.Highlight{ID: id, PageID: pageID, Text: text, Comment: comment,
+			Source: source, CreatedAt: memoryNow()}
+		return id, nil
+	})
+}
+
+func (m *Memory) UpdateHighlightComment(id int64, comment string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityHighlight, id, models.ActionUpdate, func() error {
+		if h, ok := m.highlights[id]; ok {
+			h.Comment = comment
+		}
+		return nil
+	})
+}
+
+func (m *Memory) DeleteHighlight(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityHighlight, id, models.ActionDelete, func() error {
+		delete(m.highlights, id)
+		return nil
+	})
+}
+
+// highlightRow fills in the title and address of the highlighted page.
+func (m *Memory) highlightRow(h *models.Highlight) models.Highlight {
+	row := *h
+	p := m.pages[h.PageID]
+	row.PageTitle, row.PagePath = p.Title, p.Path
+	row.SiteDomain = m.sites[p.SiteID].Domain
+	return row
+}
+
+// Collections
+
+func (m *Memory) GetCollections() ([]models.Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var collections []models.Collection
+	for _, c := range byID(m.collections) {
+		collections = append(collections, m.collectionRow(c))
+	}
+	sort.SliceStable(collections, func(i, j int) bool { return collections[i].Name < collections[j].Name })
+	return collections, nil
+}
+
+// GetCollection returns a collection with its items in order.
+func (m *Memory) GetCollection(id int64) (*models.Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getCollection(func(c *models.Collection) bool { return c.ID == id })
+}
+
+// GetSharedCollection looks up a collection by its share token.
+func (m *Memory) GetSharedCollection(token string) (*models.Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getCollection(func(c *models.Collection) bool { return token != "" && c.ShareToken == token })
+}
+
+func (m *Memory) getCollection(match func(c *models.Collection) bool) (*models.Collection, error) {
+	for _, c := range byID(m.collections) {
+		if match(c) {
+			row := m.collectionRow(c)
+			row.Items = m.collectionItems(c.ID)
+			return &row, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *Memory) CreateCollection(name, description string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recordCreate(models.EntityCollection, func() (int64, error) {
+		id := m.nextID("collections")
+		m.collections[id] = &models.Collection{ID: id, Name: name, Description: description, CreatedAt: memoryNow()}
+		return id, nil
+	})
+}
+
+func (m *Memory) UpdateCollection(id int64, name, description string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityCollection, id, models.ActionUpdate, func() error {
+		if c, ok := m.collections[id]; ok {
+			c.Name, c.Description = name, description
+		}
+		return nil
+	})
+}
+
+func (m *Memory) DeleteCollection(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityCollection, id, models.ActionDelete, func() error {
+		delete(m.collections, id)
+		for itemID, it := range m.items {
+			if it.CollectionID == id {
+				delete(m.items, itemID)
+			}
+		}
+		return nil
+	})
+}
+
+// SetCollectionShareToken publishes a collection under token, or stops
+// sharing it when token is empty.
+func (m *Memory) SetCollectionShareToken(id int64, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityCollection, id, models.ActionUpdate, func() error {
+		c, ok := m.collections[id]
+		if !ok {
+			return nil
+		}
+		for _, other := range m.collections {
+			if token != "" && other.ShareToken == token && other.ID != id {
+				return errUnique("collections.share_token")
+			}
+		}
+		c.ShareToken = token
+		return nil
+	})
+}
+
+func (m *Memory) GetCollectionItems(collectionID int64) ([]models.CollectionItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.collectionItems(collectionID), nil
+}
+
+// collectionItems returns a collection's items outside the trash, in order,
+// with their sites and pages.
+func (m *Memory) collectionItems(collectionID int64) []models.CollectionItem {
+	items := []models.CollectionItem{}
+	for _, it := range m.orderedItems(collectionID) {
+		if !m.liveItem(it) {
+			continue
+		}
+		item := it.CollectionItem
+		if it.siteID != nil {
+			site := m.siteDetails(m.sites[*it.siteID])
+			item.Site = &site
+		} else {
+			page := m.pageDetails(m.pages[*it.pageID])
+			item.Page = &page
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// orderedItems returns all of a collection's items by position.
+func (m *Memory) orderedItems(collectionID int64) []*memoryItem {
+	var items []*memoryItem
+	for _, it := range byID(m.items) {
+		if it.CollectionID == collectionID {
+			items = append(items, it)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Position < items[j].Position })
+	return items
+}
+
+// liveItem reports whether an item's site or page is outside the trash.
+func (m *Memory) liveItem(it *memoryItem) bool {
+	if it.siteID != nil {
+		return m.sites[*it.siteID].DeletedAt == nil
+	}
+	return m.pages[*it.pageID].DeletedAt == nil
+}
+
+// collectionRow fills in how many items outside the trash a collection has.
+func (m *Memory) collectionRow(c *models.Collection) models.Collection {
+	row := *c
+	for _, it := range m.items {
+		if it.CollectionID == c.ID && m.liveItem(it) {
+			row.ItemCount++
+		}
+	}
+	return row
+}
+
+// AddCollectionItem appends a site or a page, whichever is non-nil, to the
+// end of a collection.
+func (m *Memory) AddCollectionItem(collectionID int64, siteID, pageID *int64, note string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var id int64
+	err := m.record(models.EntityCollection, collectionID, models.ActionUpdate, func() error {
+		if (siteID == nil) == (pageID == nil) {
+			return errors.New("CHECK constraint failed: (site_id IS NULL) != (page_id IS NULL)")
+		}
+		if m.collections[collectionID] == nil || siteID != nil && m.sites[*siteID] == nil ||
+			pageID != nil && m.pages[*pageID] == nil {
+			return errForeignKey
+		}
+
+		position := 0
+		for _, it := range m.items {
+			if it.CollectionID == collectionID && it.Position >= position {
+				position = it.Position + 1
+			}
+		}
+		id = m.nextID("collection_items")
+		m.items[id] = &memoryItem{
+			CollectionItem: models.CollectionItem{ID: id, CollectionID: collectionID, Position: position,
+				Note: note, CreatedAt: memoryNow()},
+			siteID: copyID(siteID),
+			pageID: copyID(pageID),
+		}
+		return nil
+	})
+	return id, err
+}
+
+func (m *Memory) UpdateCollectionItemNote(collectionID, itemID int64, note string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityCollection, collectionID, models.ActionUpdate, func() error {
+		if it, ok := m.items[itemID]; ok && it.CollectionID == collectionID {
+			it.Note = note
+		}
+		return nil
+	})
+}
+
+func (m *Memory) RemoveCollectionItem(collectionID, itemID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityCollection, collectionID, models.ActionUpdate, func() error {
+		if it, ok := m.items[itemID]; ok && it.CollectionID == collectionID {
+			delete(m.items, itemID)
+		}
+		return nil
+	})
+}
+
+// ReorderCollection gives the listed items positions in the order given.
+// Items of the collection that are not listed keep their old position.
+func (m *Memory) ReorderCollection(collectionID int64, itemIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.record(models.EntityCollection, collectionID, models.ActionUpdate, func() error {
+		for position, itemID := range itemIDs {
+			if it, ok := m.items[itemID]; ok && it.CollectionID == collectionID {
+				it.Position = position
+			}
+		}
+		return nil
+	})
+}
+
+// Dashboard
+
+func (m *Memory) GetDashboardStats() (*models.DashboardStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := models.DashboardStats{CategoryCount: len(m.categories)}
+	for _, s := range m.sites {
+		if s.DeletedAt == nil {
+			stats.SiteCount++
+		}
+	}
+	for _, p := range m.pages {
+		if p.DeletedAt == nil {
+			stats.PageCount++
+			if inQueue(p.ReadStatus) {
+				stats.QueueCount++
+			}
+		}
+	}
+
+	stats.RecentPages = m.getPages(PageFilter{Limit: 10})
+	stats.ReadingQueue = m.readingQueue(5)
+	stats.PinnedSites = m.getSites(SiteFilter{Pinned: true, Sort: SortPinned})
+	stats.PinnedPages = m.getPages(PageFilter{Pinned: true, Sort: SortPinned})
+
+	return &stats, nil
+}
+
+// Search
+
+func (m *Memory) Search(query string) ([]models.Site, []models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sites []models.Site
+	for _, s := range byID(m.sites) {
+		if s.DeletedAt != nil {
+			continue
+		}
+		text := []string{s.Domain, s.Name, s.Description}
+		if n := m.siteNote(s.ID); n != nil {
+			text = append(text, n.Body)
+		}
+		if containsFold(query, text...) {
+			sites = append(sites, m.siteRow(s))
+		}
+	}
+	m.sortSites(sites, "")
+
+	var pages []models.Page
+	for _, p := range byID(m.pages) {
+		if p.DeletedAt == nil && containsFold(query, m.pageText(p)...) {
+			pages = append(pages, m.pageRow(p))
+		}
+	}
+	sortPages(pages, "")
+
+	if len(sites) > 20 {
+		sites = sites[:20]
+	}
+	if len(pages) > 20 {
+		pages = pages[:20]
+	}
+	return sites, pages, nil
+}
+
+// Activity
+
+// GetEvents lists logged changes, most recent first.
+func (m *Memory) GetEvents(filter EventFilter) ([]models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []models.Event
+	for i := len(m.events) - 1; i >= 0; i-- {
+		e := m.events[i]
+		switch {
+		case filter.Entity != "" && e.Entity != filter.Entity,
+			filter.EntityID != 0 && e.EntityID != filter.EntityID,
+			filter.Action != "" && e.Action != filter.Action,
+			filter.Actor != "" && e.Actor != filter.Actor:
+			continue
+		}
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+
+		event := e.Event
+		if e.before != nil {
+			if err := json.Unmarshal(e.before, &event.Before); err != nil {
+				return nil, err
+			}
+		}
+		if e.after != nil {
+			if err := json.Unmarshal(e.after, &event.After); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// GetEventActors lists everyone changes have been attributed to.
+func (m *Memory) GetEventActors() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var actors []string
+	for _, e := range m.events {
+		if !slices.Contains(actors, e.Actor) {
+			actors = append(actors, e.Actor)
+		}
+	}
+	slices.Sort(actors)
+	return actors, nil
+}
+
+// record runs change and logs the entity's state before and after it. A
+// change that fails, or leaves the entity as it was, is not logged.
+func (m *Memory) record(entity string, id int64, action string, change func() error) error {
+	before := m.snapshot(entity, id)
+	if err := change(); err != nil {
+		return err
+	}
+	m.logEvent(entity, id, action, before, m.snapshot(entity, id))
+	return nil
+}
+
+// recordCreate runs create and logs the entity it returns the ID of.
+func (m *Memory) recordCreate(entity string, create func() (int64, error)) (int64, error) {
+	return m.recordInsert(entity, models.ActionCreate, create)
+}
+
+// recordInsert is recordCreate for entities added by another action, such
+// as a restore.
+func (m *Memory) recordInsert(entity, action string, create func() (int64, error)) (int64, error) {
+	id, err := create()
+	if err != nil {
+		return 0, err
+	}
+	m.logEvent(entity, id, action, nil, m.snapshot(entity, id))
+	return id, nil
+}
+
+func (m *Memory) logEvent(entity string, id int64, action string, before, after []byte) {
+	if bytes.Equal(before, after) {
+		return
+	}
+	m.events = append(m.events, memoryEvent{
+		Event: models.Event{ID: m.nextID("events"), Entity: entity, EntityID: id, Action: action,
+			Actor: m.actor, CreatedAt: memoryNow()},
+		before: before,
+		after:  after,
+	})
+}
+
+// snapshot returns an entity as a JSON object keyed by the column names of
+// its SQLite table, with the same extra fields, or nil if there is no such
+// entity.
+func (m *Memory) snapshot(entity string, id int64) []byte {
+	var state map[string]interface{}
+	switch entity {
+	case models.EntityCategory:
+		c, ok := m.categories[id]
+		if !ok {
+			return nil
+		}
+		state = map[string]interface{}{
+			"id": c.ID, "name": c.Name, "description": nullString(c.Description),
+			"created_at": c.CreatedAt, "parent_id": nullInt64(c.ParentID),
+		}
+	case models.EntitySite:
+		s, ok := m.sites[id]
+		if !ok {
+			return nil
+		}
+		state = map[string]interface{}{
+			"id": s.ID, "category_id": nullInt64(s.CategoryID), "domain": s.Domain,
+			"name": nullString(s.Name), "description": nullString(s.Description), "created_at": s.CreatedAt,
+			"favorite": s.Favorite, "pinned_at": s.PinnedAt, "rating": s.Rating, "deleted_at": s.DeletedAt,
+			"tags": m.tagNames(m.siteTags[id]), "note": "",
+		}
+		if n := m.siteNote(id); n != nil {
+			state["note"] = n.Body
+		}
+	case models.EntityPage:
+		p, ok := m.pages[id]
+		if !ok {
+			return nil
+		}
+		state = map[string]interface{}{
+			"id":                   p.ID,
+			"site_id":              p.SiteID,
+			"path":                 p.Path,
+			"title":                nullString(p.Title),
+			"description":          nullString(p.Description),
+			"created_at":           p.CreatedAt,
+			"archived_at":          p.ArchivedAt,
+			"archive_size":         p.ArchiveSize,
+			"watched":              p.Watched,
+			"checked_at":           p.CheckedAt,
+			"changed_at":           p.ChangedAt,
+			"broken_at":            p.BrokenAt,
+			"external_archive_url": nullString(p.ExternalArchiveURL),
+			"external_archived_at": p.ExternalArchivedAt,
+			"read_status":          nullString(p.ReadStatus),
+			"read_status_at":       p.ReadStatusAt,
+			"queued_at":            p.QueuedAt,
+			"visited_at":           p.VisitedAt,
+			"favorite":             p.Favorite,
+			"pinned_at":            p.PinnedAt,
+			"rating":               p.Rating,
+			"deleted_at":           p.DeletedAt,
+			"deleted_with_site":    p.deletedWithSite,
+			"tags":                 m.tagNames(m.pageTags[id]),
+			"note":                 "",
+			"extracted_at":         "",
+		}
+		if n := m.pageNote(id); n != nil {
+			state["note"] = n.Body
+		}
+		if c, ok := m.contents[id]; ok {
+			state["extracted_at"] = c.ExtractedAt.Format(time.DateTime)
+		}
+	case models.EntityTag:
+		name, ok := m.tags[id]
+		if !ok {
+			return nil
+		}
+		aliases := m.tagAliases(id)
+		if aliases == nil {
+			aliases = []string{}
+		}
+		state = map[string]interface{}{"id": id, "name": name, "aliases": aliases}
+	case models.EntitySavedSearch:
+		ss, ok := m.savedSearches[id]
+		if !ok {
+			return nil
+		}
+		state = map[string]interface{}{"id": ss.ID, "name": ss.Name, "query": ss.Query, "created_at": ss.CreatedAt}
+	case models.EntityHighlight:
+		h, ok := m.highlights[id]
+		if !ok {
+			return nil
+		}
+		state = map[string]interface{}{
+			"id": h.ID, "page_id": h.PageID, "text": h.Text, "comment": h.Comment,
+			"source": h.Source, "created_at": h.CreatedAt,
+		}
+	case models.EntityCollection:
+		c, ok := m.collections[id]
+		if !ok {
+			return nil
+		}
+		items := []string{}
+		for _, it := range m.orderedItems(id) {
+			var label string
+			if it.siteID != nil {
+				label = m.sites[*it.siteID].Domain
+			} else {
+				p := m.pages[*it.pageID]
+				label = m.sites[p.SiteID].Domain + p.Path
+			}
+			if it.Note != "" {
+				label += " (" + it.Note + ")"
+			}
+			items = append(items, label)
+		}
+		state = map[string]interface{}{
+			"id": c.ID, "name": c.Name, "description": c.Description,
+			"share_token": nullString(c.ShareToken), "created_at": c.CreatedAt, "items": items,
+		}
+	default:
+		return nil
+	}
+
+	// Every value is a plain string, number, bool, time or list of strings,
+	// none of which can fail to encode
+	b, _ := json.Marshal(state)
+	return b
+}
+
+// tagNames returns the names of a set of tags, sorted.
+func (m *Memory) tagNames(tagIDs map[int64]bool) []string {
+	names := []string{}
+	for _, t := range m.tagList(tagIDs) {
+		names = append(names, t.Name)
+	}
+	return names
+}
@@ -1,29 +1,76 @@
 package repository
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/lehmann314159/bookmarks/internal/models"
 )
 
+// Repository is the Store kept in an SQL database, either SQLite or
+// PostgreSQL.
 type Repository struct {
-	db *sql.DB
+	db    *conn
+	actor string // who changes are attributed to in the activity log
 }
 
+// DefaultActor is who changes made through the web interface are
+// attributed to.
+const DefaultActor = "web"
+
+// New returns a Repository on an SQLite database opened by database.New,
+// reading and writing through the same pool.
 func New(db *sql.DB) *Repository {
-	return &Repository{db: db}
+	return NewWithWriter(db, db)
+}
+
+// NewWithWriter returns a Repository on an SQLite database that reads
+// through db, opened by database.New, and writes through writer, opened by
+// database.NewWriter.
+func NewWithWriter(db, writer *sql.DB) *Repository {
+	return &Repository{db: newConn(db, writer, sqliteDialect), actor: DefaultActor}
+}
+
+// NewPostgres returns a Repository on a PostgreSQL database opened by
+// database.NewPostgres.
+func NewPostgres(db *sql.DB) *Repository {
+	return &Repository{db: newConn(db, db, postgresDialect), actor: DefaultActor}
+}
+
+// As returns a repository sharing the same database that attributes the
+// changes it makes to actor.
+func (r *Repository) As(actor string) Store {
+	return &Repository{db: r.db, actor: actor}
 }
 
 // Categories
 
+// ErrCategoryCycle is returned when a category would become its own ancestor.
+var ErrCategoryCycle = errors.New("a category cannot be nested under itself or one of its subcategories")
+
+// GetCategories returns every category in tree order: each category is
+// followed by its subcategories, siblings sorted by name.
 func (r *Repository) GetCategories() ([]models.Category, error) {
 	rows, err := r.db.Query(`
-		SELECT c.id, c.name, c.description, c.created_at,
-		       (SELECT COUNT(*) FROM sites WHERE category_id = c.id) as site_count
+		WITH RECURSIVE tree(id, depth, sort_path) AS (
+			SELECT id, 0, name FROM categories WHERE parent_id IS NULL
+			UNION ALL
+			SELECT c.id, t.depth + 1, t.sort_path || ` + r.db.dialect.unitSeparator() + ` || c.name
+			FROM categories c JOIN tree t ON c.parent_id = t.id
+		)
+		SELECT c.id, c.parent_id, c.name, c.description, c.created_at,
+		       (SELECT COUNT(*) FROM sites WHERE category_id = c.id AND deleted_at IS NULL) as site_count,
+		       t.depth
 		FROM categories c
-		ORDER BY c.name
-	`)
+		JOIN tree t ON t.id = c.id
+		ORDER BY t.sort_path` + r.db.dialect.bytewise())
 	if err != nil {
 		return nil, err
 	}
@@ -32,10 +79,14 @@ func (r *Repository) GetCategories() ([]models.Category, error) {
 	var categories []models.Category
 	for rows.Next() {
 		var c models.Category
+		var parentID sql.NullInt64
 		var desc sql.NullString
-		if err := rows.Scan(&c.ID, &c.Name, &desc, &c.CreatedAt, &c.SiteCount); err != nil {
+		if err := rows.Scan(&c.ID, &parentID, &c.Name, &desc, &c.CreatedAt, &c.SiteCount, &c.Depth); err != nil {
 			return nil, err
 		}
+		if parentID.Valid {
+			c.ParentID = &parentID.Int64
+		}
 		c.Description = desc.String
 		categories = append(categories, c)
 	}
@@ -44,54 +95,272 @@ func (r *Repository) GetCategories() ([]models.Category, error) {
 
 func (r *Repository) GetCategory(id int64) (*models.Category, error) {
 	var c models.Category
+	var parentID sql.NullInt64
 	var desc sql.NullString
 	err := r.db.QueryRow(`
-		SELECT c.id, c.name, c.description, c.created_at,
-		       (SELECT COUNT(*) FROM sites WHERE category_id = c.id) as site_count
+		SELECT c.id, c.parent_id, c.name, c.description, c.created_at,
+		       (SELECT COUNT(*) FROM sites WHERE category_id = c.id AND deleted_at IS NULL) as site_count,
+		       (WITH RECURSIVE up(id, depth) AS (
+		            SELECT parent_id, 0 FROM categories WHERE id = c.id
+		            UNION ALL
+		            SELECT p.parent_id, up.depth + 1 FROM categories p JOIN up ON p.id = up.id
+		        ) SELECT MAX(depth) FROM up) as depth
 		FROM categories c WHERE c.id = ?
-	`, id).Scan(&c.ID, &c.Name, &desc, &c.CreatedAt, &c.SiteCount)
+	`, id).Scan(&c.ID, &parentID, &c.Name, &desc, &c.CreatedAt, &c.SiteCount, &c.Depth)
 	if err != nil {
 		return nil, err
 	}
+	if parentID.Valid {
+		c.ParentID = &parentID.Int64
+	}
 	c.Description = desc.String
 	return &c, nil
 }
 
-func (r *Repository) CreateCategory(name, description string) (int64, error) {
-	result, err := r.db.Exec(`INSERT INTO categories (name, description) VALUES (?, ?)`, name, nullString(description))
-	if err != nil {
-		return 0, err
-	}
-	return result.LastInsertId()
+func (r *Repository) CreateCategory(name, description string, parentID *int64) (int64, error) {
+	return r.recordCreate(models.EntityCategory, func(tx *txn) (int64, error) {
+		var id int64
+		err := tx.QueryRow(`INSERT INTO categories (name, description, parent_id) VALUES (?, ?, ?) RETURNING id`,
+			name, nullString(description), nullInt64(parentID)).Scan(&id)
+		return id, err
+	})
 }
 
-func (r *Repository) UpdateCategory(id int64, name, description string) error {
-	_, err := r.db.Exec(`UPDATE categories SET name = ?, description = ? WHERE id = ?`, name, nullString(description), id)
-	return err
+func (r *Repository) UpdateCategory(id int64, name, description string, parentID *int64) error {
+	return r.record(models.EntityCategory, id, models.ActionUpdate, func(tx *txn) error {
+		if parentID != nil {
+			var cycle bool
+			err := tx.QueryRow(`
+				WITH RECURSIVE sub(id) AS (
+					SELECT CAST(? AS BIGINT)
+					UNION ALL
+					SELECT c.id FROM categories c JOIN sub ON c.parent_id = sub.id
+				)
+				SELECT EXISTS (SELECT 1 FROM sub WHERE id = ?)
+			`, id, *parentID).Scan(&cycle)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return ErrCategoryCycle
+			}
+		}
+
+		_, err := tx.Exec(`UPDATE categories SET name = ?, description = ?, parent_id = ? WHERE id = ?`,
+			name, nullString(description), nullInt64(parentID), id)
+		return err
+	})
 }
 
 func (r *Repository) DeleteCategory(id int64) error {
-	_, err := r.db.Exec(`DELETE FROM categories WHERE id = ?`, id)
-	return err
+	return r.record(models.EntityCategory, id, models.ActionDelete, func(tx *txn) error {
+		_, err := tx.Exec(`DELETE FROM categories WHERE id = ?`, id)
+		return err
+	})
+}
+
+// categoryFilter restricts sites (aliased s) to a category, or to it and all
+// of its descendants.
+func categoryFilter(categoryID int64, includeDescendants bool) (string, []interface{}) {
+	if !includeDescendants {
+		return "s.category_id = ?", []interface{}{categoryID}
+	}
+	return `s.category_id IN (
+			WITH RECURSIVE sub(id) AS (
+				SELECT CAST(? AS BIGINT)
+				UNION ALL
+				SELECT c.id FROM categories c JOIN sub ON c.parent_id = sub.id
+			)
+			SELECT id FROM sub
+		)`, []interface{}{categoryID}
+}
+
+// hasTag returns a condition on pages p and sites s that holds when the page
+// carries the tag.
+func (f TagFilter) hasTag(tagID int64) (string, []interface{}) {
+	set, args := tagSet(tagID, f.IncludeSubtags)
+	condition := "EXISTS (SELECT 1 FROM page_tags WHERE page_id = p.id AND tag_id IN " + set + ")"
+	if !f.IncludeSiteTags {
+		return condition, args
+	}
+	condition = "(" + condition + " OR EXISTS (SELECT 1 FROM site_tags WHERE site_id = s.id AND tag_id IN " + set + "))"
+	return condition, append(args, args...)
+}
+
+// tagSet returns a subquery selecting the tag and, optionally, every tag
+// nested below it by name.
+func tagSet(tagID int64, includeDescendants bool) (string, []interface{}) {
+	if !includeDescendants {
+		return "(?)", []interface{}{tagID}
+	}
+	return `(
+			SELECT t.id FROM tags t, tags parent
+			WHERE parent.id = ?
+			  AND (t.id = parent.id OR substr(t.name, 1, length(parent.name) + 1) = parent.name || '` + models.TagSeparator + `')
+		)`, []interface{}{tagID}
+}
+
+// setCategoryPaths fills in the breadcrumb trail of each site's category.
+func (r *Repository) setCategoryPaths(sites []models.Site) error {
+	categories, err := r.GetCategories()
+	if err != nil {
+		return err
+	}
+	byID := make(map[int64]models.Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+
+	for i := range sites {
+		var path []models.Category
+		for id := sites[i].CategoryID; id != nil; {
+			c, ok := byID[*id]
+			if !ok {
+				break
+			}
+			path = append([]models.Category{c}, path...)
+			id = c.ParentID
+		}
+		sites[i].CategoryPath = path
+	}
+	return nil
 }
 
 // Sites
 
-func (r *Repository) GetSites(categoryID *int64) ([]models.Site, error) {
-	query := `
-		SELECT s.id, s.category_id, COALESCE(c.name, '') as category_name,
-		       s.domain, s.name, s.description, s.created_at,
-		       (SELECT COUNT(*) FROM pages WHERE site_id = s.id) as page_count
-		FROM sites s
-		LEFT JOIN categories c ON s.category_id = c.id
-	`
+// SiteFilter selects sites for GetSites. Zero values match every site
+// outside the trash.
+type SiteFilter struct {
+	CategoryID *int64
+	// IncludeSubcategories lets CategoryID also match its descendants.
+	IncludeSubcategories bool
+	Favorites            bool
+	Pinned               bool
+	// MinRating keeps sites rated at least this many stars.
+	MinRating int
+	// TagID keeps sites carrying the tag.
+	TagID *int64
+	// IncludeSubtags lets TagID also match the tags nested below it.
+	IncludeSubtags bool
+	// Sort is one of the Sort constants; sites are listed by domain by
+	// default.
+	Sort string
+	// Limit caps the number of sites returned, after skipping Offset of
+	// them. Zero means no limit.
+	Limit  int
+	Offset int
+}
+
+// Sort orders understood by PageFilter, SiteFilter and TagListFilter.
+const (
+	SortNewest = "newest"
+	SortOldest = "oldest"
+	SortName   = "name"
+	SortDomain = "domain"
+	SortRating = "rating"
+	// SortPageCount lists the sites or tags with the most pages first.
+	SortPageCount = "pages"
+	// SortVisited lists the most recently visited first. A site was visited
+	// when one of its pages was.
+	SortVisited = "visited"
+	// SortPinned lists the most recently pinned first.
+	SortPinned = "pinned"
+)
+
+// PageSortOrders, SiteSortOrders and TagSortOrders are the sort orders
+// offered in each list's filters, the default first.
+var (
+	PageSortOrders = []string{SortNewest, SortOldest, SortName, SortDomain, SortVisited, SortRating}
+	SiteSortOrders = []string{SortDomain, SortNewest, SortOldest, SortName, SortPageCount, SortVisited, SortRating}
+	TagSortOrders  = []string{SortName, SortPageCount}
+)
+
+// limit returns the LIMIT clause for a filter's Limit and Offset, with its
+// arguments. They are passed as arguments so that every page of a list
+// runs the same statement.
+func limit(n, offset int) (string, []interface{}) {
+	if n <= 0 {
+		return "", nil
+	}
+	return " LIMIT ? OFFSET ?", []interface{}{n, offset}
+}
+
+func (f SiteFilter) where(d dialect) (string, []interface{}) {
 	args := []interface{}{}
-	if categoryID != nil {
-		query += ` WHERE s.category_id = ?`
-		args = append(args, *categoryID)
+	conditions := []string{"s.deleted_at IS NULL"}
+
+	if f.CategoryID != nil {
+		condition, conditionArgs := categoryFilter(*f.CategoryID, f.IncludeSubcategories)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	if f.Favorites {
+		conditions = append(conditions, "s.favorite")
+	}
+	if f.Pinned {
+		conditions = append(conditions, "s.pinned_at IS NOT NULL")
+	}
+	if f.MinRating > 0 {
+		conditions = append(conditions, "s.rating >= ?")
+		args = append(args, f.MinRating)
+	}
+	if f.TagID != nil {
+		set, setArgs := tagSet(*f.TagID, f.IncludeSubtags)
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM site_tags WHERE site_id = s.id AND tag_id IN "+set+")")
+		args = append(args, setArgs...)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (f SiteFilter) orderBy() string {
+	switch f.Sort {
+	case SortNewest:
+		return " ORDER BY s.created_at DESC, s.id DESC"
+	case SortOldest:
+		return " ORDER BY s.created_at, s.id"
+	case SortName:
+		return " ORDER BY LOWER(COALESCE(NULLIF(s.name, ''), s.domain)), s.domain"
+	case SortRating:
+		return " ORDER BY s.rating DESC, s.domain"
+	case SortPageCount:
+		return " ORDER BY page_count DESC, s.domain"
+	case SortVisited:
+		return ` ORDER BY (SELECT MAX(visited_at) FROM pages WHERE site_id = s.id AND deleted_at IS NULL) DESC NULLS LAST,
+		                  s.domain`
+	case SortPinned:
+		return " ORDER BY s.pinned_at DESC"
+	default:
+		return " ORDER BY s.domain"
 	}
-	query += ` ORDER BY s.domain`
+}
+
+// GetSites lists the sites matching filter.
+func (r *Repository) GetSites(filter SiteFilter) ([]models.Site, error) {
+	where, args := filter.where(r.db.dialect)
+	limitClause, limitArgs := limit(filter.Limit, filter.Offset)
+	query := `SELECT ` + siteColumns + `
+		FROM sites s
+		LEFT JOIN categories c ON s.category_id = c.id
+	` + where + filter.orderBy() + limitClause
+
+	return r.querySites(query, append(args, limitArgs...)...)
+}
+
+// CountSites returns how many sites match filter, ignoring its Limit.
+func (r *Repository) CountSites(filter SiteFilter) (int, error) {
+	where, args := filter.where(r.db.dialect)
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM sites s`+where, args...).Scan(&count)
+	return count, err
+}
 
+// querySites runs a query selecting siteColumns and loads each site's tags
+// and category path.
+func (r *Repository) querySites(query string, args ...interface{}) ([]models.Site, error) {
 	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
@@ -100,148 +369,298 @@ func (r *Repository) GetSites(categoryID *int64) ([]models.Site, error) {
 
 	var sites []models.Site
 	for rows.Next() {
-		var s models.Site
-		var catID sql.NullInt64
-		var name, desc sql.NullString
-		if err := rows.Scan(&s.ID, &catID, &s.CategoryName, &s.Domain, &name, &desc, &s.CreatedAt, &s.PageCount); err != nil {
+		s, err := scanSite(rows)
+		if err != nil {
 			return nil, err
 		}
-		if catID.Valid {
-			s.CategoryID = &catID.Int64
-		}
-		s.Name = name.String
-		s.Description = desc.String
-		sites = append(sites, s)
+		sites = append(sites, *s)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	// Load tags for each site
+	ids := make([]int64, len(sites))
 	for i := range sites {
-		tags, err := r.GetSiteTags(sites[i].ID)
-		if err != nil {
-			return nil, err
-		}
-		sites[i].Tags = tags
+		ids[i] = sites[i].ID
 	}
-
-	return sites, nil
-}
-
-func (r *Repository) GetSite(id int64) (*models.Site, error) {
-	var s models.Site
-	var catID sql.NullInt64
-	var name, desc sql.NullString
-	err := r.db.QueryRow(`
-		SELECT s.id, s.category_id, COALESCE(c.name, '') as category_name,
-		       s.domain, s.name, s.description, s.created_at,
-		       (SELECT COUNT(*) FROM pages WHERE site_id = s.id) as page_count
-		FROM sites s
-		LEFT JOIN categories c ON s.category_id = c.id
-		WHERE s.id = ?
-	`, id).Scan(&s.ID, &catID, &s.CategoryName, &s.Domain, &name, &desc, &s.CreatedAt, &s.PageCount)
+	tags, err := r.tagsOf("site_tags", "site_id", ids)
 	if err != nil {
 		return nil, err
 	}
-	if catID.Valid {
-		s.CategoryID = &catID.Int64
+	for i := range sites {
+		sites[i].Tags = tags[sites[i].ID]
 	}
-	s.Name = name.String
-	s.Description = desc.String
 
-	tags, err := r.GetSiteTags(s.ID)
-	if err != nil {
+	if err := r.setCategoryPaths(sites); err != nil {
 		return nil, err
 	}
-	s.Tags = tags
 
-	return &s, nil
+	return sites, nil
 }
 
-func (r *Repository) GetSiteByDomain(domain string) (*models.Site, error) {
-	var s models.Site
-	var catID sql.NullInt64
-	var name, desc sql.NullString
-	err := r.db.QueryRow(`
-		SELECT s.id, s.category_id, COALESCE(c.name, '') as category_name,
-		       s.domain, s.name, s.description, s.created_at,
-		       (SELECT COUNT(*) FROM pages WHERE site_id = s.id) as page_count
+func (r *Repository) GetSite(id int64) (*models.Site, error) {
+	sites, err := r.querySites(`SELECT `+siteColumns+`
 		FROM sites s
 		LEFT JOIN categories c ON s.category_id = c.id
-		WHERE s.domain = ?
-	`, domain).Scan(&s.ID, &catID, &s.CategoryName, &s.Domain, &name, &desc, &s.CreatedAt, &s.PageCount)
+		WHERE s.id = ? AND s.deleted_at IS NULL
+	`, id)
 	if err != nil {
 		return nil, err
 	}
-	if catID.Valid {
-		s.CategoryID = &catID.Int64
+	if len(sites) == 0 {
+		return nil, sql.ErrNoRows
 	}
-	s.Name = name.String
-	s.Description = desc.String
-	return &s, nil
+	return &sites[0], nil
+}
+
+func (r *Repository) GetSiteByDomain(domain string) (*models.Site, error) {
+	return scanSite(r.db.QueryRow(`SELECT `+siteColumns+`
+		FROM sites s
+		LEFT JOIN categories c ON s.category_id = c.id
+		WHERE s.domain = ? AND s.deleted_at IS NULL
+	`, domain))
 }
 
+// CreateSite adds a site. Adding the domain of a site in the trash brings
+// that site back instead; pages trashed along with it stay in the trash.
 func (r *Repository) CreateSite(categoryID *int64, domain, name, description string) (int64, error) {
 	var catID interface{} = nil
 	if categoryID != nil {
 		catID = *categoryID
 	}
-	result, err := r.db.Exec(`INSERT INTO sites (category_id, domain, name, description) VALUES (?, ?, ?, ?)`,
-		catID, domain, nullString(name), nullString(description))
-	if err != nil {
+
+	// Adding a site that is in the trash restores it with the new details
+	var trashedID int64
+	err := r.db.QueryRow(`SELECT id FROM sites WHERE domain = ? AND deleted_at IS NOT NULL`, domain).Scan(&trashedID)
+	if err == nil {
+		err := r.record(models.EntitySite, trashedID, models.ActionRestore, func(tx *txn) error {
+			return untrashSite(tx, trashedID, false)
+		})
+		if err != nil {
+			return 0, err
+		}
+		return trashedID, r.UpdateSite(trashedID, categoryID, domain, name, description)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
 		return 0, err
 	}
-	return result.LastInsertId()
+
+	return r.recordCreate(models.EntitySite, func(tx *txn) (int64, error) {
+		var id int64
+		err := tx.QueryRow(`INSERT INTO sites (category_id, domain, name, description) VALUES (?, ?, ?, ?) RETURNING id`,
+			catID, domain, nullString(name), nullString(description)).Scan(&id)
+		return id, err
+	})
 }
 
 func (r *Repository) UpdateSite(id int64, categoryID *int64, domain, name, description string) error {
-	var catID interface{} = nil
-	if categoryID != nil {
-		catID = *categoryID
-	}
-	_, err := r.db.Exec(`UPDATE sites SET category_id = ?, domain = ?, name = ?, description = ? WHERE id = ?`,
-		catID, domain, nullString(name), nullString(description), id)
-	return err
+	return r.record(models.EntitySite, id, models.ActionUpdate, func(tx *txn) error {
+		var catID interface{} = nil
+		if categoryID != nil {
+			catID = *categoryID
+		}
+		_, err := tx.Exec(`UPDATE sites SET category_id = ?, domain = ?, name = ?, description = ? WHERE id = ?`,
+			catID, domain, nullString(name), nullString(description), id)
+		return err
+	})
 }
 
+// DeleteSite moves a site and all of its pages into the trash.
 func (r *Repository) DeleteSite(id int64) error {
-	_, err := r.db.Exec(`DELETE FROM sites WHERE id = ?`, id)
-	return err
+	return r.record(models.EntitySite, id, models.ActionDelete, func(tx *txn) error {
+		result, err := tx.Exec(`UPDATE sites SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		_, err = tx.Exec(`UPDATE pages SET deleted_at = (SELECT deleted_at FROM sites WHERE id = ?), deleted_with_site = TRUE
+			WHERE site_id = ? AND deleted_at IS NULL`, id, id)
+		return err
+	})
 }
 
 // Pages
 
-func (r *Repository) GetPages(siteID *int64, categoryID *int64, tagID *int64) ([]models.Page, error) {
-	query := `
-		SELECT DISTINCT p.id, p.site_id, s.domain, p.path, p.title, p.description, p.created_at
-		FROM pages p
-		JOIN sites s ON p.site_id = s.id
-		LEFT JOIN categories c ON s.category_id = c.id
-	`
+// TagFilter selects pages by their tags. A page matches when it carries
+// every tag in All, at least one tag in Any (if given) and none in None.
+type TagFilter struct {
+	All  []int64
+	Any  []int64
+	None []int64
+	// IncludeSubtags lets a tag also match its children, e.g. "lang/go"
+	// for "lang".
+	IncludeSubtags bool
+	// IncludeSiteTags counts the tags of a page's site as its own.
+	IncludeSiteTags bool
+}
+
+// IsEmpty reports whether the filter selects every page.
+func (f TagFilter) IsEmpty() bool {
+	return len(f.All) == 0 && len(f.Any) == 0 && len(f.None) == 0
+}
+
+// PageFilter selects pages for GetPages and CountPages. Zero values match
+// every page outside the trash.
+type PageFilter struct {
+	SiteID     *int64
+	CategoryID *int64
+	// IncludeSubcategories lets CategoryID also match its descendants.
+	IncludeSubcategories bool
+	Tags                 TagFilter
+	// Query matches page titles, paths, descriptions, domains, extracted
+	// text, notes and highlights.
+	Query string
+	// ReadStatus limits pages to one read-later state.
+	ReadStatus string
+	Favorites  bool
+	Pinned     bool
+	// MinRating keeps pages rated at least this many stars.
+	MinRating int
+	// Sort is one of the Sort constants; pages are listed newest first by
+	// default.
+	Sort string
+	// Limit caps the number of pages returned, after skipping Offset of
+	// them. Zero means no limit.
+	Limit  int
+	Offset int
+}
+
+// where builds the WHERE clause, if any, for the filter on pages p and
+// sites s.
+func (f PageFilter) where(d dialect) (string, []interface{}) {
 	args := []interface{}{}
-	conditions := []string{}
+	conditions := []string{"p.deleted_at IS NULL"}
 
-	if siteID != nil {
+	if f.SiteID != nil {
 		conditions = append(conditions, "p.site_id = ?")
-		args = append(args, *siteID)
+		args = append(args, *f.SiteID)
 	}
-	if categoryID != nil {
-		conditions = append(conditions, "s.category_id = ?")
-		args = append(args, *categoryID)
+	if f.CategoryID != nil {
+		condition, conditionArgs := categoryFilter(*f.CategoryID, f.IncludeSubcategories)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	tags := f.Tags
+	for _, tagID := range tags.All {
+		condition, conditionArgs := tags.hasTag(tagID)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
 	}
-	if tagID != nil {
-		query += ` LEFT JOIN page_tags pt ON p.id = pt.page_id LEFT JOIN site_tags st ON s.id = st.site_id`
-		conditions = append(conditions, "(pt.tag_id = ? OR st.tag_id = ?)")
-		args = append(args, *tagID, *tagID)
+	if len(tags.Any) > 0 {
+		var alternatives []string
+		for _, tagID := range tags.Any {
+			condition, conditionArgs := tags.hasTag(tagID)
+			alternatives = append(alternatives, condition)
+			args = append(args, conditionArgs...)
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	for _, tagID := range tags.None {
+		condition, conditionArgs := tags.hasTag(tagID)
+		conditions = append(conditions, "NOT "+condition)
+		args = append(args, conditionArgs...)
+	}
+	if f.Query != "" {
+		search := d.pageSearch(f.Query)
+		search.add(d.contains("s.domain", f.Query))
+		condition, searchArgs := search.where()
+		conditions = append(conditions, condition)
+		args = append(args, searchArgs...)
 	}
 
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	if f.ReadStatus != "" {
+		conditions = append(conditions, "p.read_status = ?")
+		args = append(args, f.ReadStatus)
+	}
+	if f.Favorites {
+		conditions = append(conditions, "p.favorite")
+	}
+	if f.Pinned {
+		conditions = append(conditions, "p.pinned_at IS NOT NULL")
+	}
+	if f.MinRating > 0 {
+		conditions = append(conditions, "p.rating >= ?")
+		args = append(args, f.MinRating)
 	}
-	query += " ORDER BY p.created_at DESC"
 
-	rows, err := r.db.Query(query, args...)
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// pageSearch matches pages p against query by their title, path,
+// description, extracted text, note and highlights.
+func (d dialect) pageSearch(query string) *alternatives {
+	search := &alternatives{}
+	search.add(d.contains("p.title", query))
+	search.add(d.contains("p.path", query))
+	search.add(d.matches("COALESCE(p.description, '')", query))
+	search.addExists("page_contents pc WHERE pc.page_id = p.id", d.matches("pc.text", query))
+	search.addExists("notes n WHERE n.page_id = p.id", d.matches("n.body", query))
+	search.addExists("highlights h WHERE h.page_id = p.id", d.matches("h.text || ' ' || h.comment", query))
+	return search
+}
+
+// siteSearch matches sites s against query by their domain, name,
+// description and note.
+func (d dialect) siteSearch(query string) *alternatives {
+	search := &alternatives{}
+	search.add(d.contains("s.domain", query))
+	search.add(d.contains("s.name", query))
+	search.add(d.matches("COALESCE(s.description, '')", query))
+	search.addExists("notes n WHERE n.site_id = s.id", d.matches("n.body", query))
+	return search
+}
+
+func (f PageFilter) orderBy() string {
+	switch f.Sort {
+	case SortOldest:
+		return " ORDER BY p.created_at, p.id"
+	case SortName:
+		return " ORDER BY LOWER(COALESCE(NULLIF(p.title, ''), s.domain || p.path)), p.id"
+	case SortDomain:
+		return " ORDER BY s.domain, p.path, p.id"
+	case SortVisited:
+		return " ORDER BY p.visited_at DESC NULLS LAST, p.created_at DESC, p.id DESC"
+	case SortRating:
+		return " ORDER BY p.rating DESC, p.created_at DESC, p.id DESC"
+	case SortPinned:
+		return " ORDER BY p.pinned_at DESC"
+	default:
+		return " ORDER BY p.created_at DESC, p.id DESC"
+	}
+}
+
+// GetPages lists the pages matching filter.
+func (r *Repository) GetPages(filter PageFilter) ([]models.Page, error) {
+	where, args := filter.where(r.db.dialect)
+	limitClause, limitArgs := limit(filter.Limit, filter.Offset)
+	query := `
+		SELECT ` + pageColumns + `
+		FROM pages p
+		JOIN sites s ON p.site_id = s.id
+		LEFT JOIN categories c ON s.category_id = c.id
+	` + where + filter.orderBy() + limitClause
+	args = append(args, limitArgs...)
+
+	if !filter.Tags.IsEmpty() {
+		// One condition per tag, so the query varies with their number
+		return r.scanPages(r.db.QueryOnce(query, args...))
+	}
+	return r.queryPages(query, args...)
+}
+
+// queryPages runs a query selecting pageColumns and loads each page's tags.
+func (r *Repository) queryPages(query string, args ...interface{}) ([]models.Page, error) {
+	return r.scanPages(r.db.Query(query, args...))
+}
+
+// scanPages reads the pages from the rows of a query selecting
+// pageColumns and loads each page's tags.
+func (r *Repository) scanPages(rows *sql.Rows, err error) ([]models.Page, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -249,51 +668,109 @@ func (r *Repository) GetPages(siteID *int64, categoryID *int64, tagID *int64) ([
 
 	var pages []models.Page
 	for rows.Next() {
-		var p models.Page
-		var title, desc sql.NullString
-		if err := rows.Scan(&p.ID, &p.SiteID, &p.SiteDomain, &p.Path, &title, &desc, &p.CreatedAt); err != nil {
+		p, err := scanPage(rows)
+		if err != nil {
 			return nil, err
 		}
-		p.Title = title.String
-		p.Description = desc.String
-		pages = append(pages, p)
+		pages = append(pages, *p)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	// Load tags for each page
+	ids := make([]int64, len(pages))
+	var siteIDs []int64
+	seen := map[int64]bool{}
+	for i, p := range pages {
+		ids[i] = p.ID
+		if !seen[p.SiteID] {
+			seen[p.SiteID] = true
+			siteIDs = append(siteIDs, p.SiteID)
+		}
+	}
+	tags, err := r.tagsOf("page_tags", "page_id", ids)
+	if err != nil {
+		return nil, err
+	}
+	siteTags, err := r.tagsOf("site_tags", "site_id", siteIDs)
+	if err != nil {
+		return nil, err
+	}
 	for i := range pages {
-		tags, err := r.GetPageTags(pages[i].ID)
+		pages[i].Tags = tags[pages[i].ID]
+		pages[i].SiteTags = siteTags[pages[i].SiteID]
+	}
+
+	return pages, nil
+}
+
+// tagBatchSize caps the IDs tagsOf passes to a single query, well below
+// the number of parameters SQLite allows.
+const tagBatchSize = 500
+
+// tagsOf loads the tags of many sites or pages at once, linked through
+// table (site_tags or page_tags) by column, keyed by site or page ID and
+// sorted by name.
+func (r *Repository) tagsOf(table, column string, ids []int64) (map[int64][]models.Tag, error) {
+	tags := make(map[int64][]models.Tag, len(ids))
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), tagBatchSize)]
+		ids = ids[len(batch):]
+
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		rows, err := r.db.QueryOnce(`
+			SELECT l.`+column+`, t.id, t.name FROM `+table+` l
+			JOIN tags t ON t.id = l.tag_id
+			WHERE l.`+column+` IN (?`+strings.Repeat(", ?", len(batch)-1)+`)
+			ORDER BY t.name
+		`, args...)
 		if err != nil {
 			return nil, err
 		}
-		pages[i].Tags = tags
-
-		siteTags, err := r.GetSiteTags(pages[i].SiteID)
-		if err != nil {
+		for rows.Next() {
+			var ownerID int64
+			var t models.Tag
+			if err := rows.Scan(&ownerID, &t.ID, &t.Name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tags[ownerID] = append(tags[ownerID], t)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
 			return nil, err
 		}
-		pages[i].SiteTags = siteTags
 	}
+	return tags, nil
+}
 
-	return pages, nil
+// CountPages returns how many pages match filter, ignoring its Limit.
+func (r *Repository) CountPages(filter PageFilter) (int, error) {
+	where, args := filter.where(r.db.dialect)
+	query := `SELECT COUNT(*) FROM pages p JOIN sites s ON p.site_id = s.id` + where
+	var count int
+	var err error
+	if filter.Tags.IsEmpty() {
+		err = r.db.QueryRow(query, args...).Scan(&count)
+	} else {
+		err = r.db.QueryRowOnce(query, args...).Scan(&count)
+	}
+	return count, err
 }
 
 func (r *Repository) GetPage(id int64) (*models.Page, error) {
-	var p models.Page
-	var title, desc sql.NullString
-	err := r.db.QueryRow(`
-		SELECT p.id, p.site_id, s.domain, p.path, p.title, p.description, p.created_at
+	p, err := scanPage(r.db.QueryRow(`
+		SELECT `+pageColumns+`
 		FROM pages p
 		JOIN sites s ON p.site_id = s.id
-		WHERE p.id = ?
-	`, id).Scan(&p.ID, &p.SiteID, &p.SiteDomain, &p.Path, &title, &desc, &p.CreatedAt)
+		WHERE p.id = ? AND p.deleted_at IS NULL
+	`, id))
 	if err != nil {
 		return nil, err
 	}
-	p.Title = title.String
-	p.Description = desc.String
 
 	tags, err := r.GetPageTags(p.ID)
 	if err != nil {
@@ -307,285 +784,2209 @@ func (r *Repository) GetPage(id int64) (*models.Page, error) {
 	}
 	p.SiteTags = siteTags
 
-	return &p, nil
+	return p, nil
 }
 
+// CreatePage adds a page. Adding a page that is in the trash restores it
+// with the new title and description.
 func (r *Repository) CreatePage(siteID int64, path, title, description string) (int64, error) {
-	result, err := r.db.Exec(`INSERT INTO pages (site_id, path, title, description) VALUES (?, ?, ?, ?)`,
-		siteID, path, nullString(title), nullString(description))
-	if err != nil {
+	// Adding a page that is in the trash restores it with the new details
+	var trashedID int64
+	err := r.db.QueryRow(`SELECT id FROM pages WHERE site_id = ? AND path = ? AND deleted_at IS NOT NULL`,
+		siteID, path).Scan(&trashedID)
+	if err == nil {
+		return trashedID, r.record(models.EntityPage, trashedID, models.ActionRestore, func(tx *txn) error {
+			_, err := tx.Exec(`UPDATE pages SET title = ?, description = ?, deleted_at = NULL, deleted_with_site = FALSE
+				WHERE id = ?`, nullString(title), nullString(description), trashedID)
+			return err
+		})
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
 		return 0, err
 	}
-	return result.LastInsertId()
+
+	return r.recordCreate(models.EntityPage, func(tx *txn) (int64, error) {
+		var id int64
+		err := tx.QueryRow(`INSERT INTO pages (site_id, path, title, description) VALUES (?, ?, ?, ?) RETURNING id`,
+			siteID, path, nullString(title), nullString(description)).Scan(&id)
+		return id, err
+	})
 }
 
 func (r *Repository) UpdatePage(id int64, siteID int64, path, title, description string) error {
-	_, err := r.db.Exec(`UPDATE pages SET site_id = ?, path = ?, title = ?, description = ? WHERE id = ?`,
-		siteID, path, nullString(title), nullString(description), id)
-	return err
+	return r.record(models.EntityPage, id, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE pages SET site_id = ?, path = ?, title = ?, description = ? WHERE id = ?`,
+			siteID, path, nullString(title), nullString(description), id)
+		return err
+	})
 }
 
+// DeletePage moves a page into the trash. It returns sql.ErrNoRows for a
+// page that does not exist or is in the trash already.
 func (r *Repository) DeletePage(id int64) error {
-	_, err := r.db.Exec(`DELETE FROM pages WHERE id = ?`, id)
-	return err
+	return r.record(models.EntityPage, id, models.ActionDelete, func(tx *txn) error {
+		result, err := tx.Exec(`UPDATE pages SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
 }
 
-// Tags
+// SetPageArchive records that a snapshot of size bytes was just stored for the page.
+func (r *Repository) SetPageArchive(id int64, size int64) error {
+	return r.record(models.EntityPage, id, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE pages SET archived_at = CURRENT_TIMESTAMP, archive_size = ? WHERE id = ?`, size, id)
+		return err
+	})
+}
 
-func (r *Repository) GetTags() ([]models.Tag, error) {
+func (r *Repository) GetPageContent(pageID int64) (*models.PageContent, error) {
+	var c models.PageContent
+	err := r.db.QueryRow(`
+		SELECT page_id, text, extracted_at FROM page_contents WHERE page_id = ?
+	`, pageID).Scan(&c.PageID, &c.Text, &c.ExtractedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SetPageContent stores the extracted text of a page, replacing any earlier
+// extraction. Extracting the same text again changes nothing, so watcher
+// runs over unchanged pages stay out of the activity log.
+func (r *Repository) SetPageContent(pageID int64, text string) error {
+	return r.record(models.EntityPage, pageID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`
+			INSERT INTO page_contents (page_id, text) VALUES (?, ?)
+			ON CONFLICT(page_id) DO UPDATE SET text = excluded.text, extracted_at = CURRENT_TIMESTAMP
+			WHERE page_contents.text <> excluded.text
+		`, pageID, text)
+		return err
+	})
+}
+
+// SetPageBroken flags or clears a page whose URL no longer resolves. The
+// original time is kept while the page stays broken.
+func (r *Repository) SetPageBroken(id int64, broken bool) error {
+	query := `UPDATE pages SET broken_at = NULL WHERE id = ?`
+	if broken {
+		query = `UPDATE pages SET broken_at = COALESCE(broken_at, CURRENT_TIMESTAMP) WHERE id = ?`
+	}
+	return r.record(models.EntityPage, id, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(query, id)
+		return err
+	})
+}
+
+// SetPageExternalArchive records where an external archive keeps a copy of the page.
+func (r *Repository) SetPageExternalArchive(id int64, archiveURL string, archivedAt time.Time) error {
+	var at interface{}
+	if !archivedAt.IsZero() {
+		at = archivedAt
+	}
+	return r.record(models.EntityPage, id, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE pages SET external_archive_url = ?, external_archived_at = ? WHERE id = ?`,
+			archiveURL, at, id)
+		return err
+	})
+}
+
+// Watched pages
+
+func (r *Repository) SetPageWatched(id int64, watched bool) error {
+	return r.record(models.EntityPage, id, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE pages SET watched = ? WHERE id = ?`, watched, id)
+		return err
+	})
+}
+
+func (r *Repository) GetWatchedPages() ([]models.Page, error) {
 	rows, err := r.db.Query(`
-		SELECT t.id, t.name,
-		       (SELECT COUNT(*) FROM site_tags WHERE tag_id = t.id) as site_count,
-		       (SELECT COUNT(*) FROM page_tags WHERE tag_id = t.id) as page_count
-		FROM tags t
-		ORDER BY t.name
+		SELECT ` + pageColumns + `
+		FROM pages p
+		JOIN sites s ON p.site_id = s.id
+		WHERE p.watched AND p.deleted_at IS NULL
+		ORDER BY p.checked_at NULLS FIRST
 	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var tags []models.Tag
+	var pages []models.Page
 	for rows.Next() {
-		var t models.Tag
-		if err := rows.Scan(&t.ID, &t.Name, &t.SiteCount, &t.PageCount); err != nil {
+		p, err := scanPage(rows)
+		if err != nil {
 			return nil, err
 		}
-		tags = append(tags, t)
+		pages = append(pages, *p)
 	}
-	return tags, rows.Err()
+	return pages, rows.Err()
 }
 
-func (r *Repository) GetTag(id int64) (*models.Tag, error) {
-	var t models.Tag
-	err := r.db.QueryRow(`
-		SELECT t.id, t.name,
-		       (SELECT COUNT(*) FROM site_tags WHERE tag_id = t.id) as site_count,
-		       (SELECT COUNT(*) FROM page_tags WHERE tag_id = t.id) as page_count
-		FROM tags t WHERE t.id = ?
-	`, id).Scan(&t.ID, &t.Name, &t.SiteCount, &t.PageCount)
+// GetPageVersions returns the recorded versions of a page, newest first.
+func (r *Repository) GetPageVersions(pageID int64) ([]models.PageVersion, error) {
+	rows, err := r.db.Query(`
+		SELECT id, page_id, text, hash, diff, fetched_at
+		FROM page_versions
+		WHERE page_id = ?
+		ORDER BY id DESC
+	`, pageID)
 	if err != nil {
 		return nil, err
 	}
-	return &t, nil
-}
+	defer rows.Close()
 
-func (r *Repository) GetOrCreateTag(name string) (int64, error) {
-	name = strings.TrimSpace(strings.ToLower(name))
-	var id int64
-	err := r.db.QueryRow(`SELECT id FROM tags WHERE name = ?`, name).Scan(&id)
-	if err == nil {
-		return id, nil
-	}
-	if err != sql.ErrNoRows {
-		return 0, err
-	}
-	result, err := r.db.Exec(`INSERT INTO tags (name) VALUES (?)`, name)
-	if err != nil {
-		return 0, err
+	var versions []models.PageVersion
+	for rows.Next() {
+		var v models.PageVersion
+		var diff sql.NullString
+		if err := rows.Scan(&v.ID, &v.PageID, &v.Text, &v.Hash, &diff, &v.FetchedAt); err != nil {
+			return nil, err
+		}
+		v.Diff = diff.String
+		versions = append(versions, v)
 	}
-	return result.LastInsertId()
+	return versions, rows.Err()
 }
 
-func (r *Repository) CreateTag(name string) (int64, error) {
-	name = strings.TrimSpace(strings.ToLower(name))
-	result, err := r.db.Exec(`INSERT INTO tags (name) VALUES (?)`, name)
+func (r *Repository) GetLatestPageVersion(pageID int64) (*models.PageVersion, error) {
+	var v models.PageVersion
+	var diff sql.NullString
+	err := r.db.QueryRow(`
+		SELECT id, page_id, text, hash, diff, fetched_at
+		FROM page_versions
+		WHERE page_id = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, pageID).Scan(&v.ID, &v.PageID, &v.Text, &v.Hash, &diff, &v.FetchedAt)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return result.LastInsertId()
+	v.Diff = diff.String
+	return &v, nil
+}
+
+// AddPageVersion records a new version of a page's text. A version with a
+// diff marks the page as changed; the first version is only a baseline.
+func (r *Repository) AddPageVersion(pageID int64, text, hash, diff string) error {
+	return r.record(models.EntityPage, pageID, models.ActionUpdate, func(tx *txn) error {
+		if _, err := tx.Exec(`INSERT INTO page_versions (page_id, text, hash, diff) VALUES (?, ?, ?, ?)`,
+			pageID, text, hash, nullString(diff)); err != nil {
+			return err
+		}
+		update := `UPDATE pages SET checked_at = CURRENT_TIMESTAMP WHERE id = ?`
+		if diff != "" {
+			update = `UPDATE pages SET checked_at = CURRENT_TIMESTAMP, changed_at = CURRENT_TIMESTAMP WHERE id = ?`
+		}
+		_, err := tx.Exec(update, pageID)
+		return err
+	})
 }
 
-func (r *Repository) DeleteTag(id int64) error {
-	_, err := r.db.Exec(`DELETE FROM tags WHERE id = ?`, id)
+// MarkPageChecked records that a watched page was fetched and found
+// unchanged. Checks happen on every watcher run, so they are not recorded
+// in the activity log, where they would bury the changes.
+func (r *Repository) MarkPageChecked(pageID int64) error {
+	_, err := r.db.Exec(`UPDATE pages SET checked_at = CURRENT_TIMESTAMP WHERE id = ?`, pageID)
 	return err
 }
 
-func (r *Repository) GetSiteTags(siteID int64) ([]models.Tag, error) {
-	rows, err := r.db.Query(`
-		SELECT t.id, t.name FROM tags t
-		JOIN site_tags st ON t.id = st.tag_id
-		WHERE st.site_id = ?
-		ORDER BY t.name
-	`, siteID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// MarkPageVisited records that the page was opened. Like checks, visits are
+// not recorded in the activity log.
+func (r *Repository) MarkPageVisited(pageID int64) error {
+	_, err := r.db.Exec(`UPDATE pages SET visited_at = CURRENT_TIMESTAMP WHERE id = ?`, pageID)
+	return err
+}
 
-	var tags []models.Tag
-	for rows.Next() {
-		var t models.Tag
-		if err := rows.Scan(&t.ID, &t.Name); err != nil {
-			return nil, err
+// ClearPageChanged acknowledges the latest change so the page stops being flagged.
+func (r *Repository) ClearPageChanged(pageID int64) error {
+	return r.record(models.EntityPage, pageID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE pages SET changed_at = NULL WHERE id = ?`, pageID)
+		return err
+	})
+}
+
+// Read later
+
+// ErrInvalidReadStatus is returned for a status outside models.ReadStatuses.
+var ErrInvalidReadStatus = errors.New("invalid read status")
+
+// SetPageReadStatus moves a page to a read-later state and records the
+// transition. An empty status takes the page out of the queue. Pages
+// re-entering the queue go to its back.
+func (r *Repository) SetPageReadStatus(pageID int64, status string) error {
+	return r.record(models.EntityPage, pageID, models.ActionUpdate, func(tx *txn) error {
+		if status != "" && !slices.Contains(models.ReadStatuses, status) {
+			return ErrInvalidReadStatus
 		}
-		tags = append(tags, t)
+
+		result, err := tx.Exec(`
+			UPDATE pages SET
+			    read_status = ?,
+			    read_status_at = CASE WHEN CAST(? AS TEXT) IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END,
+			    queued_at = CASE
+			        WHEN ? IN ('unread', 'reading') AND COALESCE(read_status, '') NOT IN ('unread', 'reading') THEN CURRENT_TIMESTAMP
+			        WHEN CAST(? AS TEXT) IS NULL THEN NULL
+			        ELSE queued_at
+			    END
+			WHERE id = ? AND read_status IS DISTINCT FROM ?
+		`, nullString(status), nullString(status), status, nullString(status), pageID, nullString(status))
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			// Unknown page, or already in that state
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO page_read_events (page_id, status) VALUES (?, ?)`, pageID, nullString(status))
+		return err
+	})
+}
+
+// GetReadingQueue lists unread and in-progress pages, oldest queued first.
+// A limit of zero returns the whole queue.
+func (r *Repository) GetReadingQueue(limit int) ([]models.Page, error) {
+	query := `
+		SELECT ` + pageColumns + `
+		FROM pages p
+		JOIN sites s ON p.site_id = s.id
+		WHERE p.read_status IN ('unread', 'reading') AND p.deleted_at IS NULL
+		ORDER BY p.queued_at, p.id
+	`
+	if limit <= 0 {
+		return r.queryPages(query)
 	}
-	return tags, rows.Err()
+	return r.queryPages(query+" LIMIT ?", limit)
 }
 
-func (r *Repository) GetPageTags(pageID int64) ([]models.Tag, error) {
+// GetPageReadEvents returns a page's read-later history, most recent first.
+func (r *Repository) GetPageReadEvents(pageID int64) ([]models.ReadEvent, error) {
 	rows, err := r.db.Query(`
-		SELECT t.id, t.name FROM tags t
-		JOIN page_tags pt ON t.id = pt.tag_id
-		WHERE pt.page_id = ?
-		ORDER BY t.name
+		SELECT page_id, status, changed_at FROM page_read_events
+		WHERE page_id = ?
+		ORDER BY changed_at DESC, id DESC
 	`, pageID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var tags []models.Tag
+	var events []models.ReadEvent
 	for rows.Next() {
-		var t models.Tag
-		if err := rows.Scan(&t.ID, &t.Name); err != nil {
+		var e models.ReadEvent
+		var status sql.NullString
+		if err := rows.Scan(&e.PageID, &status, &e.ChangedAt); err != nil {
 			return nil, err
 		}
-		tags = append(tags, t)
+		e.Status = status.String
+		events = append(events, e)
 	}
-	return tags, rows.Err()
+	return events, rows.Err()
 }
 
-func (r *Repository) SetSiteTags(siteID int64, tagIDs []int64) error {
-	tx, err := r.db.Begin()
+// Trash
+
+// GetTrashedSites lists sites in the trash, most recently deleted first.
+// Their PageCount is the number of pages deleted along with them.
+func (r *Repository) GetTrashedSites() ([]models.Site, error) {
+	return r.querySites(`SELECT ` + siteColumns + `
+		FROM sites s
+		LEFT JOIN categories c ON s.category_id = c.id
+		WHERE s.deleted_at IS NOT NULL
+		ORDER BY s.deleted_at DESC, s.id DESC`)
+}
+
+// GetTrashedPages lists pages deleted on their own, most recently deleted
+// first. Pages deleted along with their site are listed under the site.
+func (r *Repository) GetTrashedPages() ([]models.Page, error) {
+	return r.queryPages(`SELECT ` + pageColumns + `
+		FROM pages p
+		JOIN sites s ON p.site_id = s.id
+		WHERE p.deleted_at IS NOT NULL AND NOT p.deleted_with_site
+		ORDER BY p.deleted_at DESC, p.id DESC`)
+}
+
+// RestoreSite takes a site and the pages deleted along with it out of the
+// trash. Their tags, notes and other links were never removed.
+func (r *Repository) RestoreSite(id int64) error {
+	return r.record(models.EntitySite, id, models.ActionRestore, func(tx *txn) error {
+		return untrashSite(tx, id, true)
+	})
+}
+
+// untrashSite clears a site's deletion. Without withPages, the pages that
+// went into the trash with the site stay there as individually deleted pages.
+func untrashSite(tx *txn, id int64, withPages bool) error {
+	result, err := tx.Exec(`UPDATE sites SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
 
-	if _, err := tx.Exec(`DELETE FROM site_tags WHERE site_id = ?`, siteID); err != nil {
-		return err
+	pages := `UPDATE pages SET deleted_with_site = FALSE WHERE site_id = ? AND deleted_with_site`
+	if withPages {
+		pages = `UPDATE pages SET deleted_at = NULL, deleted_with_site = FALSE WHERE site_id = ? AND deleted_with_site`
 	}
-	for _, tagID := range tagIDs {
-		if _, err := tx.Exec(`INSERT INTO site_tags (site_id, tag_id) VALUES (?, ?)`, siteID, tagID); err != nil {
+	_, err = tx.Exec(pages, id)
+	return err
+}
+
+// RestorePage takes a page out of the trash. If its site is in the trash
+// too, the site comes back without its other pages, logged as restored in
+// its own right.
+func (r *Repository) RestorePage(id int64) error {
+	return r.record(models.EntityPage, id, models.ActionRestore, func(tx *txn) error {
+		var siteID int64
+		var siteDeleted bool
+		err := tx.QueryRow(`SELECT p.site_id, s.deleted_at IS NOT NULL
+			FROM pages p JOIN sites s ON p.site_id = s.id
+			WHERE p.id = ? AND p.deleted_at IS NOT NULL`, id).Scan(&siteID, &siteDeleted)
+		if err != nil {
 			return err
 		}
+		if siteDeleted {
+			err := r.logChange(tx, models.EntitySite, siteID, models.ActionRestore, func() error {
+				return untrashSite(tx, siteID, false)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`UPDATE pages SET deleted_at = NULL, deleted_with_site = FALSE WHERE id = ?`, id)
+		return err
+	})
+}
+
+// PurgeSite permanently deletes a site in the trash with all of its pages,
+// returning the IDs of the pages removed.
+func (r *Repository) PurgeSite(id int64) ([]int64, error) {
+	purged, pageIDs, err := r.purge(models.EntitySite, `id = ? AND deleted_at IS NOT NULL`, id)
+	if err == nil && len(purged) == 0 {
+		err = sql.ErrNoRows
 	}
-	return tx.Commit()
+	return pageIDs, err
 }
 
-func (r *Repository) SetPageTags(pageID int64, tagIDs []int64) error {
+// PurgePage permanently deletes a page in the trash.
+func (r *Repository) PurgePage(id int64) error {
+	purged, _, err := r.purge(models.EntityPage, `id = ? AND deleted_at IS NOT NULL`, id)
+	if err == nil && len(purged) == 0 {
+		err = sql.ErrNoRows
+	}
+	return err
+}
+
+// PurgeTrash permanently deletes everything that went into the trash before
+// cutoff, returning the IDs of the pages removed.
+func (r *Repository) PurgeTrash(cutoff time.Time) ([]int64, error) {
+	before := r.db.dialect.timestamp(cutoff)
+	_, ids, err := r.purge(models.EntitySite, `deleted_at < ?`, before)
+	if err != nil {
+		return nil, err
+	}
+	_, pageIDs, err := r.purge(models.EntityPage, `deleted_at < ?`, before)
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, pageIDs...), nil
+}
+
+// EmptyTrash permanently deletes everything in the trash, returning the IDs
+// of the pages removed.
+func (r *Repository) EmptyTrash() ([]int64, error) {
+	return r.PurgeTrash(time.Now().Add(time.Second))
+}
+
+// purge deletes the sites or pages matching where and logs each of them,
+// along with every page a site takes with it. It returns their IDs along
+// with the IDs of every page removed, including a site's pages, so callers
+// can clean up files kept for those pages.
+func (r *Repository) purge(entity, where string, arg interface{}) (purged, pageIDs []int64, err error) {
+	table := snapshotTables[entity]
+	pagesQuery := `SELECT id FROM pages WHERE ` + where
+	if entity == models.EntitySite {
+		pagesQuery = `SELECT id FROM pages WHERE site_id IN (SELECT id FROM sites WHERE ` + where + `)`
+	}
+
 	tx, err := r.db.Begin()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer tx.Rollback()
 
-	if _, err := tx.Exec(`DELETE FROM page_tags WHERE page_id = ?`, pageID); err != nil {
-		return err
+	if purged, err = queryIDs(tx, `SELECT id FROM `+table+` WHERE `+where, arg); err != nil {
+		return nil, nil, err
 	}
-	for _, tagID := range tagIDs {
-		if _, err := tx.Exec(`INSERT INTO page_tags (page_id, tag_id) VALUES (?, ?)`, pageID, tagID); err != nil {
-			return err
+	if pageIDs, err = queryIDs(tx, pagesQuery, arg); err != nil {
+		return nil, nil, err
+	}
+	snapshots := make([][]byte, len(purged))
+	for i, id := range purged {
+		if snapshots[i], err = snapshot(tx, entity, id); err != nil {
+			return nil, nil, err
 		}
 	}
-	return tx.Commit()
+	var pageSnapshots [][]byte
+	if entity == models.EntitySite {
+		pageSnapshots = make([][]byte, len(pageIDs))
+		for i, id := range pageIDs {
+			if pageSnapshots[i], err = snapshot(tx, models.EntityPage, id); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM `+table+` WHERE `+where, arg); err != nil {
+		return nil, nil, err
+	}
+	for i, before := range pageSnapshots {
+		if err := r.logEvent(tx, models.EntityPage, pageIDs[i], models.ActionPurge, before, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i, id := range purged {
+		if err := r.logEvent(tx, entity, id, models.ActionPurge, snapshots[i], nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	return purged, pageIDs, tx.Commit()
 }
 
-func (r *Repository) AddSiteTag(siteID, tagID int64) error {
-	_, err := r.db.Exec(`INSERT OR IGNORE INTO site_tags (site_id, tag_id) VALUES (?, ?)`, siteID, tagID)
-	return err
+func queryIDs(q querier, query string, args ...interface{}) ([]int64, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
-func (r *Repository) RemoveSiteTag(siteID, tagID int64) error {
-	_, err := r.db.Exec(`DELETE FROM site_tags WHERE site_id = ? AND tag_id = ?`, siteID, tagID)
+// Favorites, pins and ratings
+
+// ErrInvalidRating is returned for a rating outside 0 to models.MaxRating.
+var ErrInvalidRating = errors.New("rating must be between 0 and 5 stars")
+
+func (r *Repository) SetPageFavorite(id int64, favorite bool) error {
+	return r.record(models.EntityPage, id, models.ActionUpdate, func(tx *txn) error {
+		return setFavorite(tx, "pages", id, favorite)
+	})
+}
+
+func (r *Repository) SetSiteFavorite(id int64, favorite bool) error {
+	return r.record(models.EntitySite, id, models.ActionUpdate, func(tx *txn) error {
+		return setFavorite(tx, "sites", id, favorite)
+	})
+}
+
+// SetPagePinned pins a page to the dashboard or unpins it. Pinning an
+// already pinned page keeps its place.
+func (r *Repository) SetPagePinned(id int64, pinned bool) error {
+	return r.record(models.EntityPage, id, models.ActionUpdate, func(tx *txn) error {
+		return setPinned(tx, "pages", id, pinned)
+	})
+}
+
+func (r *Repository) SetSitePinned(id int64, pinned bool) error {
+	return r.record(models.EntitySite, id, models.ActionUpdate, func(tx *txn) error {
+		return setPinned(tx, "sites", id, pinned)
+	})
+}
+
+// SetPageRating gives a page 1 to 5 stars; zero clears the rating.
+func (r *Repository) SetPageRating(id int64, rating int) error {
+	return r.record(models.EntityPage, id, models.ActionUpdate, func(tx *txn) error {
+		return setRating(tx, "pages", id, rating)
+	})
+}
+
+func (r *Repository) SetSiteRating(id int64, rating int) error {
+	return r.record(models.EntitySite, id, models.ActionUpdate, func(tx *txn) error {
+		return setRating(tx, "sites", id, rating)
+	})
+}
+
+func setFavorite(tx *txn, table string, id int64, favorite bool) error {
+	_, err := tx.Exec(`UPDATE `+table+` SET favorite = ? WHERE id = ?`, favorite, id)
 	return err
 }
 
-func (r *Repository) AddPageTag(pageID, tagID int64) error {
-	_, err := r.db.Exec(`INSERT OR IGNORE INTO page_tags (page_id, tag_id) VALUES (?, ?)`, pageID, tagID)
+func setPinned(tx *txn, table string, id int64, pinned bool) error {
+	_, err := tx.Exec(`UPDATE `+table+`
+		SET pinned_at = CASE WHEN ? THEN COALESCE(pinned_at, CURRENT_TIMESTAMP) END
+		WHERE id = ?`, pinned, id)
 	return err
 }
 
-func (r *Repository) RemovePageTag(pageID, tagID int64) error {
-	_, err := r.db.Exec(`DELETE FROM page_tags WHERE page_id = ? AND tag_id = ?`, pageID, tagID)
+func setRating(tx *txn, table string, id int64, rating int) error {
+	if rating < 0 || rating > models.MaxRating {
+		return ErrInvalidRating
+	}
+	_, err := tx.Exec(`UPDATE `+table+` SET rating = ? WHERE id = ?`, rating, id)
 	return err
 }
 
-// Dashboard
+// Tags
 
-func (r *Repository) GetDashboardStats() (*models.DashboardStats, error) {
-	var stats models.DashboardStats
+func (r *Repository) GetTags() ([]models.Tag, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.name,
+		       (SELECT COUNT(*) FROM site_tags st JOIN sites s ON st.site_id = s.id
+		        WHERE st.tag_id = t.id AND s.deleted_at IS NULL) as site_count,
+		       (SELECT COUNT(*) FROM page_tags pt JOIN pages p ON pt.page_id = p.id
+		        WHERE pt.tag_id = t.id AND p.deleted_at IS NULL) as page_count
+		FROM tags t
+		ORDER BY t.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	r.db.QueryRow(`SELECT COUNT(*) FROM categories`).Scan(&stats.CategoryCount)
-	r.db.QueryRow(`SELECT COUNT(*) FROM sites`).Scan(&stats.SiteCount)
-	r.db.QueryRow(`SELECT COUNT(*) FROM pages`).Scan(&stats.PageCount)
+	var tags []models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.SiteCount, &t.PageCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	pages, err := r.GetPages(nil, nil, nil)
+	aliases, err := r.getAliases()
 	if err != nil {
 		return nil, err
 	}
-	if len(pages) > 10 {
-		pages = pages[:10]
+	for i := range tags {
+		tags[i].Aliases = aliases[tags[i].ID]
 	}
-	stats.RecentPages = pages
+	sortTagTree(tags)
 
-	return &stats, nil
+	return tags, nil
 }
 
-// Search
+// TagListFilter pages through the tags for ListTags.
+type TagListFilter struct {
+	// Sort is SortName, the default, which lists tags as a tree with each
+	// tag's children following it, or SortPageCount, which lists them flat
+	// with a Depth of 0.
+	Sort string
+	// Limit caps the number of tags returned, after skipping Offset of
+	// them. Zero means no limit.
+	Limit  int
+	Offset int
+}
 
-func (r *Repository) Search(query string) ([]models.Site, []models.Page, error) {
-	query = "%" + query + "%"
+// ListTags lists tags like GetTags, a page at a time.
+func (r *Repository) ListTags(filter TagListFilter) ([]models.Tag, error) {
+	d := r.db.dialect
+	// Each tag's depth is the number of its ancestors that exist as tags,
+	// and replacing the separator with one that sorts first puts children
+	// right after their parent, as sortTagTree does.
+	depth := `(SELECT COUNT(*) FROM tags a
+		        WHERE substr(t.name, 1, length(a.name) + 1) = a.name || '` + models.TagSeparator + `')`
+	order := ` ORDER BY REPLACE(t.name, '` + models.TagSeparator + `', ` + d.unitSeparator() + `)` + d.bytewise()
+	if filter.Sort == SortPageCount {
+		depth = `0`
+		order = ` ORDER BY page_count DESC, t.name`
+	}
+	limitClause, limitArgs := limit(filter.Limit, filter.Offset)
 
-	siteRows, err := r.db.Query(`
-		SELECT s.id, s.category_id, COALESCE(c.name, '') as category_name,
-		       s.domain, s.name, s.description, s.created_at,
-		       (SELECT COUNT(*) FROM pages WHERE site_id = s.id) as page_count
-		FROM sites s
-		LEFT JOIN categories c ON s.category_id = c.id
-		WHERE s.domain LIKE ? OR s.name LIKE ? OR s.description LIKE ?
-		ORDER BY s.domain
-		LIMIT 20
-	`, query, query, query)
+	rows, err := r.db.Query(`
+		SELECT t.id, t.name,
+		       (SELECT COUNT(*) FROM site_tags st JOIN sites s ON st.site_id = s.id
+		        WHERE st.tag_id = t.id AND s.deleted_at IS NULL) as site_count,
+		       (SELECT COUNT(*) FROM page_tags pt JOIN pages p ON pt.page_id = p.id
+		        WHERE pt.tag_id = t.id AND p.deleted_at IS NULL) as page_count,
+		       `+depth+`
+		FROM tags t
+	`+order+limitClause, limitArgs...)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	defer siteRows.Close()
+	defer rows.Close()
 
-	var sites []models.Site
-	for siteRows.Next() {
-		var s models.Site
-		var catID sql.NullInt64
-		var name, desc sql.NullString
-		if err := siteRows.Scan(&s.ID, &catID, &s.CategoryName, &s.Domain, &name, &desc, &s.CreatedAt, &s.PageCount); err != nil {
-			return nil, nil, err
-		}
-		if catID.Valid {
-			s.CategoryID = &catID.Int64
+	var tags []models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.SiteCount, &t.PageCount, &t.Depth); err != nil {
+			return nil, err
 		}
-		s.Name = name.String
-		s.Description = desc.String
-		sites = append(sites, s)
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	pageRows, err := r.db.Query(`
-		SELECT p.id, p.site_id, s.domain, p.path, p.title, p.description, p.created_at
-		FROM pages p
-		JOIN sites s ON p.site_id = s.id
-		WHERE p.path LIKE ? OR p.title LIKE ? OR p.description LIKE ?
-		ORDER BY p.created_at DESC
-		LIMIT 20
-	`, query, query, query)
+	aliases, err := r.getAliases()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	defer pageRows.Close()
+	for i := range tags {
+		tags[i].Aliases = aliases[tags[i].ID]
+	}
+	return tags, nil
+}
 
-	var pages []models.Page
-	for pageRows.Next() {
-		var p models.Page
-		var title, desc sql.NullString
-		if err := pageRows.Scan(&p.ID, &p.SiteID, &p.SiteDomain, &p.Path, &title, &desc, &p.CreatedAt); err != nil {
-			return nil, nil, err
+func (r *Repository) CountTags() (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM tags`).Scan(&count)
+	return count, err
+}
+
+// HasSubtags reports whether any tag sits below the tag with id.
+func (r *Repository) HasSubtags(id int64) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`
+		SELECT EXISTS (
+		    SELECT 1 FROM tags parent JOIN tags t
+		        ON substr(t.name, 1, length(parent.name) + 1) = parent.name || '`+models.TagSeparator+`'
+		    WHERE parent.id = ?
+		)
+	`, id).Scan(&exists)
+	return exists, err
+}
+
+// sortTagTree orders tags so that children follow their parent, and sets
+// each tag's depth to the number of its ancestors that exist as tags.
+func sortTagTree(tags []models.Tag) {
+	sort.Slice(tags, func(i, j int) bool {
+		a := strings.Split(tags[i].Name, models.TagSeparator)
+		b := strings.Split(tags[j].Name, models.TagSeparator)
+		return slices.Compare(a, b) < 0
+	})
+
+	var ancestors []models.Tag
+	for i := range tags {
+		for len(ancestors) > 0 && !ancestors[len(ancestors)-1].Includes(tags[i].Name) {
+			ancestors = ancestors[:len(ancestors)-1]
 		}
-		p.Title = title.String
-		p.Description = desc.String
-		pages = append(pages, p)
+		tags[i].Depth = len(ancestors)
+		ancestors = append(ancestors, tags[i])
 	}
+}
 
-	return sites, pages, nil
+func (r *Repository) GetTag(id int64) (*models.Tag, error) {
+	var t models.Tag
+	err := r.db.QueryRow(`
+		SELECT t.id, t.name,
+		       (SELECT COUNT(*) FROM site_tags st JOIN sites s ON st.site_id = s.id
+		        WHERE st.tag_id = t.id AND s.deleted_at IS NULL) as site_count,
+		       (SELECT COUNT(*) FROM page_tags pt JOIN pages p ON pt.page_id = p.id
+		        WHERE pt.tag_id = t.id AND p.deleted_at IS NULL) as page_count
+		FROM tags t WHERE t.id = ?
+	`, id).Scan(&t.ID, &t.Name, &t.SiteCount, &t.PageCount)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := r.GetTagAliases(t.ID)
+	if err != nil {
+		return nil, err
+	}
+	t.Aliases = aliases
+
+	return &t, nil
+}
+
+// GetOrCreateTag resolves a typed tag name, or one of its aliases, to a tag
+// ID, creating the tag if the name is new.
+func (r *Repository) GetOrCreateTag(name string) (int64, error) {
+	name = normalizeTag(name)
+	if name == "" {
+		return 0, ErrTagNameEmpty
+	}
+	id, err := lookupTag(r.db, name)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+	unaliased, err := unaliasParent(r.db, name)
+	if err != nil {
+		return 0, err
+	}
+	if unaliased != name {
+		if id, err := lookupTag(r.db, unaliased); err != sql.ErrNoRows {
+			return id, err
+		}
+	}
+	return r.insertTag(unaliased)
+}
+
+// LookupTag resolves a typed tag name, or one of its aliases, to the ID of
+// an existing tag. It returns sql.ErrNoRows when no tag goes by that name.
+func (r *Repository) LookupTag(name string) (int64, error) {
+	return lookupTag(r.db, normalizeTag(name))
+}
+
+// lookupTag returns the ID of the tag with name as its name or one of its
+// aliases.
+func lookupTag(q querier, name string) (int64, error) {
+	var id int64
+	err := q.QueryRow(`
+		SELECT id FROM tags WHERE name = ?
+		UNION ALL
+		SELECT tag_id FROM tag_aliases WHERE alias = ?
+		LIMIT 1
+	`, name, name).Scan(&id)
+	return id, err
+}
+
+// unaliasParent puts a new tag below the tag its closest ancestor is an
+// alias of, so that "lang/python" becomes "docs/python" once "lang" has
+// been merged into "docs".
+func unaliasParent(q querier, name string) (string, error) {
+	for i := strings.LastIndex(name, models.TagSeparator); i > 0; i = strings.LastIndex(name[:i], models.TagSeparator) {
+		var tagName string
+		var alias bool
+		err := q.QueryRow(`
+			SELECT name, FALSE FROM tags WHERE name = ?
+			UNION ALL
+			SELECT t.name, TRUE FROM tag_aliases a JOIN tags t ON a.tag_id = t.id WHERE a.alias = ?
+			LIMIT 1
+		`, name[:i], name[:i]).Scan(&tagName, &alias)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil || !alias {
+			return name, err
+		}
+		return tagName + name[i:], nil
+	}
+	return name, nil
+}
+
+func (r *Repository) CreateTag(name string) (int64, error) {
+	name = normalizeTag(name)
+	if name == "" {
+		return 0, ErrTagNameEmpty
+	}
+	name, err := unaliasParent(r.db, name)
+	if err != nil {
+		return 0, err
+	}
+	if taken, err := r.tagNameTaken(r.db, name, 0); err != nil {
+		return 0, err
+	} else if taken {
+		return 0, ErrTagExists
+	}
+	return r.insertTag(name)
+}
+
+// insertTag adds a tag along with any missing ancestors, so that "lang/go"
+// always has a "lang" to be filtered by.
+func (r *Repository) insertTag(name string) (int64, error) {
+	return r.recordCreate(models.EntityTag, func(tx *txn) (int64, error) {
+		if err := createTagAncestors(tx, name); err != nil {
+			return 0, err
+		}
+		var id int64
+		if err := tx.QueryRow(`INSERT INTO tags (name) VALUES (?) RETURNING id`, name).Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, nil
+	})
+}
+
+// createTagAncestors inserts each parent of name that is not already a tag
+// or an alias.
+func createTagAncestors(q querier, name string) error {
+	for i := strings.LastIndex(name, models.TagSeparator); i > 0; i = strings.LastIndex(name, models.TagSeparator) {
+		name = name[:i]
+		if _, err := q.Exec(`
+			INSERT INTO tags (name) SELECT CAST(? AS TEXT)
+			WHERE NOT EXISTS (SELECT 1 FROM tags WHERE name = ?)
+			  AND NOT EXISTS (SELECT 1 FROM tag_aliases WHERE alias = ?)
+		`, name, name, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTag removes a tag from every site and page and deletes it,
+// returning what RestoreTag needs to bring it back.
+func (r *Repository) DeleteTag(id int64) (*models.DeletedTag, error) {
+	deleted := &models.DeletedTag{ID: id}
+	err := r.record(models.EntityTag, id, models.ActionDelete, func(tx *txn) error {
+		err := tx.QueryRow(`SELECT name FROM tags WHERE id = ?`, id).Scan(&deleted.Name)
+		if err != nil {
+			return err
+		}
+		if deleted.Aliases, err = queryStrings(tx, `SELECT alias FROM tag_aliases WHERE tag_id = ? ORDER BY alias`, id); err != nil {
+			return err
+		}
+		if deleted.SiteIDs, err = queryIDs(tx, `SELECT site_id FROM site_tags WHERE tag_id = ?`, id); err != nil {
+			return err
+		}
+		if deleted.PageIDs, err = queryIDs(tx, `SELECT page_id FROM page_tags WHERE tag_id = ?`, id); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM tags WHERE id = ?`, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// RestoreTag recreates a deleted tag, with its aliases and its links to the
+// sites and pages that still exist. The tag gets a new ID, as SQLite may
+// have handed its old one to a tag created since. It fails with
+// ErrTagExists if the name has been taken since.
+func (r *Repository) RestoreTag(deleted *models.DeletedTag) error {
+	_, err := r.recordInsert(models.EntityTag, models.ActionRestore, func(tx *txn) (int64, error) {
+		if taken, err := r.tagNameTaken(tx, deleted.Name, 0); err != nil {
+			return 0, err
+		} else if taken {
+			return 0, ErrTagExists
+		}
+		var id int64
+		if err := tx.QueryRow(`INSERT INTO tags (name) VALUES (?) RETURNING id`, deleted.Name).Scan(&id); err != nil {
+			return 0, err
+		}
+		for _, alias := range deleted.Aliases {
+			if _, err := tx.Exec(`INSERT INTO tag_aliases (alias, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, alias, id); err != nil {
+				return 0, err
+			}
+		}
+		for _, siteID := range deleted.SiteIDs {
+			if _, err := tx.Exec(`INSERT INTO site_tags (site_id, tag_id) SELECT id, CAST(? AS BIGINT) FROM sites WHERE id = ?`,
+				id, siteID); err != nil {
+				return 0, err
+			}
+		}
+		for _, pageID := range deleted.PageIDs {
+			if _, err := tx.Exec(`INSERT INTO page_tags (page_id, tag_id) SELECT id, CAST(? AS BIGINT) FROM pages WHERE id = ?`,
+				id, pageID); err != nil {
+				return 0, err
+			}
+		}
+		return id, nil
+	})
+	return err
+}
+
+// ErrTagExists is returned when a tag name or alias is already in use.
+var ErrTagExists = errors.New("a tag or alias with that name already exists")
+
+// ErrTagCycle is returned when a tag would be renamed below itself.
+var ErrTagCycle = errors.New("a tag cannot be moved below itself")
+
+// ErrTagNameEmpty is returned for a tag name or alias with nothing left
+// once normalized, such as "/" or " / ".
+var ErrTagNameEmpty = errors.New("a tag name needs more than separators and spaces")
+
+// RenameTag changes a tag's name. The old name is kept as an alias so that
+// typing it still finds the tag. Child tags move along with their parent.
+func (r *Repository) RenameTag(id int64, name string) error {
+	return r.record(models.EntityTag, id, models.ActionUpdate, func(tx *txn) error {
+		name = normalizeTag(name)
+		if name == "" {
+			return ErrTagNameEmpty
+		}
+
+		var oldName string
+		if err := tx.QueryRow(`SELECT name FROM tags WHERE id = ?`, id).Scan(&oldName); err != nil {
+			return err
+		}
+		if name == oldName {
+			return nil
+		}
+		if strings.HasPrefix(name, oldName+models.TagSeparator) {
+			return ErrTagCycle
+		}
+
+		renames, err := childTagRenames(tx, oldName, name)
+		if err != nil {
+			return err
+		}
+		renames[id] = [2]string{oldName, name}
+		if err := r.renameTags(tx, renames); err != nil {
+			return err
+		}
+		if err := createTagAncestors(tx, name); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// childTagRenames returns the old and new names, by tag ID, of the tags
+// below oldName when it is renamed to name.
+func childTagRenames(q querier, oldName, name string) (map[int64][2]string, error) {
+	renames := map[int64][2]string{}
+	prefix := oldName + models.TagSeparator
+	rows, err := q.Query(`SELECT id, name FROM tags WHERE substr(name, 1, ?) = ?`,
+		utf8.RuneCountInString(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var childID int64
+		var childName string
+		if err := rows.Scan(&childID, &childName); err != nil {
+			return nil, err
+		}
+		renames[childID] = [2]string{childName, name + strings.TrimPrefix(childName, oldName)}
+	}
+	return renames, rows.Err()
+}
+
+// renameTags gives each tag its new name, keeping the old one as an alias.
+// It fails with ErrTagExists, renaming nothing, if a new name is taken.
+func (r *Repository) renameTags(tx querier, renames map[int64][2]string) error {
+	for tagID, names := range renames {
+		if taken, err := r.tagNameTaken(tx, names[1], tagID); err != nil {
+			return err
+		} else if taken {
+			return ErrTagExists
+		}
+	}
+
+	for tagID, names := range renames {
+		// Renaming to one of the tag's own aliases promotes it
+		if _, err := tx.Exec(`DELETE FROM tag_aliases WHERE alias = ?`, names[1]); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE tags SET name = ? WHERE id = ?`, names[1], tagID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO tag_aliases (alias, tag_id) VALUES (?, ?)`, names[0], tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeTags moves every site and page tagged with sourceID over to targetID,
+// then deletes the source tag. Its name and aliases become aliases of the
+// target. Items that already carry both tags keep a single link. Tags below
+// the source move below the target, and one whose name is taken there is
+// merged in turn into the tag that has it.
+func (r *Repository) MergeTags(sourceID, targetID int64) error {
+	if sourceID == targetID {
+		return errors.New("cannot merge a tag into itself")
+	}
+
+	// Logged as the source going away and the target gaining its aliases
+	return r.record(models.EntityTag, targetID, models.ActionUpdate, func(tx *txn) error {
+		return r.logChange(tx, models.EntityTag, sourceID, models.ActionMerge, func() error {
+			return r.mergeTags(tx, sourceID, targetID)
+		})
+	})
+}
+
+func (r *Repository) mergeTags(tx *txn, sourceID, targetID int64) error {
+	var sourceName string
+	if err := tx.QueryRow(`SELECT name FROM tags WHERE id = ?`, sourceID).Scan(&sourceName); err != nil {
+		return err
+	}
+	var targetName string
+	if err := tx.QueryRow(`SELECT name FROM tags WHERE id = ?`, targetID).Scan(&targetName); err != nil {
+		return err
+	}
+	if strings.HasPrefix(targetName, sourceName+models.TagSeparator) {
+		return ErrTagCycle
+	}
+
+	if err := r.moveChildTags(tx, sourceName, targetName); err != nil {
+		return err
+	}
+
+	statements := []string{
+		`INSERT INTO site_tags (site_id, tag_id) SELECT site_id, CAST(? AS BIGINT) FROM site_tags WHERE tag_id = ?
+		 ON CONFLICT DO NOTHING`,
+		`INSERT INTO page_tags (page_id, tag_id) SELECT page_id, CAST(? AS BIGINT) FROM page_tags WHERE tag_id = ?
+		 ON CONFLICT DO NOTHING`,
+		`UPDATE tag_aliases SET tag_id = ? WHERE tag_id = ?`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, targetID, sourceID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM tags WHERE id = ?`, sourceID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO tag_aliases (alias, tag_id) VALUES (?, ?)`, sourceName, targetID)
+	return err
+}
+
+// moveChildTags moves the tags below sourceName to below targetName. A
+// child whose new name is already taken is merged into the tag that has it,
+// which takes care of the child's own children.
+func (r *Repository) moveChildTags(tx *txn, sourceName, targetName string) error {
+	renames, err := childTagRenames(tx, sourceName, targetName)
+	if err != nil {
+		return err
+	}
+	for _, childID := range byOldName(renames) {
+		names := renames[childID]
+		var name string
+		err := tx.QueryRow(`SELECT name FROM tags WHERE id = ?`, childID).Scan(&name)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && name != names[0]) {
+			// Already moved or merged along with its parent
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		existingID, err := lookupTag(tx, names[1])
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && existingID == childID) {
+			if err := r.renameTags(tx, map[int64][2]string{childID: names}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = r.logChange(tx, models.EntityTag, existingID, models.ActionUpdate, func() error {
+			return r.logChange(tx, models.EntityTag, childID, models.ActionMerge, func() error {
+				return r.mergeTags(tx, childID, existingID)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// byOldName returns the IDs of renamed tags with parents before their
+// children.
+func byOldName(renames map[int64][2]string) []int64 {
+	ids := make([]int64, 0, len(renames))
+	for id := range renames {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return renames[ids[i]][0] < renames[ids[j]][0] })
+	return ids
+}
+
+func (r *Repository) GetTagAliases(tagID int64) ([]string, error) {
+	rows, err := r.db.Query(`SELECT alias FROM tag_aliases WHERE tag_id = ? ORDER BY alias`, tagID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []string
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+func (r *Repository) AddTagAlias(tagID int64, alias string) error {
+	return r.record(models.EntityTag, tagID, models.ActionUpdate, func(tx *txn) error {
+		alias = normalizeTag(alias)
+		if alias == "" {
+			return ErrTagNameEmpty
+		}
+		if taken, err := r.tagNameTaken(tx, alias, 0); err != nil {
+			return err
+		} else if taken {
+			return ErrTagExists
+		}
+		_, err := tx.Exec(`INSERT INTO tag_aliases (alias, tag_id) VALUES (?, ?)`, alias, tagID)
+		return err
+	})
+}
+
+func (r *Repository) DeleteTagAlias(tagID int64, alias string) error {
+	return r.record(models.EntityTag, tagID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`DELETE FROM tag_aliases WHERE tag_id = ? AND alias = ?`, tagID, alias)
+		return err
+	})
+}
+
+// getAliases returns all aliases keyed by tag ID.
+func (r *Repository) getAliases() (map[int64][]string, error) {
+	rows, err := r.db.Query(`SELECT tag_id, alias FROM tag_aliases ORDER BY alias`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := map[int64][]string{}
+	for rows.Next() {
+		var tagID int64
+		var alias string
+		if err := rows.Scan(&tagID, &alias); err != nil {
+			return nil, err
+		}
+		aliases[tagID] = append(aliases[tagID], alias)
+	}
+	return aliases, rows.Err()
+}
+
+// tagNameTaken reports whether name is used by a tag other than exceptID or
+// by an alias of one.
+func (r *Repository) tagNameTaken(q querier, name string, exceptID int64) (bool, error) {
+	var taken bool
+	err := q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM tags WHERE name = ? AND id != ?)
+		    OR EXISTS (SELECT 1 FROM tag_aliases WHERE alias = ? AND tag_id != ?)
+	`, name, exceptID, name, exceptID).Scan(&taken)
+	return taken, err
+}
+
+func (r *Repository) GetSiteTags(siteID int64) ([]models.Tag, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.name FROM tags t
+		JOIN site_tags st ON t.id = st.tag_id
+		WHERE st.site_id = ?
+		ORDER BY t.name
+	`, siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func (r *Repository) GetPageTags(pageID int64) ([]models.Tag, error) {
+	rows, err := r.db.Query(`
+		SELECT t.id, t.name FROM tags t
+		JOIN page_tags pt ON t.id = pt.tag_id
+		WHERE pt.page_id = ?
+		ORDER BY t.name
+	`, pageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func (r *Repository) SetSiteTags(siteID int64, tagIDs []int64) error {
+	return r.record(models.EntitySite, siteID, models.ActionUpdate, func(tx *txn) error {
+		if _, err := tx.Exec(`DELETE FROM site_tags WHERE site_id = ?`, siteID); err != nil {
+			return err
+		}
+		for _, tagID := range tagIDs {
+			if _, err := tx.Exec(`INSERT INTO site_tags (site_id, tag_id) VALUES (?, ?)`, siteID, tagID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Repository) SetPageTags(pageID int64, tagIDs []int64) error {
+	return r.record(models.EntityPage, pageID, models.ActionUpdate, func(tx *txn) error {
+		if _, err := tx.Exec(`DELETE FROM page_tags WHERE page_id = ?`, pageID); err != nil {
+			return err
+		}
+		for _, tagID := range tagIDs {
+			if _, err := tx.Exec(`INSERT INTO page_tags (page_id, tag_id) VALUES (?, ?)`, pageID, tagID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Repository) AddSiteTag(siteID, tagID int64) error {
+	return r.record(models.EntitySite, siteID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`INSERT INTO site_tags (site_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, siteID, tagID)
+		return err
+	})
+}
+
+func (r *Repository) RemoveSiteTag(siteID, tagID int64) error {
+	return r.record(models.EntitySite, siteID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`DELETE FROM site_tags WHERE site_id = ? AND tag_id = ?`, siteID, tagID)
+		return err
+	})
+}
+
+func (r *Repository) AddPageTag(pageID, tagID int64) error {
+	return r.record(models.EntityPage, pageID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`INSERT INTO page_tags (page_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, pageID, tagID)
+		return err
+	})
+}
+
+func (r *Repository) RemovePageTag(pageID, tagID int64) error {
+	return r.record(models.EntityPage, pageID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`DELETE FROM page_tags WHERE page_id = ? AND tag_id = ?`, pageID, tagID)
+		return err
+	})
+}
+
+// Saved searches
+
+func (r *Repository) GetSavedSearches() ([]models.SavedSearch, error) {
+	rows, err := r.db.Query(`SELECT id, name, query, created_at FROM saved_searches ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var searches []models.SavedSearch
+	for rows.Next() {
+		var ss models.SavedSearch
+		if err := rows.Scan(&ss.ID, &ss.Name, &ss.Query, &ss.CreatedAt); err != nil {
+			return nil, err
+		}
+		searches = append(searches, ss)
+	}
+	return searches, rows.Err()
+}
+
+func (r *Repository) GetSavedSearch(id int64) (*models.SavedSearch, error) {
+	var ss models.SavedSearch
+	err := r.db.QueryRow(`SELECT id, name, query, created_at FROM saved_searches WHERE id = ?`, id).
+		Scan(&ss.ID, &ss.Name, &ss.Query, &ss.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+func (r *Repository) CreateSavedSearch(name, query string) (int64, error) {
+	return r.recordCreate(models.EntitySavedSearch, func(tx *txn) (int64, error) {
+		var id int64
+		err := tx.QueryRow(`INSERT INTO saved_searches (name, query) VALUES (?, ?) RETURNING id`, name, query).Scan(&id)
+		return id, err
+	})
+}
+
+func (r *Repository) DeleteSavedSearch(id int64) error {
+	return r.record(models.EntitySavedSearch, id, models.ActionDelete, func(tx *txn) error {
+		_, err := tx.Exec(`DELETE FROM saved_searches WHERE id = ?`, id)
+		return err
+	})
+}
+
+// Notes
+
+// GetPageNote returns the note on a page, or sql.ErrNoRows if it has none.
+func (r *Repository) GetPageNote(pageID int64) (*models.Note, error) {
+	return scanNote(r.db.QueryRow(`SELECT `+noteColumns+` FROM notes WHERE page_id = ?`, pageID))
+}
+
+// GetSiteNote returns the note on a site, or sql.ErrNoRows if it has none.
+func (r *Repository) GetSiteNote(siteID int64) (*models.Note, error) {
+	return scanNote(r.db.QueryRow(`SELECT `+noteColumns+` FROM notes WHERE site_id = ?`, siteID))
+}
+
+// GetNotes returns every note, for exports.
+func (r *Repository) GetNotes() ([]models.Note, error) {
+	rows, err := r.db.Query(`SELECT ` + noteColumns + ` FROM notes ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *n)
+	}
+	return notes, rows.Err()
+}
+
+func (r *Repository) SavePageNote(pageID int64, body string) error {
+	return r.record(models.EntityPage, pageID, models.ActionUpdate, func(tx *txn) error {
+		return saveNote(tx, "page_id", pageID, body)
+	})
+}
+
+func (r *Repository) SaveSiteNote(siteID int64, body string) error {
+	return r.record(models.EntitySite, siteID, models.ActionUpdate, func(tx *txn) error {
+		return saveNote(tx, "site_id", siteID, body)
+	})
+}
+
+// saveNote writes the note owned through column and keeps the new body as a
+// revision. Saving an unchanged body does nothing.
+func saveNote(tx *txn, column string, ownerID int64, body string) error {
+	var noteID int64
+	var current string
+	err := tx.QueryRow(`SELECT id, body FROM notes WHERE `+column+` = ?`, ownerID).Scan(&noteID, &current)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err := tx.QueryRow(`INSERT INTO notes (`+column+`, body) VALUES (?, ?) RETURNING id`, ownerID, body).Scan(&noteID)
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case current == body:
+		return nil
+	default:
+		if _, err := tx.Exec(`UPDATE notes SET body = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, body, noteID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO note_revisions (note_id, body) VALUES (?, ?)`, noteID, body)
+	return err
+}
+
+// GetNoteRevisions lists a note's saved versions, newest first.
+func (r *Repository) GetNoteRevisions(noteID int64) ([]models.NoteRevision, error) {
+	rows, err := r.db.Query(`
+		SELECT id, note_id, body, created_at FROM note_revisions
+		WHERE note_id = ?
+		ORDER BY created_at DESC, id DESC
+	`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.NoteRevision
+	for rows.Next() {
+		var rev models.NoteRevision
+		if err := rows.Scan(&rev.ID, &rev.NoteID, &rev.Body, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// RestoreNoteRevision saves an earlier version of a note as its current
+// body and returns the note.
+func (r *Repository) RestoreNoteRevision(revisionID int64) (*models.Note, error) {
+	var noteID int64
+	var body string
+	err := r.db.QueryRow(`SELECT note_id, body FROM note_revisions WHERE id = ?`, revisionID).Scan(&noteID, &body)
+	if err != nil {
+		return nil, err
+	}
+	note, err := scanNote(r.db.QueryRow(`SELECT `+noteColumns+` FROM notes WHERE id = ?`, noteID))
+	if err != nil {
+		return nil, err
+	}
+
+	if note.PageID != nil {
+		err = r.SavePageNote(*note.PageID, body)
+	} else {
+		err = r.SaveSiteNote(*note.SiteID, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	note.Body = body
+	return note, nil
+}
+
+const noteColumns = `id, site_id, page_id, body, updated_at`
+
+func scanNote(row scanner) (*models.Note, error) {
+	var n models.Note
+	var siteID, pageID sql.NullInt64
+	if err := row.Scan(&n.ID, &siteID, &pageID, &n.Body, &n.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if siteID.Valid {
+		n.SiteID = &siteID.Int64
+	}
+	if pageID.Valid {
+		n.PageID = &pageID.Int64
+	}
+	return &n, nil
+}
+
+// Highlights
+
+const highlightColumns = `h.id, h.page_id, COALESCE(p.title, ''), s.domain, p.path,
+	h.text, h.comment, h.source, h.created_at`
+
+const highlightJoins = `FROM highlights h
+	JOIN pages p ON h.page_id = p.id
+	JOIN sites s ON p.site_id = s.id`
+
+// GetHighlights lists highlights across all pages, newest first. A query
+// matches the passage, the comment or the page title.
+func (r *Repository) GetHighlights(query string) ([]models.Highlight, error) {
+	q := `SELECT ` + highlightColumns + ` ` + highlightJoins + ` WHERE p.deleted_at IS NULL`
+	var args []interface{}
+	if query != "" {
+		var search alternatives
+		search.add(r.db.dialect.matches("h.text || ' ' || h.comment", query))
+		search.add(r.db.dialect.contains("p.title", query))
+		condition, searchArgs := search.where()
+		q += ` AND ` + condition
+		args = append(args, searchArgs...)
+	}
+	q += ` ORDER BY h.created_at DESC, h.id DESC`
+	return r.queryHighlights(q, args...)
+}
+
+// GetPageHighlights lists a page's highlights in the order they were made.
+func (r *Repository) GetPageHighlights(pageID int64) ([]models.Highlight, error) {
+	return r.queryHighlights(`SELECT `+highlightColumns+` `+highlightJoins+`
+		WHERE h.page_id = ?
+		ORDER BY h.created_at, h.id`, pageID)
+}
+
+func (r *Repository) GetHighlight(id int64) (*models.Highlight, error) {
+	return scanHighlight(r.db.QueryRow(`SELECT `+highlightColumns+` `+highlightJoins+` WHERE h.id = ?`, id))
+}
+
+func (r *Repository) CreateHighlight(pageID int64, text, comment, source string) (int64, error) {
+	return r.recordCreate(models.EntityHighlight, func(tx *txn) (int64, error) {
+		var id int64
+		err := tx.QueryRow(`INSERT INTO highlights (page_id, text, comment, source) VALUES (?, ?, ?, ?) RETURNING id`,
+			pageID, text, comment, source).Scan(&id)
+		return id, err
+	})
+}
+
+func (r *Repository) UpdateHighlightComment(id int64, comment string) error {
+	return r.record(models.EntityHighlight, id, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE highlights SET comment = ? WHERE id = ?`, comment, id)
+		return err
+	})
+}
+
+func (r *Repository) DeleteHighlight(id int64) error {
+	return r.record(models.EntityHighlight, id, models.ActionDelete, func(tx *txn) error {
+		_, err := tx.Exec(`DELETE FROM highlights WHERE id = ?`, id)
+		return err
+	})
+}
+
+func (r *Repository) queryHighlights(query string, args ...interface{}) ([]models.Highlight, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var highlights []models.Highlight
+	for rows.Next() {
+		h, err := scanHighlight(rows)
+		if err != nil {
+			return nil, err
+		}
+		highlights = append(highlights, *h)
+	}
+	return highlights, rows.Err()
+}
+
+func scanHighlight(row scanner) (*models.Highlight, error) {
+	var h models.Highlight
+	err := row.Scan(&h.ID, &h.PageID, &h.PageTitle, &h.SiteDomain, &h.PagePath,
+		&h.Text, &h.Comment, &h.Source, &h.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Collections
+
+func (r *Repository) GetCollections() ([]models.Collection, error) {
+	rows, err := r.db.Query(`
+		SELECT c.id, c.name, c.description, c.share_token, c.created_at,
+		       (SELECT COUNT(*) FROM collection_items WHERE collection_id = c.id AND ` + liveCollectionItem + `) as item_count
+		FROM collections c
+		ORDER BY c.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []models.Collection
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, *c)
+	}
+	return collections, rows.Err()
+}
+
+// GetCollection returns a collection with its items in order.
+func (r *Repository) GetCollection(id int64) (*models.Collection, error) {
+	return r.getCollection(`c.id = ?`, id)
+}
+
+// GetSharedCollection looks up a collection by its share token.
+func (r *Repository) GetSharedCollection(token string) (*models.Collection, error) {
+	return r.getCollection(`c.share_token = ?`, token)
+}
+
+func (r *Repository) getCollection(condition string, arg interface{}) (*models.Collection, error) {
+	c, err := scanCollection(r.db.QueryRow(`
+		SELECT c.id, c.name, c.description, c.share_token, c.created_at,
+		       (SELECT COUNT(*) FROM collection_items WHERE collection_id = c.id AND `+liveCollectionItem+`) as item_count
+		FROM collections c
+		WHERE `+condition, arg))
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := r.GetCollectionItems(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	c.Items = items
+
+	return c, nil
+}
+
+func (r *Repository) CreateCollection(name, description string) (int64, error) {
+	return r.recordCreate(models.EntityCollection, func(tx *txn) (int64, error) {
+		var id int64
+		err := tx.QueryRow(`INSERT INTO collections (name, description) VALUES (?, ?) RETURNING id`, name, description).Scan(&id)
+		return id, err
+	})
+}
+
+func (r *Repository) UpdateCollection(id int64, name, description string) error {
+	return r.record(models.EntityCollection, id, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE collections SET name = ?, description = ? WHERE id = ?`, name, description, id)
+		return err
+	})
+}
+
+func (r *Repository) DeleteCollection(id int64) error {
+	return r.record(models.EntityCollection, id, models.ActionDelete, func(tx *txn) error {
+		_, err := tx.Exec(`DELETE FROM collections WHERE id = ?`, id)
+		return err
+	})
+}
+
+// SetCollectionShareToken publishes a collection under token, or stops
+// sharing it when token is empty.
+func (r *Repository) SetCollectionShareToken(id int64, token string) error {
+	return r.record(models.EntityCollection, id, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE collections SET share_token = ? WHERE id = ?`, nullString(token), id)
+		return err
+	})
+}
+
+func (r *Repository) GetCollectionItems(collectionID int64) ([]models.CollectionItem, error) {
+	rows, err := r.db.Query(`
+		SELECT id, collection_id, site_id, page_id, position, note, created_at
+		FROM collection_items
+		WHERE collection_id = ? AND `+liveCollectionItem+`
+		ORDER BY position, id
+	`, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type itemRef struct {
+		item           models.CollectionItem
+		siteID, pageID sql.NullInt64
+	}
+	var refs []itemRef
+	for rows.Next() {
+		var ref itemRef
+		var note sql.NullString
+		if err := rows.Scan(&ref.item.ID, &ref.item.CollectionID, &ref.siteID, &ref.pageID,
+			&ref.item.Position, &note, &ref.item.CreatedAt); err != nil {
+			return nil, err
+		}
+		ref.item.Note = note.String
+		refs = append(refs, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	items := make([]models.CollectionItem, 0, len(refs))
+	for _, ref := range refs {
+		var err error
+		if ref.siteID.Valid {
+			ref.item.Site, err = r.GetSite(ref.siteID.Int64)
+		} else {
+			ref.item.Page, err = r.GetPage(ref.pageID.Int64)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, ref.item)
+	}
+	return items, nil
+}
+
+// liveCollectionItem leaves out collection items whose site or page is in
+// the trash.
+const liveCollectionItem = `(site_id IS NULL OR site_id IN (SELECT id FROM sites WHERE deleted_at IS NULL))
+		AND (page_id IS NULL OR page_id IN (SELECT id FROM pages WHERE deleted_at IS NULL))`
+
+// AddCollectionItem appends a site or a page, whichever is non-nil, to the
+// end of a collection.
+func (r *Repository) AddCollectionItem(collectionID int64, siteID, pageID *int64, note string) (int64, error) {
+	var id int64
+	err := r.record(models.EntityCollection, collectionID, models.ActionUpdate, func(tx *txn) error {
+		return tx.QueryRow(`
+			INSERT INTO collection_items (collection_id, site_id, page_id, position, note)
+			VALUES (?, ?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM collection_items WHERE collection_id = ?), ?)
+			RETURNING id
+		`, collectionID, nullInt64(siteID), nullInt64(pageID), collectionID, nullString(note)).Scan(&id)
+	})
+	return id, err
+}
+
+func (r *Repository) UpdateCollectionItemNote(collectionID, itemID int64, note string) error {
+	return r.record(models.EntityCollection, collectionID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE collection_items SET note = ? WHERE id = ? AND collection_id = ?`,
+			nullString(note), itemID, collectionID)
+		return err
+	})
+}
+
+func (r *Repository) RemoveCollectionItem(collectionID, itemID int64) error {
+	return r.record(models.EntityCollection, collectionID, models.ActionUpdate, func(tx *txn) error {
+		_, err := tx.Exec(`DELETE FROM collection_items WHERE id = ? AND collection_id = ?`, itemID, collectionID)
+		return err
+	})
+}
+
+// ReorderCollection gives the listed items positions in the order given.
+// Items of the collection that are not listed keep their old position.
+func (r *Repository) ReorderCollection(collectionID int64, itemIDs []int64) error {
+	return r.record(models.EntityCollection, collectionID, models.ActionUpdate, func(tx *txn) error {
+		for position, itemID := range itemIDs {
+			if _, err := tx.Exec(`UPDATE collection_items SET position = ? WHERE id = ? AND collection_id = ?`,
+				position, itemID, collectionID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func scanCollection(row scanner) (*models.Collection, error) {
+	var c models.Collection
+	var desc, token sql.NullString
+	if err := row.Scan(&c.ID, &c.Name, &desc, &token, &c.CreatedAt, &c.ItemCount); err != nil {
+		return nil, err
+	}
+	c.Description = desc.String
+	c.ShareToken = token.String
+	return &c, nil
+}
+
+// Dashboard
+
+func (r *Repository) GetDashboardStats() (*models.DashboardStats, error) {
+	var stats models.DashboardStats
+
+	r.db.QueryRow(`SELECT COUNT(*) FROM categories`).Scan(&stats.CategoryCount)
+	r.db.QueryRow(`SELECT COUNT(*) FROM sites WHERE deleted_at IS NULL`).Scan(&stats.SiteCount)
+	r.db.QueryRow(`SELECT COUNT(*) FROM pages WHERE deleted_at IS NULL`).Scan(&stats.PageCount)
+
+	pages, err := r.GetPages(PageFilter{Limit: 10})
+	if err != nil {
+		return nil, err
+	}
+	stats.RecentPages = pages
+
+	r.db.QueryRow(`SELECT COUNT(*) FROM pages WHERE read_status IN ('unread', 'reading') AND deleted_at IS NULL`).Scan(&stats.QueueCount)
+	queue, err := r.GetReadingQueue(5)
+	if err != nil {
+		return nil, err
+	}
+	stats.ReadingQueue = queue
+
+	if stats.PinnedSites, err = r.GetSites(SiteFilter{Pinned: true, Sort: SortPinned}); err != nil {
+		return nil, err
+	}
+	if stats.PinnedPages, err = r.GetPages(PageFilter{Pinned: true, Sort: SortPinned}); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Search
+
+func (r *Repository) Search(query string) ([]models.Site, []models.Page, error) {
+	siteSearch, siteArgs := r.db.dialect.siteSearch(query).where()
+	siteRows, err := r.db.Query(`
+		SELECT `+siteColumns+`
+		FROM sites s
+		LEFT JOIN categories c ON s.category_id = c.id
+		WHERE s.deleted_at IS NULL AND `+siteSearch+`
+		ORDER BY s.domain
+		LIMIT 20
+	`, siteArgs...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer siteRows.Close()
+
+	var sites []models.Site
+	for siteRows.Next() {
+		s, err := scanSite(siteRows)
+		if err != nil {
+			return nil, nil, err
+		}
+		sites = append(sites, *s)
+	}
+
+	pageSearch, pageArgs := r.db.dialect.pageSearch(query).where()
+	pageRows, err := r.db.Query(`
+		SELECT `+pageColumns+`
+		FROM pages p
+		JOIN sites s ON p.site_id = s.id
+		WHERE p.deleted_at IS NULL AND `+pageSearch+`
+		ORDER BY p.created_at DESC
+		LIMIT 20
+	`, pageArgs...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pageRows.Close()
+
+	var pages []models.Page
+	for pageRows.Next() {
+		p, err := scanPage(pageRows)
+		if err != nil {
+			return nil, nil, err
+		}
+		pages = append(pages, *p)
+	}
+
+	return sites, pages, nil
+}
+
+// Activity
+
+// snapshotTables maps each entity in the activity log to its table.
+var snapshotTables = map[string]string{
+	models.EntityCategory:    "categories",
+	models.EntitySite:        "sites",
+	models.EntityPage:        "pages",
+	models.EntityTag:         "tags",
+	models.EntitySavedSearch: "saved_searches",
+	models.EntityHighlight:   "highlights",
+	models.EntityCollection:  "collections",
+}
+
+// EventFilter narrows down the activity log. Zero values match everything.
+type EventFilter struct {
+	Entity   string
+	EntityID int64
+	Action   string
+	Actor    string
+	Limit    int
+}
+
+// GetEvents lists logged changes, most recent first.
+func (r *Repository) GetEvents(filter EventFilter) ([]models.Event, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Entity != "" {
+		conditions = append(conditions, "entity = ?")
+		args = append(args, filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		conditions = append(conditions, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+
+	query := `SELECT id, entity, entity_id, action, before, after, actor, created_at FROM events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		var e models.Event
+		var before, after sql.NullString
+		if err := rows.Scan(&e.ID, &e.Entity, &e.EntityID, &e.Action, &before, &after, &e.Actor, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			if err := json.Unmarshal([]byte(before.String), &e.Before); err != nil {
+				return nil, err
+			}
+		}
+		if after.Valid {
+			if err := json.Unmarshal([]byte(after.String), &e.After); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// GetEventActors lists everyone changes have been attributed to.
+func (r *Repository) GetEventActors() ([]string, error) {
+	rows, err := r.db.Query(`SELECT DISTINCT actor FROM events ORDER BY actor`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actors []string
+	for rows.Next() {
+		var actor string
+		if err := rows.Scan(&actor); err != nil {
+			return nil, err
+		}
+		actors = append(actors, actor)
+	}
+	return actors, rows.Err()
+}
+
+// record runs change in a transaction that also logs the entity's state
+// before and after it, so that a change is never left out of the log and
+// the states logged are its own rather than a concurrent writer's. A change
+// that fails, or leaves the entity as it was, is not logged.
+func (r *Repository) record(entity string, id int64, action string, change func(tx *txn) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.logChange(tx, entity, id, action, func() error { return change(tx) }); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// logChange runs change within tx and logs the entity's state before and
+// after it.
+func (r *Repository) logChange(tx *txn, entity string, id int64, action string, change func() error) error {
+	before, err := snapshot(tx, entity, id)
+	if err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	after, err := snapshot(tx, entity, id)
+	if err != nil {
+		return err
+	}
+	return r.logEvent(tx, entity, id, action, before, after)
+}
+
+// recordCreate runs create in a transaction that also logs the entity it
+// returns the ID of.
+func (r *Repository) recordCreate(entity string, create func(tx *txn) (int64, error)) (int64, error) {
+	return r.recordInsert(entity, models.ActionCreate, create)
+}
+
+// recordInsert is recordCreate for rows inserted by another action, such as
+// a restore.
+func (r *Repository) recordInsert(entity, action string, create func(tx *txn) (int64, error)) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := create(tx)
+	if err != nil {
+		return 0, err
+	}
+	after, err := snapshot(tx, entity, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.logEvent(tx, entity, id, action, nil, after); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (r *Repository) logEvent(q querier, entity string, id int64, action string, before, after []byte) error {
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	_, err := q.Exec(`INSERT INTO events (entity, entity_id, action, before, after, actor) VALUES (?, ?, ?, ?, ?, ?)`,
+		entity, id, action, nullBytes(before), nullBytes(after), r.actor)
+	return err
+}
+
+// snapshot returns an entity's row as a JSON object, or nil if there is no
+// such entity. Sites and pages also carry their tag names and note, tags
+// their aliases, and collections their items in order. On PostgreSQL the
+// row stays locked until tx ends, so no other change to it can come between
+// the snapshots taken around a change.
+func snapshot(tx *txn, entity string, id int64) ([]byte, error) {
+	rows, err := tx.Query(`SELECT * FROM `+snapshotTables[entity]+` WHERE id = ?`+tx.dialect.forUpdate(), id)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	if !rows.Next() {
+		rows.Close()
+		return nil, rows.Err()
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err = rows.Scan(dest...)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	state := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		state[column] = values[i]
+	}
+
+	var lists map[string]string
+	switch entity {
+	case models.EntitySite:
+		lists = map[string]string{
+			"tags": `SELECT t.name FROM site_tags st JOIN tags t ON st.tag_id = t.id WHERE st.site_id = ? ORDER BY t.name`,
+			"note": `SELECT body FROM notes WHERE site_id = ?`,
+		}
+	case models.EntityPage:
+		lists = map[string]string{
+			"tags": `SELECT t.name FROM page_tags pt JOIN tags t ON pt.tag_id = t.id WHERE pt.page_id = ? ORDER BY t.name`,
+			"note": `SELECT body FROM notes WHERE page_id = ?`,
+			// When its text was last extracted; the text itself is too long
+			"extracted_at": `SELECT CAST(extracted_at AS TEXT) FROM page_contents WHERE page_id = ?`,
+		}
+	case models.EntityTag:
+		lists = map[string]string{
+			"aliases": `SELECT alias FROM tag_aliases WHERE tag_id = ? ORDER BY alias`,
+		}
+	case models.EntityCollection:
+		lists = map[string]string{
+			"items": `SELECT COALESCE(s.domain, ps.domain || p.path) || COALESCE(' (' || ci.note || ')', '')
+				FROM collection_items ci
+				LEFT JOIN sites s ON ci.site_id = s.id
+				LEFT JOIN pages p ON ci.page_id = p.id
+				LEFT JOIN sites ps ON p.site_id = ps.id
+				WHERE ci.collection_id = ?
+				ORDER BY ci.position, ci.id`,
+		}
+	}
+	for field, query := range lists {
+		values, err := queryStrings(tx, query, id)
+		if err != nil {
+			return nil, err
+		}
+		if field == "note" || field == "extracted_at" {
+			// A site or page has at most one note, and a page one extraction
+			state[field] = strings.Join(values, "")
+		} else {
+			state[field] = values
+		}
+	}
+
+	return json.Marshal(state)
+}
+
+func queryStrings(q querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// pageColumns is the select list understood by scanPage. Queries using it must
+// alias pages as p and join sites as s.
+const pageColumns = `p.id, p.site_id, s.domain, p.path, p.title, p.description, p.created_at,
+		       p.archived_at, p.archive_size, p.watched, p.checked_at, p.changed_at,
+		       p.broken_at, p.external_archive_url, p.external_archived_at,
+		       p.read_status, p.read_status_at, p.queued_at, p.visited_at,
+		       p.favorite, p.pinned_at, p.rating, p.deleted_at`
+
+const siteColumns = `s.id, s.category_id, COALESCE(c.name, '') as category_name,
+		       s.domain, s.name, s.description, s.created_at,
+		       ` + sitePageCount + ` as page_count,
+		       s.favorite, s.pinned_at, s.rating, s.deleted_at`
+
+// sitePageCount counts a site's pages. For a site in the trash it counts the
+// pages that went into the trash along with it.
+const sitePageCount = `(SELECT COUNT(*) FROM pages
+		        WHERE site_id = s.id
+		          AND CASE WHEN s.deleted_at IS NULL THEN deleted_at IS NULL ELSE deleted_with_site END)`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// querier is satisfied by conn and txn, as well as *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// normalizeTag lowercases a tag name and tidies its path, turning
+// " Lang / Go/" into "lang/go".
+func normalizeTag(name string) string {
+	var segments []string
+	for _, segment := range strings.Split(strings.ToLower(name), models.TagSeparator) {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, models.TagSeparator)
+}
+
+func scanPage(row scanner) (*models.Page, error) {
+	var p models.Page
+	var title, desc sql.NullString
+	var externalURL, readStatus sql.NullString
+	var archivedAt, checkedAt, changedAt, brokenAt, externalAt, readStatusAt, queuedAt, visitedAt, pinnedAt, deletedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.SiteID, &p.SiteDomain, &p.Path, &title, &desc, &p.CreatedAt,
+		&archivedAt, &p.ArchiveSize, &p.Watched, &checkedAt, &changedAt,
+		&brokenAt, &externalURL, &externalAt,
+		&readStatus, &readStatusAt, &queuedAt, &visitedAt,
+		&p.Favorite, &pinnedAt, &p.Rating, &deletedAt); err != nil {
+		return nil, err
+	}
+	p.Title = title.String
+	p.Description = desc.String
+	p.ArchivedAt = nullTime(archivedAt)
+	p.CheckedAt = nullTime(checkedAt)
+	p.ChangedAt = nullTime(changedAt)
+	p.BrokenAt = nullTime(brokenAt)
+	p.ExternalArchiveURL = externalURL.String
+	p.ExternalArchivedAt = nullTime(externalAt)
+	p.ReadStatus = readStatus.String
+	p.ReadStatusAt = nullTime(readStatusAt)
+	p.QueuedAt = nullTime(queuedAt)
+	p.VisitedAt = nullTime(visitedAt)
+	p.PinnedAt = nullTime(pinnedAt)
+	p.DeletedAt = nullTime(deletedAt)
+	return &p, nil
+}
+
+func scanSite(row scanner) (*models.Site, error) {
+	var s models.Site
+	var catID sql.NullInt64
+	var name, desc sql.NullString
+	var pinnedAt, deletedAt sql.NullTime
+	if err := row.Scan(&s.ID, &catID, &s.CategoryName, &s.Domain, &name, &desc, &s.CreatedAt, &s.PageCount,
+		&s.Favorite, &pinnedAt, &s.Rating, &deletedAt); err != nil {
+		return nil, err
+	}
+	if catID.Valid {
+		s.CategoryID = &catID.Int64
+	}
+	s.Name = name.String
+	s.Description = desc.String
+	s.PinnedAt = nullTime(pinnedAt)
+	s.DeletedAt = nullTime(deletedAt)
+	return &s, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nullInt64(n *int64) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+func nullBytes(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return string(b)
 }
 
 func nullString(s string) interface{} {
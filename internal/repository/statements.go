@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// maxStatements caps how many statements a statementCache keeps. Filter
+// combinations give a few queries many variants; past the cap new ones run
+// unprepared. Queries whose length varies with their arguments bypass the
+// cache through conn.QueryOnce.
+const maxStatements = 1000
+
+// statementCache prepares each query a Repository runs once and reuses the
+// statement, so the database does not parse it again on every call.
+// database/sql prepares it on each pooled connection as it is first used
+// there.
+type statementCache struct {
+	db    *sql.DB
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStatementCache(db *sql.DB) *statementCache {
+	return &statementCache{db: db, stmts: map[string]*sql.Stmt{}}
+}
+
+// get returns the prepared statement for query, or nil when the query
+// should run unprepared: the cache is full, or preparing failed, in which
+// case running it reports the error.
+func (c *statementCache) get(query string) *sql.Stmt {
+	if stmt := c.cached(query); stmt != nil {
+		return stmt
+	}
+
+	// Preparing waits for a free connection, so it must not hold the lock
+	// that transactions need to look up statements
+	c.mu.Lock()
+	full := len(c.stmts) >= maxStatements
+	c.mu.Unlock()
+	if full {
+		return nil
+	}
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.stmts[query]; ok {
+		// Another caller prepared it in the meantime
+		stmt.Close()
+		return existing
+	}
+	c.stmts[query] = stmt
+	return stmt
+}
+
+// cached returns the statement for query if it was prepared already. A
+// transaction cannot prepare one itself: that takes a second connection
+// from the pool while the transaction holds the write lock, and with every
+// other connection waiting for that lock none would come free.
+func (c *statementCache) cached(query string) *sql.Stmt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stmts[query]
+}
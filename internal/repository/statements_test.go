@@ -0,0 +1,36 @@
+package repository
+
+import "testing"
+
+// Paging through a list and loading the tags of differently sized pages
+// must reuse the statements already prepared, or the cache would fill up.
+func TestStatementCacheVariants(t *testing.T) {
+	r := New(openSQLite(t))
+	for _, title := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		page := addPage(t, r, "example.com", "/"+title, title)
+		check(t, r.AddPageTag(page, addTag(t, r, "tag-"+title)))
+	}
+	cached := func() int {
+		r.db.stmts.mu.Lock()
+		defer r.db.stmts.mu.Unlock()
+		return len(r.db.stmts.stmts)
+	}
+
+	_, err := r.GetPages(PageFilter{Sort: SortName, Limit: 1})
+	check(t, err)
+	_, err = r.CountPages(PageFilter{})
+	check(t, err)
+	want := cached()
+	for n := 1; n <= 7; n++ {
+		_, err := r.GetPages(PageFilter{Sort: SortName, Limit: n, Offset: 7 - n})
+		check(t, err)
+		tags := TagFilter{Any: make([]int64, n)}
+		_, err = r.GetPages(PageFilter{Tags: tags})
+		check(t, err)
+		_, err = r.CountPages(PageFilter{Tags: tags})
+		check(t, err)
+	}
+	if got := cached(); got != want {
+		t.Errorf("%d statements cached after paging, want %d", got, want)
+	}
+}
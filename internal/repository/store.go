@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/models"
+)
+
+// Store is everything the handlers and background jobs need from storage.
+// Repository keeps the data in SQLite and Memory keeps it in process memory.
+// Lookups of a single missing row fail with sql.ErrNoRows in every
+// implementation.
+type Store interface {
+	// As returns a store sharing the same data that attributes the changes
+	// it makes to actor.
+	As(actor string) Store
+
+	// Categories
+	GetCategories() ([]models.Category, error)
+	GetCategory(id int64) (*models.Category, error)
+	CreateCategory(name, description string, parentID *int64) (int64, error)
+	UpdateCategory(id int64, name, description string, parentID *int64) error
+	DeleteCategory(id int64) error
+
+	// Sites
+	GetSites(filter SiteFilter) ([]models.Site, error)
+	CountSites(filter SiteFilter) (int, error)
+	GetSite(id int64) (*models.Site, error)
+	GetSiteByDomain(domain string) (*models.Site, error)
+	CreateSite(categoryID *int64, domain, name, description string) (int64, error)
+	UpdateSite(id int64, categoryID *int64, domain, name, description string) error
+	DeleteSite(id int64) error
+
+	// Pages
+	GetPages(filter PageFilter) ([]models.Page, error)
+	CountPages(filter PageFilter) (int, error)
+	GetPage(id int64) (*models.Page, error)
+	CreatePage(siteID int64, path, title, description string) (int64, error)
+	UpdatePage(id int64, siteID int64, path, title, description string) error
+	DeletePage(id int64) error
+	SetPageArchive(id int64, size int64) error
+	GetPageContent(pageID int64) (*models.PageContent, error)
+	SetPageContent(pageID int64, text string) error
+	SetPageBroken(id int64, broken bool) error
+	SetPageExternalArchive(id int64, archiveURL string, archivedAt time.Time) error
+	MarkPageVisited(pageID int64) error
+
+	// Watched pages
+	SetPageWatched(id int64, watched bool) error
+	GetWatchedPages() ([]models.Page, error)
+	GetPageVersions(pageID int64) ([]models.PageVersion, error)
+	GetLatestPageVersion(pageID int64) (*models.PageVersion, error)
+	AddPageVersion(pageID int64, text, hash, diff string) error
+	MarkPageChecked(pageID int64) error
+	ClearPageChanged(pageID int64) error
+
+	// Read later
+	SetPageReadStatus(pageID int64, status string) error
+	GetReadingQueue(limit int) ([]models.Page, error)
+	GetPageReadEvents(pageID int64) ([]models.ReadEvent, error)
+
+	// Trash
+	GetTrashedSites() ([]models.Site, error)
+	GetTrashedPages() ([]models.Page, error)
+	RestoreSite(id int64) error
+	RestorePage(id int64) error
+	PurgeSite(id int64) ([]int64, error)
+	PurgePage(id int64) error
+	PurgeTrash(cutoff time.Time) ([]int64, error)
+	EmptyTrash() ([]int64, error)
+
+	// Favorites, pins and ratings
+	SetPageFavorite(id int64, favorite bool) error
+	SetSiteFavorite(id int64, favorite bool) error
+	SetPagePinned(id int64, pinned bool) error
+	SetSitePinned(id int64, pinned bool) error
+	SetPageRating(id int64, rating int) error
+	SetSiteRating(id int64, rating int) error
+
+	// Tags
+	GetTags() ([]models.Tag, error)
+	ListTags(filter TagListFilter) ([]models.Tag, error)
+	CountTags() (int, error)
+	HasSubtags(id int64) (bool, error)
+	GetTag(id int64) (*models.Tag, error)
+	LookupTag(name string) (int64, error)
+	GetOrCreateTag(name string) (int64, error)
+	CreateTag(name string) (int64, error)
+	DeleteTag(id int64) (*models.DeletedTag, error)
+	RestoreTag(deleted *models.DeletedTag) error
+	RenameTag(id int64, name string) error
+	MergeTags(sourceID, targetID int64) error
+	GetTagAliases(tagID int64) ([]string, error)
+	AddTagAlias(tagID int64, alias string) error
+	DeleteTagAlias(tagID int64, alias string) error
+	GetSiteTags(siteID int64) ([]models.Tag, error)
+	GetPageTags(pageID int64) ([]models.Tag, error)
+	SetSiteTags(siteID int64, tagIDs []int64) error
+	SetPageTags(pageID int64, tagIDs []int64) error
+	AddSiteTag(siteID, tagID int64) error
+	RemoveSiteTag(siteID, tagID int64) error
+	AddPageTag(pageID, tagID int64) error
+	RemovePageTag(pageID, tagID int64) error
+
+	// Saved searches
+	GetSavedSearches() ([]models.SavedSearch, error)
+	GetSavedSearch(id int64) (*models.SavedSearch, error)
+	CreateSavedSearch(name, query string) (int64, error)
+	DeleteSavedSearch(id int64) error
+
+	// Notes
+	GetPageNote(pageID int64) (*models.Note, error)
+	GetSiteNote(siteID int64) (*models.Note, error)
+	GetNotes() ([]models.Note, error)
+	SavePageNote(pageID int64, body string) error
+	SaveSiteNote(siteID int64, body string) error
+	GetNoteRevisions(noteID int64) ([]models.NoteRevision, error)
+	RestoreNoteRevision(revisionID int64) (*models.Note, error)
+
+	// Highlights
+	GetHighlights(query string) ([]models.Highlight, error)
+	GetPageHighlights(pageID int64) ([]models.Highlight, error)
+	GetHighlight(id int64) (*models.Highlight, error)
+	CreateHighlight(pageID int64, text, comment, source string) (int64, error)
+	UpdateHighlightComment(id int64, comment string) error
+	DeleteHighlight(id int64) error
+
+	// Collections
+	GetCollections() ([]models.Collection, error)
+	GetCollection(id int64) (*models.Collection, error)
+	GetSharedCollection(token string) (*models.Collection, error)
+	CreateCollection(name, description string) (int64, error)
+	UpdateCollection(id int64, name, description string) error
+	DeleteCollection(id int64) error
+	SetCollectionShareToken(id int64, token string) error
+	GetCollectionItems(collectionID int64) ([]models.CollectionItem, error)
+	AddCollectionItem(collectionID int64, siteID, pageID *int64, note string) (int64, error)
+	UpdateCollectionItemNote(collectionID, itemID int64, note string) error
+	RemoveCollectionItem(collectionID, itemID int64) error
+	ReorderCollection(collectionID int64, itemIDs []int64) error
+
+	// Dashboard and search
+	GetDashboardStats() (*models.DashboardStats, error)
+	Search(query string) ([]models.Site, []models.Page, error)
+
+	// Activity
+	GetEvents(filter EventFilter) ([]models.Event, error)
+	GetEventActors() ([]string, error)
+}
+
+var (
+	_ Store = (*Repository)(nil)
+	_ Store = (*Memory)(nil)
+)
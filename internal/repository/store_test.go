@@ -0,0 +1,770 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/database"
+	"github.com/lehmann314159/bookmarks/internal/database/dbtest"
+	"github.com/lehmann314159/bookmarks/internal/models"
+)
+
+// stores are the Store implementations the conformance tests run against,
+// each opening an empty store. PostgreSQL is only tested with
+// dbtest.PostgresEnv set.
+var stores = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return NewMemory() }},
+	{"sqlite", func(t *testing.T) Store { return New(openSQLite(t)) }},
+	{"sqlite-writer", func(t *testing.T) Store {
+		dir := t.TempDir()
+		db, err := database.New(dir)
+		check(t, err)
+		writer, err := database.NewWriter(dir)
+		check(t, err)
+		t.Cleanup(func() { writer.Close(); db.Close() })
+		return NewWithWriter(db, writer)
+	}},
+	{"postgres", func(t *testing.T) Store { return NewPostgres(dbtest.Postgres(t)) }},
+}
+
+// openSQLite opens a migrated database in a temporary directory.
+func openSQLite(t testing.TB) *sql.DB {
+	t.Helper()
+	db, err := database.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// forEachStore runs test against a fresh store of every kind, so each
+// implementation has to give the same answers.
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			test(t, store.open(t))
+		})
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addPage bookmarks path on domain, creating the site the first time.
+func addPage(t *testing.T, s Store, domain, path, title string) int64 {
+	t.Helper()
+	site, err := s.GetSiteByDomain(domain)
+	if errors.Is(err, sql.ErrNoRows) {
+		var id int64
+		id, err = s.CreateSite(nil, domain, "", "")
+		check(t, err)
+		site, err = s.GetSite(id)
+	}
+	check(t, err)
+	id, err := s.CreatePage(site.ID, path, title, "")
+	check(t, err)
+	return id
+}
+
+func addTag(t *testing.T, s Store, name string) int64 {
+	t.Helper()
+	id, err := s.GetOrCreateTag(name)
+	check(t, err)
+	return id
+}
+
+func getPage(t *testing.T, s Store, id int64) *models.Page {
+	t.Helper()
+	page, err := s.GetPage(id)
+	check(t, err)
+	return page
+}
+
+// titles lists the titles of the pages filter selects.
+func titles(t *testing.T, s Store, filter PageFilter) []string {
+	t.Helper()
+	pages, err := s.GetPages(filter)
+	check(t, err)
+	return pageTitles(pages)
+}
+
+func pageTitles(pages []models.Page) []string {
+	titles := []string{}
+	for _, p := range pages {
+		titles = append(titles, p.Title)
+	}
+	return titles
+}
+
+func tagNames(tags []models.Tag, err error) []string {
+	names := []string{}
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	if err != nil {
+		names = append(names, "error: "+err.Error())
+	}
+	return names
+}
+
+// count passes on a count, or -1 when counting failed, so the comparison
+// after it fails too.
+func count(n int, err error) int {
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func wantStrings(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
+
+func TestStoreCategories(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		dev, err := s.CreateCategory("Dev", "", nil)
+		check(t, err)
+		lang, err := s.CreateCategory("Languages", "", &dev)
+		check(t, err)
+		_, err = s.CreateCategory("Art", "", nil)
+		check(t, err)
+
+		cats, err := s.GetCategories()
+		check(t, err)
+		var names []string
+		for _, c := range cats {
+			names = append(names, c.Name)
+		}
+		wantStrings(t, "categories", names, []string{"Art", "Dev", "Languages"})
+		if c, err := s.GetCategory(lang); err != nil || c.ParentID == nil || *c.ParentID != dev {
+			t.Errorf("Languages = %+v, %v; want it under Dev", c, err)
+		}
+
+		if err := s.UpdateCategory(dev, "Dev", "", &lang); !errors.Is(err, ErrCategoryCycle) {
+			t.Errorf("nesting a category under its child: err = %v, want ErrCategoryCycle", err)
+		}
+		if _, err := s.GetCategory(999); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetCategory(999): err = %v, want sql.ErrNoRows", err)
+		}
+
+		_, err = s.CreateSite(&lang, "go.dev", "Go", "")
+		check(t, err)
+		if n := count(s.CountSites(SiteFilter{CategoryID: &dev, IncludeSubcategories: true})); n != 1 {
+			t.Errorf("sites under Dev = %d, want 1", n)
+		}
+		if n := count(s.CountSites(SiteFilter{CategoryID: &dev})); n != 0 {
+			t.Errorf("sites directly in Dev = %d, want 0", n)
+		}
+	})
+}
+
+func TestStoreSites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		id, err := s.CreateSite(nil, "go.dev", "Go", "The Go site")
+		check(t, err)
+		if _, err := s.CreateSite(nil, "go.dev", "", ""); err == nil {
+			t.Error("created a second site with the same domain")
+		}
+
+		check(t, s.UpdateSite(id, nil, "go.dev", "Golang", ""))
+		site, err := s.GetSiteByDomain("go.dev")
+		check(t, err)
+		if site.Name != "Golang" || site.Description != "" {
+			t.Errorf("updated site = %q %q", site.Name, site.Description)
+		}
+
+		check(t, s.SetSiteFavorite(id, true))
+		check(t, s.SetSitePinned(id, true))
+		check(t, s.SetSiteRating(id, 4))
+		if err := s.SetSiteRating(id, 6); !errors.Is(err, ErrInvalidRating) {
+			t.Errorf("rating 6: err = %v, want ErrInvalidRating", err)
+		}
+		site, err = s.GetSite(id)
+		check(t, err)
+		if !site.Favorite || site.PinnedAt == nil || site.Rating != 4 {
+			t.Errorf("site = favorite %v, pinned %v, rating %d", site.Favorite, site.PinnedAt, site.Rating)
+		}
+
+		_, err = s.CreateSite(nil, "example.com", "", "")
+		check(t, err)
+		if n := count(s.CountSites(SiteFilter{Favorites: true})); n != 1 {
+			t.Errorf("favorite sites = %d, want 1", n)
+		}
+		if n := count(s.CountSites(SiteFilter{MinRating: 3})); n != 1 {
+			t.Errorf("sites rated 3 or more = %d, want 1", n)
+		}
+		sites, err := s.GetSites(SiteFilter{})
+		check(t, err)
+		if len(sites) != 2 || sites[0].Domain != "example.com" || sites[1].Domain != "go.dev" {
+			t.Errorf("sites = %v, want them by domain", sites)
+		}
+
+		if err := s.DeleteSite(999); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("DeleteSite(999): err = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestStorePages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		a := addPage(t, s, "go.dev", "/doc", "Documentation")
+		b := addPage(t, s, "go.dev", "/blog", "Blog")
+		c := addPage(t, s, "example.com", "/", "Example")
+
+		page := getPage(t, s, a)
+		if page.URL() != "https://go.dev/doc" {
+			t.Errorf("page URL = %s", page.URL())
+		}
+		if _, err := s.CreatePage(page.SiteID, "/doc", "", ""); err == nil {
+			t.Error("created the same page twice")
+		}
+
+		wantStrings(t, "pages by name", titles(t, s, PageFilter{Sort: SortName}),
+			[]string{"Blog", "Documentation", "Example"})
+		wantStrings(t, "pages newest first", titles(t, s, PageFilter{}),
+			[]string{"Example", "Blog", "Documentation"})
+		wantStrings(t, "pages on go.dev", titles(t, s, PageFilter{SiteID: &page.SiteID, Sort: SortName}),
+			[]string{"Blog", "Documentation"})
+		wantStrings(t, "pages matching", titles(t, s, PageFilter{Query: "blog"}), []string{"Blog"})
+
+		check(t, s.SetPageRating(c, 5))
+		check(t, s.SetPageFavorite(b, true))
+		wantStrings(t, "best rated page", titles(t, s, PageFilter{Sort: SortRating, Limit: 1}), []string{"Example"})
+		if n := count(s.CountPages(PageFilter{Favorites: true})); n != 1 {
+			t.Errorf("favorite pages = %d, want 1", n)
+		}
+
+		check(t, s.UpdatePage(a, page.SiteID, "/doc/", "Docs", "All of them"))
+		page = getPage(t, s, a)
+		if page.Path != "/doc/" || page.Title != "Docs" || page.Description != "All of them" {
+			t.Errorf("updated page = %+v", page)
+		}
+
+		check(t, s.SetPageContent(a, "Effective Go and the spec"))
+		if content, err := s.GetPageContent(a); err != nil || content.Text != "Effective Go and the spec" {
+			t.Errorf("content = %+v, %v", content, err)
+		}
+		wantStrings(t, "pages matching their text", titles(t, s, PageFilter{Query: "effective"}), []string{"Docs"})
+
+		check(t, s.SetPageBroken(b, true))
+		if getPage(t, s, b).BrokenAt == nil {
+			t.Error("broken page has no BrokenAt")
+		}
+		check(t, s.SetPageBroken(b, false))
+		if getPage(t, s, b).BrokenAt != nil {
+			t.Error("fixed page is still broken")
+		}
+
+		if _, err := s.GetPage(999); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetPage(999): err = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestStorePagesByTag(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		a := addPage(t, s, "go.dev", "/doc", "Go docs")
+		b := addPage(t, s, "docs.rs", "/", "Rust docs")
+		c := addPage(t, s, "example.com", "/", "Example")
+		lang := addTag(t, s, "lang")
+		golang := addTag(t, s, "lang/go")
+		rust := addTag(t, s, "lang/rust")
+		docs := addTag(t, s, "docs")
+		check(t, s.SetPageTags(a, []int64{golang, docs}))
+		check(t, s.SetPageTags(b, []int64{rust, docs}))
+		site := getPage(t, s, c).SiteID
+		check(t, s.AddSiteTag(site, golang))
+
+		tests := []struct {
+			name   string
+			filter TagFilter
+			want   []string
+		}{
+			{"all", TagFilter{All: []int64{golang, docs}}, []string{"Go docs"}},
+			{"any", TagFilter{Any: []int64{golang, rust}}, []string{"Go docs", "Rust docs"}},
+			{"none", TagFilter{None: []int64{docs}}, []string{"Example"}},
+			{"subtags", TagFilter{All: []int64{lang}, IncludeSubtags: true}, []string{"Go docs", "Rust docs"}},
+			{"without subtags", TagFilter{All: []int64{lang}}, []string{}},
+			{"site tags", TagFilter{All: []int64{golang}, IncludeSiteTags: true}, []string{"Example", "Go docs"}},
+		}
+		for _, tt := range tests {
+			wantStrings(t, tt.name, titles(t, s, PageFilter{Tags: tt.filter, Sort: SortName}), tt.want)
+			if n := count(s.CountPages(PageFilter{Tags: tt.filter})); n != len(tt.want) {
+				t.Errorf("%s: counted %d pages, want %d", tt.name, n, len(tt.want))
+			}
+		}
+
+		wantStrings(t, "page tags", tagNames(s.GetPageTags(a)), []string{"docs", "lang/go"})
+		wantStrings(t, "site tags", tagNames(s.GetSiteTags(site)), []string{"lang/go"})
+	})
+}
+
+func TestStorePagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		for _, title := range []string{"a", "b", "c", "d", "e"} {
+			addPage(t, s, "example.com", "/"+title, title)
+		}
+		tests := []struct {
+			limit, offset int
+			want          []string
+		}{
+			{0, 0, []string{"a", "b", "c", "d", "e"}},
+			{2, 0, []string{"a", "b"}},
+			{2, 2, []string{"c", "d"}},
+			{2, 4, []string{"e"}},
+			{2, 5, []string{}},
+		}
+		for _, tt := range tests {
+			got := titles(t, s, PageFilter{Sort: SortName, Limit: tt.limit, Offset: tt.offset})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("limit %d offset %d: pages = %q, want %q", tt.limit, tt.offset, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestStoreTags(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		page := addPage(t, s, "go.dev", "/", "Go")
+		golang := addTag(t, s, "golang")
+		if id := addTag(t, s, "golang"); id != golang {
+			t.Errorf("GetOrCreateTag made a second golang tag")
+		}
+		if _, err := s.CreateTag("golang"); !errors.Is(err, ErrTagExists) {
+			t.Errorf("CreateTag(golang) twice: err = %v, want ErrTagExists", err)
+		}
+		check(t, s.AddPageTag(page, golang))
+
+		check(t, s.RenameTag(golang, "lang/go"))
+		if tag, err := s.GetTag(golang); err != nil || tag.Name != "lang/go" {
+			t.Errorf("renamed tag = %+v, %v", tag, err)
+		}
+		aliases, err := s.GetTagAliases(golang)
+		check(t, err)
+		wantStrings(t, "aliases", aliases, []string{"golang"})
+		if id := addTag(t, s, "golang"); id != golang {
+			t.Error("the old name no longer finds the renamed tag")
+		}
+		if id, err := s.LookupTag(" Golang "); err != nil || id != golang {
+			t.Errorf("LookupTag(golang) = %d, %v; want the renamed tag", id, err)
+		}
+		if _, err := s.LookupTag("rust"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("LookupTag(rust): err = %v, want sql.ErrNoRows", err)
+		}
+
+		check(t, s.AddTagAlias(golang, "go"))
+		if err := s.AddTagAlias(golang, "lang/go"); !errors.Is(err, ErrTagExists) {
+			t.Errorf("aliasing a tag to its own name: err = %v, want ErrTagExists", err)
+		}
+		check(t, s.DeleteTagAlias(golang, "go"))
+		aliases, err = s.GetTagAliases(golang)
+		check(t, err)
+		wantStrings(t, "aliases", aliases, []string{"golang"})
+
+		addTag(t, s, "lang/rust")
+		addTag(t, s, "art")
+		// Renaming golang to lang/go brought in its parent, lang
+		wantStrings(t, "tags", tagNames(s.GetTags()), []string{"art", "lang", "lang/go", "lang/rust"})
+		counted, err := s.ListTags(TagListFilter{Sort: SortPageCount, Limit: 1})
+		check(t, err)
+		if len(counted) != 1 || counted[0].Name != "lang/go" || counted[0].PageCount != 1 {
+			t.Errorf("most used tag = %+v, want lang/go on one page", counted)
+		}
+		if n := count(s.CountTags()); n != 4 {
+			t.Errorf("CountTags = %d, want 4", n)
+		}
+		for id, want := range map[int64]bool{golang: false, addTag(t, s, "lang"): true, addTag(t, s, "art"): false, 0: false} {
+			if has, err := s.HasSubtags(id); err != nil || has != want {
+				t.Errorf("HasSubtags(%d) = %v, %v; want %v", id, has, err, want)
+			}
+		}
+
+		deleted, err := s.DeleteTag(golang)
+		check(t, err)
+		wantStrings(t, "tags after delete", tagNames(s.GetTags()), []string{"art", "lang", "lang/rust"})
+		check(t, s.RestoreTag(deleted))
+		wantStrings(t, "restored page tags", tagNames(s.GetPageTags(page)), []string{"lang/go"})
+		aliases, err = s.GetTagAliases(addTag(t, s, "golang"))
+		check(t, err)
+		wantStrings(t, "restored aliases", aliases, []string{"golang"})
+
+		// SQLite gives the newest tag's ID to the next one created, which
+		// the restored tag must not clash with
+		deleted, err = s.DeleteTag(addTag(t, s, "art"))
+		check(t, err)
+		addTag(t, s, "music")
+		check(t, s.RestoreTag(deleted))
+		wantStrings(t, "tags after restoring", tagNames(s.GetTags()),
+			[]string{"art", "lang", "lang/go", "lang/rust", "music"})
+	})
+}
+
+func TestStoreEmptyTagNames(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		golang := addTag(t, s, "golang")
+		for _, name := range []string{"/", " / ", "//", " /  / "} {
+			if _, err := s.CreateTag(name); !errors.Is(err, ErrTagNameEmpty) {
+				t.Errorf("CreateTag(%q): err = %v, want ErrTagNameEmpty", name, err)
+			}
+			if _, err := s.GetOrCreateTag(name); !errors.Is(err, ErrTagNameEmpty) {
+				t.Errorf("GetOrCreateTag(%q): err = %v, want ErrTagNameEmpty", name, err)
+			}
+			if err := s.RenameTag(golang, name); !errors.Is(err, ErrTagNameEmpty) {
+				t.Errorf("RenameTag(%q): err = %v, want ErrTagNameEmpty", name, err)
+			}
+			if err := s.AddTagAlias(golang, name); !errors.Is(err, ErrTagNameEmpty) {
+				t.Errorf("AddTagAlias(%q): err = %v, want ErrTagNameEmpty", name, err)
+			}
+		}
+		wantStrings(t, "tags", tagNames(s.GetTags()), []string{"golang"})
+		if aliases, err := s.GetTagAliases(golang); err != nil || len(aliases) != 0 {
+			t.Errorf("aliases = %q, %v; want none", aliases, err)
+		}
+	})
+}
+
+func TestStoreMergeTags(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		a := addPage(t, s, "go.dev", "/", "Go")
+		b := addPage(t, s, "docs.rs", "/", "Rust")
+		lang := addTag(t, s, "lang")
+		rust := addTag(t, s, "lang/rust")
+		docs := addTag(t, s, "docs")
+		check(t, s.AddPageTag(a, lang))
+		check(t, s.AddPageTag(b, rust))
+
+		check(t, s.MergeTags(lang, docs))
+		if _, err := s.GetTag(lang); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("merged tag still exists: err = %v", err)
+		}
+		wantStrings(t, "tags", tagNames(s.GetTags()), []string{"docs", "docs/rust"})
+		wantStrings(t, "Go page tags", tagNames(s.GetPageTags(a)), []string{"docs"})
+		wantStrings(t, "Rust page tags", tagNames(s.GetPageTags(b)), []string{"docs/rust"})
+		if tag, err := s.GetTag(addTag(t, s, "lang/python")); err != nil || tag.Name != "docs/python" {
+			t.Errorf("new tag below the merged name = %+v, %v; want docs/python", tag, err)
+		}
+
+		// Children with the same name under both tags are merged too
+		webGo := addTag(t, s, "web/go")
+		codeGo := addTag(t, s, "code/go")
+		tools := addTag(t, s, "code/go/tools")
+		check(t, s.AddPageTag(a, codeGo))
+		check(t, s.AddPageTag(b, webGo))
+		check(t, s.AddPageTag(b, tools))
+		check(t, s.MergeTags(addTag(t, s, "code"), addTag(t, s, "web")))
+		if _, err := s.GetTag(codeGo); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("merged child tag still exists: err = %v", err)
+		}
+		wantStrings(t, "tags after merging children", tagNames(s.GetTags()),
+			[]string{"docs", "docs/python", "docs/rust", "web", "web/go", "web/go/tools"})
+		wantStrings(t, "Go page tags", tagNames(s.GetPageTags(a)), []string{"docs", "web/go"})
+		wantStrings(t, "Rust page tags", tagNames(s.GetPageTags(b)), []string{"docs/rust", "web/go", "web/go/tools"})
+		if tag, err := s.GetTag(tools); err != nil || tag.Name != "web/go/tools" {
+			t.Errorf("grandchild tag = %+v, %v; want web/go/tools", tag, err)
+		}
+		aliases, err := s.GetTagAliases(webGo)
+		check(t, err)
+		wantStrings(t, "merged child aliases", aliases, []string{"code/go"})
+
+		if err := s.MergeTags(docs, rust); !errors.Is(err, ErrTagCycle) {
+			t.Errorf("merging a tag into its child: err = %v, want ErrTagCycle", err)
+		}
+		if err := s.MergeTags(docs, docs); err == nil {
+			t.Error("merged a tag into itself")
+		}
+	})
+}
+
+func TestStoreReadingQueue(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		a := addPage(t, s, "example.com", "/a", "a")
+		b := addPage(t, s, "example.com", "/b", "b")
+		check(t, s.SetPageReadStatus(a, models.StatusUnread))
+		check(t, s.SetPageReadStatus(b, models.StatusUnread))
+		check(t, s.SetPageReadStatus(b, models.StatusReading))
+		if err := s.SetPageReadStatus(a, "skimmed"); !errors.Is(err, ErrInvalidReadStatus) {
+			t.Errorf("unknown status: err = %v, want ErrInvalidReadStatus", err)
+		}
+
+		queue, err := s.GetReadingQueue(0)
+		check(t, err)
+		wantStrings(t, "queue", pageTitles(queue), []string{"a", "b"})
+		wantStrings(t, "unread", titles(t, s, PageFilter{ReadStatus: models.StatusUnread}), []string{"a"})
+
+		events, err := s.GetPageReadEvents(b)
+		check(t, err)
+		if len(events) != 2 {
+			t.Errorf("read events = %+v, want two", events)
+		}
+
+		check(t, s.SetPageReadStatus(a, models.StatusRead))
+		check(t, s.SetPageReadStatus(b, ""))
+		queue, err = s.GetReadingQueue(0)
+		check(t, err)
+		wantStrings(t, "queue once read", pageTitles(queue), []string{})
+	})
+}
+
+func TestStoreTrash(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		a := addPage(t, s, "go.dev", "/a", "a")
+		b := addPage(t, s, "go.dev", "/b", "b")
+		site := getPage(t, s, a).SiteID
+		kept := addPage(t, s, "example.com", "/", "kept")
+
+		check(t, s.DeletePage(a))
+		if err := s.DeletePage(a); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("deleting a trashed page: err = %v, want sql.ErrNoRows", err)
+		}
+		if err := s.DeletePage(a + 1000); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("deleting a missing page: err = %v, want sql.ErrNoRows", err)
+		}
+		trashed, err := s.GetTrashedPages()
+		check(t, err)
+		wantStrings(t, "trashed pages", pageTitles(trashed), []string{"a"})
+		wantStrings(t, "pages", titles(t, s, PageFilter{Sort: SortName}), []string{"b", "kept"})
+		check(t, s.RestorePage(a))
+		wantStrings(t, "restored pages", titles(t, s, PageFilter{Sort: SortName}), []string{"a", "b", "kept"})
+
+		// Trashing a site takes its pages with it
+		check(t, s.DeleteSite(site))
+		wantStrings(t, "pages with the site trashed", titles(t, s, PageFilter{}), []string{"kept"})
+		if sites, err := s.GetTrashedSites(); err != nil || len(sites) != 1 || sites[0].Domain != "go.dev" {
+			t.Errorf("trashed sites = %v, %v", sites, err)
+		}
+		check(t, s.RestoreSite(site))
+		if n := count(s.CountPages(PageFilter{})); n != 3 {
+			t.Errorf("pages after restoring the site = %d, want 3", n)
+		}
+
+		check(t, s.DeletePage(b))
+		check(t, s.DeletePage(kept))
+		if purged, err := s.PurgeTrash(time.Now().Add(-time.Hour)); err != nil || len(purged) != 0 {
+			t.Errorf("purged %v, %v; everything was trashed under an hour ago", purged, err)
+		}
+		if purged, err := s.EmptyTrash(); err != nil || len(purged) != 2 {
+			t.Errorf("emptying the trash purged %v, %v; want two pages", purged, err)
+		}
+		if _, err := s.GetPage(b); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("purged page: err = %v, want sql.ErrNoRows", err)
+		}
+		wantStrings(t, "pages left", titles(t, s, PageFilter{}), []string{"a"})
+	})
+}
+
+func TestStoreTrashActivity(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		actions := func(entity string, id int64) []string {
+			t.Helper()
+			events, err := s.GetEvents(EventFilter{Entity: entity, EntityID: id})
+			check(t, err)
+			var actions []string
+			for _, e := range events {
+				actions = append(actions, e.Action)
+			}
+			return actions
+		}
+
+		// Restoring a page out of a trashed site brings the site back too
+		a := addPage(t, s, "go.dev", "/a", "a")
+		b := addPage(t, s, "go.dev", "/b", "b")
+		site := getPage(t, s, a).SiteID
+		check(t, s.DeleteSite(site))
+		check(t, s.RestorePage(a))
+		wantStrings(t, "site actions", actions(models.EntitySite, site),
+			[]string{models.ActionRestore, models.ActionDelete, models.ActionCreate})
+
+		// Purging a site logs the pages it takes with it
+		check(t, s.DeleteSite(site))
+		if _, err := s.PurgeSite(site); err != nil {
+			t.Fatal(err)
+		}
+		for _, page := range []int64{a, b} {
+			if got := actions(models.EntityPage, page); len(got) == 0 || got[0] != models.ActionPurge {
+				t.Errorf("page %d actions = %v, want a purge first", page, got)
+			}
+		}
+		if got := actions(models.EntitySite, site); got[0] != models.ActionPurge {
+			t.Errorf("site actions = %v, want a purge first", got)
+		}
+	})
+}
+
+func TestStoreNotes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		page := addPage(t, s, "go.dev", "/", "Go")
+		if _, err := s.GetPageNote(page); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("missing note: err = %v, want sql.ErrNoRows", err)
+		}
+		check(t, s.SavePageNote(page, "first"))
+		check(t, s.SavePageNote(page, "second"))
+		note, err := s.GetPageNote(page)
+		check(t, err)
+		if note.Body != "second" {
+			t.Errorf("note = %q", note.Body)
+		}
+
+		revisions, err := s.GetNoteRevisions(note.ID)
+		check(t, err)
+		if len(revisions) != 2 || revisions[0].Body != "second" || revisions[1].Body != "first" {
+			t.Fatalf("revisions = %+v, want both bodies, newest first", revisions)
+		}
+		if restored, err := s.RestoreNoteRevision(revisions[1].ID); err != nil || restored.Body != "first" {
+			t.Errorf("restored note = %+v, %v", restored, err)
+		}
+		wantStrings(t, "pages matching the note", titles(t, s, PageFilter{Query: "first"}), []string{"Go"})
+		if notes, err := s.GetNotes(); err != nil || len(notes) != 1 {
+			t.Errorf("notes = %+v, %v; want one", notes, err)
+		}
+	})
+}
+
+func TestStoreCollections(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		a := addPage(t, s, "go.dev", "/a", "a")
+		b := addPage(t, s, "go.dev", "/b", "b")
+		site := getPage(t, s, a).SiteID
+		id, err := s.CreateCollection("Reading", "")
+		check(t, err)
+
+		first, err := s.AddCollectionItem(id, nil, &a, "start here")
+		check(t, err)
+		second, err := s.AddCollectionItem(id, &site, nil, "")
+		check(t, err)
+		third, err := s.AddCollectionItem(id, nil, &b, "")
+		check(t, err)
+		if _, err := s.AddCollectionItem(id, &site, &a, ""); err == nil {
+			t.Error("added an item that is both a site and a page")
+		}
+
+		check(t, s.ReorderCollection(id, []int64{third, first, second}))
+		check(t, s.UpdateCollectionItemNote(id, third, "then this"))
+		items, err := s.GetCollectionItems(id)
+		check(t, err)
+		if len(items) != 3 {
+			t.Fatalf("items = %d, want 3", len(items))
+		}
+		if items[0].Page == nil || items[0].Page.Title != "b" || items[0].Note != "then this" {
+			t.Errorf("first item = %+v", items[0])
+		}
+		if items[1].Page == nil || items[1].Page.SiteDomain != "go.dev" {
+			t.Errorf("second item = %+v", items[1])
+		}
+		if items[2].Site == nil || items[2].Site.Domain != "go.dev" {
+			t.Errorf("third item = %+v", items[2])
+		}
+
+		check(t, s.RemoveCollectionItem(id, first))
+		if c, err := s.GetCollection(id); err != nil || c.ItemCount != 2 {
+			t.Errorf("collection = %+v, %v; want two items", c, err)
+		}
+
+		check(t, s.SetCollectionShareToken(id, "secret"))
+		if c, err := s.GetSharedCollection("secret"); err != nil || c.ID != id {
+			t.Errorf("shared collection = %+v, %v", c, err)
+		}
+		if _, err := s.GetSharedCollection("guess"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("wrong token: err = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestStoreSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		_, err := s.CreateSite(nil, "go.dev", "The Go Programming Language", "")
+		check(t, err)
+		addPage(t, s, "go.dev", "/doc/effective_go", "Effective Go")
+		addPage(t, s, "example.com", "/", "Example")
+
+		sites, pages, err := s.Search("go")
+		check(t, err)
+		if len(sites) != 1 || sites[0].Domain != "go.dev" {
+			t.Errorf("sites found = %v", sites)
+		}
+		wantStrings(t, "pages found", pageTitles(pages), []string{"Effective Go"})
+
+		sites, pages, err = s.Search("nothing like it")
+		check(t, err)
+		if len(sites) != 0 || len(pages) != 0 {
+			t.Errorf("found %d sites and %d pages for nonsense", len(sites), len(pages))
+		}
+	})
+}
+
+func TestStoreContentActivity(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		page := addPage(t, s, "go.dev", "/", "Go")
+		check(t, s.SetPageContent(page, "Build simple, secure, scalable systems"))
+		filter := EventFilter{Entity: models.EntityPage, EntityID: page}
+		events, err := s.GetEvents(filter)
+		check(t, err)
+
+		// Extraction times have whole seconds; wait for the next one so an
+		// unchanged re-extraction would be told apart if it were written
+		time.Sleep(time.Second)
+		check(t, s.SetPageContent(page, "Build simple, secure, scalable systems"))
+		if after, err := s.GetEvents(filter); err != nil || len(after) != len(events) {
+			t.Errorf("events after extracting the same text = %d, %v; want %d", len(after), err, len(events))
+		}
+
+		check(t, s.SetPageContent(page, "Go 1.23 is released"))
+		if after, err := s.GetEvents(filter); err != nil || len(after) != len(events)+1 {
+			t.Errorf("events after extracting new text = %d, %v; want %d", len(after), err, len(events)+1)
+		}
+		if content, err := s.GetPageContent(page); err != nil || content.Text != "Go 1.23 is released" {
+			t.Errorf("content = %+v, %v", content, err)
+		}
+	})
+}
+
+func TestStoreActivity(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		page := addPage(t, s, "go.dev", "/", "Go")
+		check(t, s.As("cli").UpdatePage(page, getPage(t, s, page).SiteID, "/", "Go home", ""))
+		check(t, s.SetPageArchive(page, 1234))
+		check(t, s.DeletePage(page))
+
+		filter := EventFilter{Entity: models.EntityPage, EntityID: page}
+		events, err := s.GetEvents(filter)
+		check(t, err)
+		var actions []string
+		for _, e := range events {
+			actions = append(actions, e.Action)
+		}
+		wantStrings(t, "actions, newest first", actions,
+			[]string{models.ActionDelete, models.ActionUpdate, models.ActionUpdate, models.ActionCreate})
+		if len(events) != 4 {
+			return
+		}
+		if e := events[2]; e.Actor != "cli" || e.Before["title"] != "Go" || e.After["title"] != "Go home" {
+			t.Errorf("update event = %+v", e)
+		}
+		if events[3].Before != nil {
+			t.Errorf("create event has a before state: %v", events[3].Before)
+		}
+
+		// Changes that change nothing are not logged
+		check(t, s.SetPageRating(page, 0))
+		if events, err := s.GetEvents(filter); err != nil || len(events) != 4 {
+			t.Errorf("events after a no-op = %d, %v; want 4", len(events), err)
+		}
+
+		actors, err := s.GetEventActors()
+		check(t, err)
+		wantStrings(t, "actors", actors, []string{"cli", DefaultActor})
+	})
+}
@@ -0,0 +1,327 @@
+// Package server runs the web app: it opens storage as configured by the
+// environment, starts the background jobs and serves HTTP on PORT.
+package server
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/backup"
+	"github.com/lehmann314159/bookmarks/internal/database"
+	"github.com/lehmann314159/bookmarks/internal/handlers"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+	"github.com/lehmann314159/bookmarks/internal/trash"
+	"github.com/lehmann314159/bookmarks/internal/undo"
+	"github.com/lehmann314159/bookmarks/internal/watcher"
+)
+
+// Run starts the server and serves until the process exits. Templates
+// and static files are read relative to the working directory.
+func Run() {
+	// Get data directory from environment or use default
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "./data"
+	}
+
+	// Initialize storage. Setting DATABASE_URL switches to PostgreSQL.
+	databaseURL := os.Getenv("DATABASE_URL")
+	storage := os.Getenv("STORAGE")
+	if storage == "" && databaseURL != "" {
+		storage = "postgres"
+	}
+	var repo repository.Store
+	var sqliteDB *sql.DB
+	switch storage {
+	case "", "sqlite":
+		db, err := database.New(dataDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+		defer db.Close()
+		writer, err := database.NewWriter(dataDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+		defer writer.Close()
+		repo = repository.NewWithWriter(db, writer)
+		sqliteDB = db
+	case "postgres":
+		if databaseURL == "" {
+			log.Fatal("STORAGE=postgres needs DATABASE_URL")
+		}
+		db, err := database.NewPostgres(databaseURL)
+		if err != nil {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+		defer db.Close()
+		repo = repository.NewPostgres(db)
+	case "memory":
+		// Nothing is kept once the server stops
+		repo = repository.NewMemory()
+	default:
+		log.Fatalf("Invalid STORAGE %q: must be sqlite, postgres or memory", storage)
+	}
+
+	// Page snapshots live next to the database
+	archiver := archive.New(dataDir)
+	autoArchive := os.Getenv("AUTO_ARCHIVE") == "true"
+
+	// External archive used to find copies of dead links
+	waybackURL := os.Getenv("WAYBACK_URL")
+	if waybackURL == "" {
+		waybackURL = archive.DefaultWaybackURL
+	}
+	archiveProvider := archive.NewWayback(waybackURL)
+
+	// Refetch watched pages in the background
+	watchInterval := 6 * time.Hour
+	if str := os.Getenv("WATCH_INTERVAL"); str != "" {
+		interval, err := time.ParseDuration(str)
+		if err != nil {
+			log.Fatalf("Invalid WATCH_INTERVAL: %v", err)
+		}
+		watchInterval = interval
+	}
+	pageWatcher := watcher.New(repo, archiveProvider, watchInterval)
+	pageWatcher.Start()
+
+	// Deleted sites and pages stay in the trash until they expire
+	trashRetention := trash.DefaultRetention
+	if str := os.Getenv("TRASH_RETENTION"); str != "" {
+		retention, err := time.ParseDuration(str)
+		if err != nil {
+			log.Fatalf("Invalid TRASH_RETENTION: %v", err)
+		}
+		trashRetention = retention
+	}
+	purger := trash.New(repo, archiver, trashRetention)
+	purger.Start()
+
+	// Back up an SQLite database into DATA_DIR/backups while running.
+	// BACKUP_INTERVAL=0 turns off the schedule but not manual backups.
+	var backups *backup.Manager
+	if sqliteDB != nil {
+		backupInterval := backup.DefaultInterval
+		if str := os.Getenv("BACKUP_INTERVAL"); str != "" {
+			interval, err := time.ParseDuration(str)
+			if err != nil {
+				log.Fatalf("Invalid BACKUP_INTERVAL: %v", err)
+			}
+			backupInterval = interval
+		}
+		keepDaily := envInt("BACKUP_KEEP_DAILY", backup.DefaultKeepDaily)
+		keepWeekly := envInt("BACKUP_KEEP_WEEKLY", backup.DefaultKeepWeekly)
+		backups = backup.New(sqliteDB, dataDir, keepDaily, keepWeekly)
+		if backupInterval > 0 {
+			backups.Start(backupInterval)
+		}
+	}
+
+	// Parse templates
+	tmpl, err := handlers.ParseTemplates("templates/*.html")
+	if err != nil {
+		log.Fatalf("Failed to parse templates: %v", err)
+	}
+
+	// Deletes can be undone for a short while
+	undos := undo.New(undo.DefaultWindow)
+
+	// Initialize handlers
+	homeHandler := handlers.NewHomeHandler(repo, tmpl)
+	categoryHandler := handlers.NewCategoryHandler(repo, tmpl)
+	siteHandler := handlers.NewSiteHandler(repo, tmpl, undos)
+	pageHandler := handlers.NewPageHandler(repo, tmpl, archiver, autoArchive, undos)
+	tagHandler := handlers.NewTagHandler(repo, tmpl, undos)
+	watchHandler := handlers.NewWatchHandler(repo, pageWatcher, tmpl)
+	archiveProviderHandler := handlers.NewArchiveProviderHandler(repo, archiveProvider, tmpl)
+	savedSearchHandler := handlers.NewSavedSearchHandler(repo, tmpl)
+	collectionHandler := handlers.NewCollectionHandler(repo, tmpl)
+	queueHandler := handlers.NewQueueHandler(repo, tmpl)
+	noteHandler := handlers.NewNoteHandler(repo, tmpl)
+	highlightHandler := handlers.NewHighlightHandler(repo, tmpl)
+	favoriteHandler := handlers.NewFavoriteHandler(repo, tmpl)
+	exportHandler := handlers.NewExportHandler(repo)
+	trashHandler := handlers.NewTrashHandler(repo, purger, tmpl)
+	activityHandler := handlers.NewActivityHandler(repo, tmpl)
+	undoHandler := handlers.NewUndoHandler(undos)
+	backupHandler := handlers.NewBackupHandler(backups, tmpl)
+	apiHandler := handlers.NewAPIHandler(repo, pageHandler, archiveProvider)
+
+	// Setup routes
+	mux := http.NewServeMux()
+
+	// Static files
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	// Home
+	mux.HandleFunc("GET /{$}", homeHandler.Dashboard)
+	mux.HandleFunc("GET /search", homeHandler.Search)
+
+	// Categories
+	mux.HandleFunc("GET /categories", categoryHandler.List)
+	mux.HandleFunc("POST /categories", categoryHandler.Create)
+	mux.HandleFunc("GET /categories/{id}/edit", categoryHandler.Edit)
+	mux.HandleFunc("PUT /categories/{id}", categoryHandler.Update)
+	mux.HandleFunc("DELETE /categories/{id}", categoryHandler.Delete)
+
+	// Sites
+	mux.HandleFunc("GET /sites", siteHandler.List)
+	mux.HandleFunc("POST /sites", siteHandler.Create)
+	mux.HandleFunc("GET /sites/{id}/edit", siteHandler.Edit)
+	mux.HandleFunc("PUT /sites/{id}", siteHandler.Update)
+	mux.HandleFunc("DELETE /sites/{id}", siteHandler.Delete)
+	mux.HandleFunc("GET /sites/{id}/pages", siteHandler.Pages)
+	mux.HandleFunc("GET /sites/{id}/notes", noteHandler.SiteNote)
+	mux.HandleFunc("PUT /sites/{id}/notes", noteHandler.SaveSiteNote)
+	mux.HandleFunc("POST /sites/{id}/favorite", favoriteHandler.SiteFavorite)
+	mux.HandleFunc("POST /sites/{id}/pin", favoriteHandler.SitePin)
+	mux.HandleFunc("POST /sites/{id}/rating", favoriteHandler.SiteRating)
+
+	// Pages
+	mux.HandleFunc("GET /pages", pageHandler.List)
+	mux.HandleFunc("POST /pages", pageHandler.Create)
+	mux.HandleFunc("GET /pages/{id}/edit", pageHandler.Edit)
+	mux.HandleFunc("PUT /pages/{id}", pageHandler.Update)
+	mux.HandleFunc("DELETE /pages/{id}", pageHandler.Delete)
+	mux.HandleFunc("GET /pages/{id}/visit", pageHandler.Visit)
+	mux.HandleFunc("POST /pages/quick-add", pageHandler.QuickAdd)
+	mux.HandleFunc("POST /pages/{id}/archive", pageHandler.Archive)
+	mux.HandleFunc("GET /pages/{id}/archive", pageHandler.ShowArchive)
+	mux.HandleFunc("GET /pages/{id}/archive/raw", pageHandler.RawArchive)
+	mux.HandleFunc("POST /pages/{id}/external-archive", archiveProviderHandler.Lookup)
+	mux.HandleFunc("POST /pages/{id}/external-archive/save", archiveProviderHandler.Save)
+	mux.HandleFunc("GET /pages/{id}/read", pageHandler.Read)
+	mux.HandleFunc("POST /pages/{id}/read", pageHandler.Extract)
+	mux.HandleFunc("POST /pages/{id}/watch", watchHandler.Watch)
+	mux.HandleFunc("DELETE /pages/{id}/watch", watchHandler.Unwatch)
+	mux.HandleFunc("POST /pages/{id}/check", watchHandler.Check)
+	mux.HandleFunc("GET /pages/{id}/changes", watchHandler.Changes)
+	mux.HandleFunc("POST /pages/{id}/changes/ack", watchHandler.Acknowledge)
+	mux.HandleFunc("POST /pages/{id}/status", queueHandler.SetStatus)
+	mux.HandleFunc("GET /pages/{id}/status", queueHandler.History)
+	mux.HandleFunc("GET /pages/{id}/notes", noteHandler.PageNote)
+	mux.HandleFunc("PUT /pages/{id}/notes", noteHandler.SavePageNote)
+	mux.HandleFunc("GET /pages/{id}/highlights", highlightHandler.PageHighlights)
+	mux.HandleFunc("POST /pages/{id}/highlights", highlightHandler.Create)
+	mux.HandleFunc("GET /pages/{id}/highlights/export", highlightHandler.PageExport)
+	mux.HandleFunc("POST /pages/{id}/favorite", favoriteHandler.PageFavorite)
+	mux.HandleFunc("POST /pages/{id}/pin", favoriteHandler.PagePin)
+	mux.HandleFunc("POST /pages/{id}/rating", favoriteHandler.PageRating)
+
+	// Notes
+	mux.HandleFunc("POST /notes/revisions/{id}/restore", noteHandler.Restore)
+
+	// Highlights
+	mux.HandleFunc("GET /highlights", highlightHandler.List)
+	mux.HandleFunc("GET /highlights/export", highlightHandler.Export)
+	mux.HandleFunc("GET /highlights/{id}/edit", highlightHandler.Edit)
+	mux.HandleFunc("PUT /highlights/{id}", highlightHandler.Update)
+	mux.HandleFunc("DELETE /highlights/{id}", highlightHandler.Delete)
+
+	// Tags
+	mux.HandleFunc("GET /tags", tagHandler.List)
+	mux.HandleFunc("POST /tags", tagHandler.Create)
+	mux.HandleFunc("GET /tags/{id}/edit", tagHandler.Edit)
+	mux.HandleFunc("PUT /tags/{id}", tagHandler.Rename)
+	mux.HandleFunc("DELETE /tags/{id}", tagHandler.Delete)
+	mux.HandleFunc("POST /tags/{id}/merge", tagHandler.Merge)
+	mux.HandleFunc("POST /tags/{id}/aliases", tagHandler.AddAlias)
+	mux.HandleFunc("DELETE /tags/{id}/aliases/{alias}", tagHandler.DeleteAlias)
+	mux.HandleFunc("GET /tags/{id}/items", tagHandler.Items)
+
+	// Reading queue
+	mux.HandleFunc("GET /queue", queueHandler.Queue)
+
+	// Saved searches
+	mux.HandleFunc("GET /searches", savedSearchHandler.List)
+	mux.HandleFunc("POST /searches", savedSearchHandler.Create)
+	mux.HandleFunc("GET /searches/nav", savedSearchHandler.Nav)
+	mux.HandleFunc("GET /searches/{id}", savedSearchHandler.Open)
+	mux.HandleFunc("DELETE /searches/{id}", savedSearchHandler.Delete)
+	mux.HandleFunc("GET /searches/{id}/feed", savedSearchHandler.Feed)
+
+	// Collections
+	mux.HandleFunc("GET /collections", collectionHandler.List)
+	mux.HandleFunc("POST /collections", collectionHandler.Create)
+	mux.HandleFunc("GET /collections/{id}", collectionHandler.Show)
+	mux.HandleFunc("PUT /collections/{id}", collectionHandler.Update)
+	mux.HandleFunc("DELETE /collections/{id}", collectionHandler.Delete)
+	mux.HandleFunc("POST /collections/{id}/items", collectionHandler.AddItem)
+	mux.HandleFunc("PUT /collections/{id}/items/{item}", collectionHandler.UpdateItem)
+	mux.HandleFunc("DELETE /collections/{id}/items/{item}", collectionHandler.RemoveItem)
+	mux.HandleFunc("POST /collections/{id}/order", collectionHandler.Reorder)
+	mux.HandleFunc("POST /collections/{id}/share", collectionHandler.Share)
+	mux.HandleFunc("DELETE /collections/{id}/share", collectionHandler.Unshare)
+	mux.HandleFunc("GET /shared/{token}", collectionHandler.Shared)
+
+	// Export
+	mux.HandleFunc("GET /export", exportHandler.Export)
+
+	// JSON API for the bookmarks command
+	mux.HandleFunc("GET /api/pages", apiHandler.ListPages)
+	mux.HandleFunc("POST /api/pages", apiHandler.AddPage)
+	mux.HandleFunc("PUT /api/pages/{id}", apiHandler.EditPage)
+	mux.HandleFunc("GET /api/categories", apiHandler.ListCategories)
+	mux.HandleFunc("GET /api/sites", apiHandler.ListSites)
+	mux.HandleFunc("PUT /api/sites/{id}", apiHandler.EditSite)
+	mux.HandleFunc("GET /api/search", apiHandler.Search)
+	mux.HandleFunc("POST /api/import", apiHandler.Import)
+	mux.HandleFunc("POST /api/check-links", apiHandler.CheckLinks)
+	mux.HandleFunc("POST /api/tags/merge", apiHandler.MergeTags)
+
+	// Trash
+	mux.HandleFunc("GET /trash", trashHandler.List)
+	mux.HandleFunc("POST /trash/sites/{id}/restore", trashHandler.RestoreSite)
+	mux.HandleFunc("POST /trash/pages/{id}/restore", trashHandler.RestorePage)
+	mux.HandleFunc("DELETE /trash/sites/{id}", trashHandler.PurgeSite)
+	mux.HandleFunc("DELETE /trash/pages/{id}", trashHandler.PurgePage)
+	mux.HandleFunc("POST /trash/empty", trashHandler.Empty)
+
+	// Activity
+	mux.HandleFunc("GET /activity", activityHandler.List)
+
+	// Undo
+	mux.HandleFunc("POST /undo/{token}", undoHandler.Undo)
+
+	// Backups, only of SQLite. They hand out the whole database, so they
+	// are only served with ADMIN_TOKEN set, as the password asked for.
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if backups != nil && adminToken == "" {
+		log.Printf("ADMIN_TOKEN is not set; the /admin/backups routes are disabled")
+	} else if backups != nil {
+		mux.HandleFunc("GET /admin/backups", handlers.RequireAdmin(adminToken, backupHandler.List))
+		mux.HandleFunc("POST /admin/backups", handlers.RequireAdmin(adminToken, backupHandler.Create))
+		mux.HandleFunc("GET /admin/backups/{name}", handlers.RequireAdmin(adminToken, backupHandler.Download))
+	}
+
+	// Start server
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	log.Printf("Starting server on :%s", port)
+	if err := http.ListenAndServe(":"+port, mux); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+// envInt reads a non-negative number from the environment, or returns def
+// when it is not set.
+func envInt(name string, def int) int {
+	str := os.Getenv(name)
+	if str == "" {
+		return def
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 0 {
+		log.Fatalf("Invalid %s: must be a number of at least 0", name)
+	}
+	return n
+}
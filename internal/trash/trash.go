@@ -0,0 +1,85 @@
+package trash
+
+import (
+	"log"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// DefaultRetention is how long deleted sites and pages stay in the trash.
+const DefaultRetention = 30 * 24 * time.Hour
+
+// purgeInterval is how often the trash is checked for expired entries.
+const purgeInterval = time.Hour
+
+// Actor is who expired entries are attributed to in the activity log.
+const Actor = "trash"
+
+// Purger permanently deletes sites and pages once they have been in the
+// trash longer than the retention period, along with their page snapshots.
+type Purger struct {
+	repo      repository.Store
+	archiver  *archive.Archiver
+	retention time.Duration
+}
+
+func New(repo repository.Store, archiver *archive.Archiver, retention time.Duration) *Purger {
+	return &Purger{repo: repo, archiver: archiver, retention: retention}
+}
+
+// Retention is how long entries stay in the trash.
+func (p *Purger) Retention() time.Duration {
+	return p.retention
+}
+
+// Start purges expired entries now and then once per hour until the process
+// exits.
+func (p *Purger) Start() {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+		for {
+			if err := p.PurgeExpired(); err != nil {
+				log.Printf("Trash purge failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// PurgeExpired permanently deletes everything older than the retention
+// period.
+func (p *Purger) PurgeExpired() error {
+	ids, err := p.repo.As(Actor).PurgeTrash(time.Now().Add(-p.retention))
+	p.removeArchives(ids)
+	return err
+}
+
+func (p *Purger) PurgeSite(id int64) error {
+	ids, err := p.repo.PurgeSite(id)
+	p.removeArchives(ids)
+	return err
+}
+
+func (p *Purger) PurgePage(id int64) error {
+	if err := p.repo.PurgePage(id); err != nil {
+		return err
+	}
+	p.removeArchives([]int64{id})
+	return nil
+}
+
+// Empty permanently deletes everything in the trash.
+func (p *Purger) Empty() error {
+	ids, err := p.repo.EmptyTrash()
+	p.removeArchives(ids)
+	return err
+}
+
+func (p *Purger) removeArchives(pageIDs []int64) {
+	for _, id := range pageIDs {
+		p.archiver.Remove(id)
+	}
+}
@@ -0,0 +1,73 @@
+package undo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultWindow is how long a destructive action can be undone.
+const DefaultWindow = 15 * time.Second
+
+// ErrExpired is returned for an undo token that is unknown, already used or
+// past its window.
+var ErrExpired = errors.New("this action can no longer be undone")
+
+// Store keeps the reverse of recent destructive actions, each behind a
+// random token, for a short window.
+type Store struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]entry
+}
+
+type entry struct {
+	revert  func() error
+	expires time.Time
+}
+
+func New(window time.Duration) *Store {
+	return &Store{window: window, pending: map[string]entry{}}
+}
+
+// Window is how long each action stays undoable.
+func (s *Store) Window() time.Duration {
+	return s.window
+}
+
+// Add registers revert as the way to undo an action that just happened and
+// returns the token that triggers it.
+func (s *Store) Add(revert func() error) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for t, e := range s.pending {
+		if now.After(e.expires) {
+			delete(s.pending, t)
+		}
+	}
+	s.pending[token] = entry{revert: revert, expires: now.Add(s.window)}
+	return token, nil
+}
+
+// Undo reverts the action behind token. Each token works at most once.
+func (s *Store) Undo(token string) error {
+	s.mu.Lock()
+	e, ok := s.pending[token]
+	delete(s.pending, token)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(e.expires) {
+		return ErrExpired
+	}
+	return e.revert()
+}
@@ -0,0 +1,118 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lehmann314159/bookmarks/internal/archive"
+	"github.com/lehmann314159/bookmarks/internal/diff"
+	"github.com/lehmann314159/bookmarks/internal/linkcheck"
+	"github.com/lehmann314159/bookmarks/internal/models"
+	"github.com/lehmann314159/bookmarks/internal/reader"
+	"github.com/lehmann314159/bookmarks/internal/repository"
+)
+
+// Actor is who the changes the watcher finds are attributed to in the
+// activity log.
+const Actor = "watcher"
+
+// Watcher periodically refetches watched pages and records a new version
+// whenever their readable text changes. Pages found gone for good are
+// flagged as broken and, given a provider, linked to an external archived
+// copy.
+type Watcher struct {
+	repo     repository.Store
+	provider archive.Provider
+	interval time.Duration
+}
+
+func New(repo repository.Store, provider archive.Provider, interval time.Duration) *Watcher {
+	return &Watcher{repo: repo.As(Actor), provider: provider, interval: interval}
+}
+
+// Start checks every watched page once per interval until the process exits.
+func (w *Watcher) Start() {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			w.checkAll()
+		}
+	}()
+}
+
+func (w *Watcher) checkAll() {
+	pages, err := w.repo.GetWatchedPages()
+	if err != nil {
+		log.Printf("Failed to load watched pages: %v", err)
+		return
+	}
+	for i := range pages {
+		if err := w.Check(&pages[i]); err != nil {
+			log.Printf("Change check for page %d failed: %v", pages[i].ID, err)
+		}
+	}
+}
+
+// Check fetches the page and compares its text against the latest recorded
+// version. The first check of a page only stores a baseline.
+func (w *Watcher) Check(page *models.Page) error {
+	article, err := reader.Fetch(page.URL())
+	if err != nil {
+		status := 0
+		var statusErr *reader.StatusError
+		if errors.As(err, &statusErr) {
+			status = statusErr.StatusCode
+		}
+		if linkcheck.Gone(status, err) {
+			if err := linkcheck.MarkBroken(w.repo, w.provider, page); err != nil {
+				log.Printf("Failed to flag page %d as broken: %v", page.ID, err)
+			}
+		}
+		return err
+	}
+	if page.BrokenAt != nil {
+		if err := w.repo.SetPageBroken(page.ID, false); err != nil {
+			return err
+		}
+	}
+	if err := w.repo.SetPageContent(page.ID, article.Text); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(article.Text))
+	hash := hex.EncodeToString(sum[:])
+
+	latest, err := w.repo.GetLatestPageVersion(page.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return w.repo.AddPageVersion(page.ID, article.Text, hash, "")
+	}
+	if err != nil {
+		return err
+	}
+	if latest.Hash == hash {
+		return w.repo.MarkPageChecked(page.ID)
+	}
+
+	changes := diff.Lines(lines(latest.Text), lines(article.Text))
+	if !diff.Changed(changes) {
+		// Only whitespace between paragraphs moved
+		return w.repo.MarkPageChecked(page.ID)
+	}
+	return w.repo.AddPageVersion(page.ID, article.Text, hash, diff.Format(changes))
+}
+
+func lines(text string) []string {
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}